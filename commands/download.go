@@ -3,7 +3,6 @@ package commands
 import (
 	"context"
 	"fileshare/p2p"
-	"fileshare/p2p/rpc"
 	"os"
 	"os/signal"
 	"time"
@@ -22,9 +21,16 @@ var downloadCmd = &cobra.Command{
 }
 
 func init() {
-	downloadCmd.Flags().StringVarP(&fileHash, "fileHash", "f", "", "hash of file to download")
+	downloadCmd.Flags().StringVarP(&fileHash, "fileHash", "f", "", "hash of file to download i.e sha256:<digest>, legacy md5 hashes are accepted")
 	downloadCmd.Flags().StringVarP(&dlPath, "download", "p", "", "directory to download files to")
+	downloadCmd.Flags().IntVarP(&window, "window", "w", 8, "maximum fragments to download at once")
+	downloadCmd.Flags().IntVarP(&peerRequests, "peerRequests", "r", 2, "maximum fragments to download at once from a single peer")
+	downloadCmd.Flags().Int64VarP(&downloadLimit, "downloadLimit", "", 0, "download limit from all peers in KB/s, 0 means no limit")
+	downloadCmd.Flags().StringVarP(&decryptionKey, "key", "", "", "passphrase or private key decrypting an encrypted file as it is downloaded")
 	downloadCmd.MarkFlagRequired("fileHash")
+	addNetworkFlags(downloadCmd)
+	addPeerExchangeFlags(downloadCmd)
+	addDHTFlags(downloadCmd)
 }
 
 func downloadFile(cmd *cobra.Command, args []string) {
@@ -34,8 +40,24 @@ func downloadFile(cmd *cobra.Command, args []string) {
 		log.Errorf("Failed to create db. Reason: %s", err)
 		return
 	}
-	request := p2p.NewRequest(dlPath, db, p2p.SimplePeerDiscovery{Payload: p2p.DiscoveryPayload{}, ClientFactory: rpc.NewClient},
-		p2p.NewHighAvailabilityDownloader(time.Second*1))
+	factory := clientFactory()
+	// Peers are joined to the DHT through the peers found, which are tracked by the book
+	book := p2p.NewPeerBook(0)
+	if useDHT {
+		factory = book.Track(factory)
+	}
+	resolver := peerResolver(p2p.SimplePeerDiscovery{Payload: p2p.DiscoveryPayload{Transport: transport},
+		ClientFactory: factory, SwarmKey: swarmKey}, nil)
+	request := p2p.NewRequest(dlPath, db, resolver, p2p.NewHighAvailabilityDownloader(time.Second*1, peerRequests), window)
+	setPeerExchange(request, factory)
+	if useDHT {
+		// The downloader doesn't serve the DHT, it only looks up providers
+		dht, network := newDHT(p2p.DHTContact{Name: hostname}, book)
+		defer network.Close()
+		request.SetProviderFinder(dht, factory)
+	}
+	request.SetDownloadLimit(downloadLimit * 1024)
+	request.SetDecryptionKey(decryptionKey)
 	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -0,0 +1,27 @@
+package commands
+
+import (
+	"fileshare/p2p"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/cobra"
+)
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "creates a key pair for receiving encrypted files",
+	Long:  "Creates a key pair, publishers encrypt files for the public key and only the private key can decrypt them",
+	Run:   generateKeyPair,
+}
+
+func generateKeyPair(cmd *cobra.Command, args []string) {
+	publicKey, privateKey, err := p2p.GenerateKeyPair()
+	if err != nil {
+		log.Errorf("Failed to create key pair. Reason: %s", err)
+		return
+	}
+	fmt.Printf("Public key:  %s\n", publicKey)
+	fmt.Printf("Private key: %s\n", privateKey)
+}
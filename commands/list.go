@@ -3,7 +3,6 @@ package commands
 import (
 	"context"
 	"fileshare/p2p"
-	"fileshare/p2p/rpc"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -20,6 +19,8 @@ var listCmd = &cobra.Command{
 
 func init() {
 	listCmd.Flags().BoolVarP(&localOnly, "local", "l", false, "list only local files")
+	addNetworkFlags(listCmd)
+	addPeerExchangeFlags(listCmd)
 }
 
 func listFiles(cmd *cobra.Command, args []string) {
@@ -36,12 +37,16 @@ func listFiles(cmd *cobra.Command, args []string) {
 	} else {
 		// Create a remote request
 		log.Info("Show files available in network")
+		factory := clientFactory()
 		request := p2p.NewRequest(
 			"",
 			db,
-			p2p.SimplePeerDiscovery{Payload: p2p.DiscoveryPayload{}, ClientFactory: rpc.NewClient},
-			p2p.NewHighAvailabilityDownloader(1*time.Second),
+			peerResolver(p2p.SimplePeerDiscovery{Payload: p2p.DiscoveryPayload{Transport: transport},
+				ClientFactory: factory, SwarmKey: swarmKey}, nil),
+			p2p.NewHighAvailabilityDownloader(1*time.Second, 0),
+			1,
 		)
+		setPeerExchange(request, factory)
 		ff = request.List(context.Background())
 	}
 	p2p.PrintFiles(ff)
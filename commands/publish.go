@@ -1,8 +1,10 @@
 package commands
 
 import (
+	"errors"
 	"fileshare/p2p"
 
+	"github.com/jinzhu/gorm"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 
@@ -17,6 +19,13 @@ var publishCmd = &cobra.Command{
 
 func init() {
 	publishCmd.Flags().StringVarP(&filePath, "filePath", "f", "", "path of file to publish")
+	publishCmd.Flags().StringVarP(&hashAlgorithm, "hash", "", string(p2p.DefaultHashAlgorithm), "hash algorithm identifying the file sha256|blake3")
+	publishCmd.Flags().Int64VarP(&chunkSize, "chunk-size", "", 0, "size of file fragments in bytes, chosen from the file size when not set")
+	publishCmd.Flags().StringSliceVarP(&allow, "allow", "", nil, "peers allowed to download the file by their certificate name, or groups by their certificate unit i.e group:finance")
+	publishCmd.Flags().BoolVarP(&public, "public", "", false, "allow all peers to download the file")
+	publishCmd.Flags().BoolVarP(&encrypt, "encrypt", "", false, "publish an encrypted copy of the file, peers only hold and serve the encrypted copy")
+	publishCmd.Flags().StringVarP(&passphrase, "passphrase", "", "", "passphrase deriving the key of an encrypted file")
+	publishCmd.Flags().StringVarP(&recipient, "recipient", "", "", "public key of the recipient of an encrypted file, created with keygen")
 	publishCmd.MarkFlagRequired("filePath")
 	rootCmd.MarkFlagFilename("filePath")
 }
@@ -28,10 +37,48 @@ func publishFile(cmd *cobra.Command, args []string) {
 		log.Errorf("Failed to create db. Reason: %s", err)
 		return
 	}
+	// Access is only changed when requested, files published before keep their access
+	var access []string
+	if public && len(allow) > 0 {
+		log.Error("A file can't be public and allowed only to some peers")
+		return
+	} else if public {
+		access = []string{}
+	} else if len(allow) > 0 {
+		access = allow
+	}
 	fs := afero.NewOsFs()
-	err = p2p.Publish(fs, db, filePath)
+	if encrypt {
+		err = publishEncrypted(fs, db, access)
+	} else if passphrase != "" || recipient != "" {
+		log.Error("A passphrase or a recipient can only be used with encrypt")
+		return
+	} else {
+		err = p2p.Publish(fs, db, filePath, p2p.HashAlgorithm(hashAlgorithm), chunkSize, access)
+	}
 	if err != nil {
 		log.Errorf("Failed to publish file %s. Reason: %s", filePath, err)
 		return
 	}
 }
+
+// publishEncrypted publishes an encrypted copy of the file, with a key derived from the passphrase or the recipient
+func publishEncrypted(fs afero.Fs, db *gorm.DB, access []string) error {
+	var key []byte
+	var encryption string
+	var err error
+	switch {
+	case passphrase != "" && recipient != "":
+		return errors.New("a file is encrypted either with a passphrase or for a recipient")
+	case passphrase != "":
+		key, encryption, err = p2p.NewPassphraseKey(passphrase)
+	case recipient != "":
+		key, encryption, err = p2p.NewRecipientKey(recipient)
+	default:
+		return errors.New("encrypt requires a passphrase or a recipient")
+	}
+	if err != nil {
+		return err
+	}
+	return p2p.PublishEncrypted(fs, db, filePath, p2p.HashAlgorithm(hashAlgorithm), chunkSize, access, key, encryption)
+}
@@ -1,8 +1,14 @@
 package commands
 
 import (
+	"errors"
+	"fileshare/p2p"
+	"fileshare/p2p/http"
+	"fileshare/p2p/rpc"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -22,6 +28,63 @@ var (
 	listenAddress string
 	listenTimout  time.Duration
 	seedPartial   bool
+	hashAlgorithm string
+	window        int
+	peerRequests  int
+	chunkSize     int64
+	// Rate limits in KB/s
+	uploadLimit     int64
+	peerUploadLimit int64
+	downloadLimit   int64
+	tlsConfig       rpc.TLSConfig
+	swarmKey        string
+	swarmKeyFile    string
+	allow           []string
+	public          bool
+	// Encryption of published files, the key is derived from a passphrase or a recipient's public key
+	encrypt    bool
+	passphrase string
+	recipient  string
+	// decryptionKey is a passphrase or private key decrypting downloaded files
+	decryptionKey string
+	// transport is how files are served and downloaded, see rpcTransport and httpTransport
+	transport string
+	// callPolicy bounds and retries calls to peers
+	callPolicy = p2p.DefaultCallPolicy
+	// Static peers, reached without LAN discovery
+	peers         []string
+	bootstrapFile string
+	// discovery is how peers are discovered in the LAN, see lanDiscovery, mdnsDiscovery and noDiscovery
+	discovery string
+	// maxPeers bounds the peers of a request once peers are exchanged, 0 disables peer exchange
+	maxPeers int
+	// useDHT locates providers of files with the DHT, seeding nodes provide their files on it
+	useDHT bool
+	// trackerURL is a tracker peers are discovered with, seeding nodes announce their files to it
+	trackerURL string
+	// Tracker served by the tracker command
+	trackerPort int
+	trackerTTL  time.Duration
+	// Exported torrents
+	torrentPath    string
+	torrentVersion int
+	trackers       []string
+)
+
+// Transports serving files, peers only see peers using the same transport
+const (
+	rpcTransport  = p2p.DefaultTransport
+	httpTransport = "http"
+)
+
+// Discovery of peers in the LAN
+const (
+	// lanDiscovery broadcasts fileshare payloads, only seen by fileshare nodes
+	lanDiscovery = "lan"
+	// mdnsDiscovery advertises and browses the _fileshare._tcp DNS-SD service
+	mdnsDiscovery = "mdns"
+	// noDiscovery only reaches static peers
+	noDiscovery = "none"
 )
 
 var hostname, _ = os.Hostname()
@@ -35,6 +98,18 @@ var rootCmd = &cobra.Command{
 			log.SetLevel(log.DebugLevel)
 			log.Debug("Debugging mode set")
 		}
+		if err := loadSwarmKey(); err != nil {
+			log.Fatalf("Failed to load swarm key. Reason: %s", err)
+		}
+		if transport != rpcTransport && transport != httpTransport {
+			log.Fatalf("Unknown transport %s, use %s or %s", transport, rpcTransport, httpTransport)
+		}
+		if discovery != lanDiscovery && discovery != mdnsDiscovery && discovery != noDiscovery {
+			log.Fatalf("Unknown discovery %s, use %s, %s or %s", discovery, lanDiscovery, mdnsDiscovery, noDiscovery)
+		}
+		if useDHT && transport != rpcTransport {
+			log.Fatalf("The DHT is only served with the %s transport", rpcTransport)
+		}
 	},
 }
 
@@ -45,6 +120,9 @@ func init() {
 	rootCmd.AddCommand(seedCmd)
 	rootCmd.AddCommand(downloadCmd)
 	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(keygenCmd)
+	rootCmd.AddCommand(torrentCmd)
+	rootCmd.AddCommand(trackerCmd)
 	// Add db flag, database is required for all commands to work
 	rootCmd.PersistentFlags().StringVarP(&dbPath, "db", "d", "fileshare.db", "database path")
 	rootCmd.MarkFlagFilename("db")
@@ -52,6 +130,109 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 }
 
+// addNetworkFlags adds flags securing connections between peers to a command
+func addNetworkFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&tlsConfig.CertFile, "tlsCert", "", "", "TLS certificate of this node, issued for its name")
+	cmd.Flags().StringVarP(&tlsConfig.KeyFile, "tlsKey", "", "", "TLS key of this node")
+	cmd.Flags().StringVarP(&tlsConfig.CAFile, "tlsCA", "", "", "CA certificate verifying remote peers, system certificates are used when not set")
+	cmd.Flags().BoolVarP(&tlsConfig.Mutual, "mutualTLS", "", false, "require both peers to present a certificate signed by the CA")
+	cmd.MarkFlagFilename("tlsCert")
+	cmd.MarkFlagFilename("tlsKey")
+	cmd.MarkFlagFilename("tlsCA")
+	cmd.Flags().StringVarP(&swarmKey, "swarmKey", "", "", "pre-shared key of a private swarm, only peers holding it are seen")
+	cmd.Flags().StringVarP(&swarmKeyFile, "swarmKeyFile", "", "", "file holding the pre-shared key of a private swarm")
+	cmd.MarkFlagFilename("swarmKeyFile")
+	cmd.Flags().StringVarP(&transport, "transport", "", rpcTransport, "transport serving files rpc|http, only peers using the same transport are seen")
+	cmd.Flags().DurationVarP(&callPolicy.ListTimeout, "timeout", "", p2p.DefaultCallPolicy.ListTimeout, "timeout of listing the files of a peer, 0 means no timeout")
+	cmd.Flags().DurationVarP(&callPolicy.FragmentsTimeout, "fragmentsTimeout", "", p2p.DefaultCallPolicy.FragmentsTimeout, "timeout of checking the fragments available on a peer, 0 means no timeout")
+	cmd.Flags().DurationVarP(&callPolicy.DownloadTimeout, "downloadTimeout", "", p2p.DefaultCallPolicy.DownloadTimeout, "timeout of a fragment download from a peer that receives no data, 0 means no timeout")
+	cmd.Flags().IntVarP(&callPolicy.Retries, "retries", "", p2p.DefaultCallPolicy.Retries, "retries of calls to peers failing with transient errors")
+	cmd.Flags().StringSliceVarP(&peers, "peer", "", nil, "host:port of a peer reached without LAN discovery, can be repeated")
+	cmd.Flags().StringVarP(&bootstrapFile, "bootstrap", "", "", "file of peers reached without LAN discovery, a host:port per line")
+	cmd.MarkFlagFilename("bootstrap")
+	cmd.Flags().StringVarP(&discovery, "discovery", "", lanDiscovery, "discovery of peers in the LAN lan|mdns|none, mdns nodes are seen by DNS-SD tools")
+	cmd.Flags().StringVarP(&trackerURL, "tracker", "", "", "URL of a tracker peers are discovered with, i.e http://tracker:7980")
+}
+
+// clientFactory creates clients of the chosen transport, peers found again by discovery reuse their connection
+func clientFactory() p2p.CreateClient {
+	factory := rpc.ClientFactory(tlsConfig, swarmKey, callPolicy)
+	if transport == httpTransport {
+		factory = http.ClientFactory(tlsConfig, swarmKey, callPolicy)
+	}
+	return p2p.NewClientPool(factory).Get
+}
+
+// peerResolver discovers peers in the LAN as chosen, adding the static peers and the tracker when there are any. Seeding
+// nodes announce their files to the tracker, files is nil for nodes that don't seed
+func peerResolver(lan p2p.SimplePeerDiscovery, files func() []p2p.TrackerFile) p2p.PeerResolver {
+	var resolvers p2p.MultiResolver
+	switch discovery {
+	case lanDiscovery:
+		resolvers = append(resolvers, lan)
+	case mdnsDiscovery:
+		resolvers = append(resolvers, p2p.MDNSResolver{Payload: lan.Payload, ClientFactory: lan.ClientFactory, SwarmKey: lan.SwarmKey})
+	}
+	if len(peers) > 0 || bootstrapFile != "" {
+		resolvers = append(resolvers, p2p.StaticPeerResolver{Peers: peers, BootstrapFile: bootstrapFile, ClientFactory: lan.ClientFactory})
+	}
+	if trackerURL != "" {
+		resolvers = append(resolvers, p2p.TrackerResolver{URL: trackerURL, Payload: lan.Payload, FileHash: fileHash, Files: files,
+			ClientFactory: lan.ClientFactory, SwarmKey: lan.SwarmKey})
+	}
+	if len(resolvers) == 1 {
+		return resolvers[0]
+	}
+	return resolvers
+}
+
+// addPeerExchangeFlags adds flags of exchanging peers with discovered peers to a command sending requests
+func addPeerExchangeFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&maxPeers, "maxPeers", "", 50, "most peers to use, peers learned from other peers aren't added beyond it. 0 disables peer exchange")
+}
+
+// setPeerExchange exchanges peers with the discovered peers of a request, unless it was disabled
+func setPeerExchange(request *p2p.Request, factory p2p.CreateClient) {
+	if maxPeers > 0 {
+		request.SetPeerExchange(factory, maxPeers)
+	}
+}
+
+// addDHTFlags adds flags of locating providers of files with the DHT to a command
+func addDHTFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&useDHT, "dht", "", false, "locate providers of files with a Kademlia DHT, rpc transport only")
+	cmd.Flags().DurationVarP(&callPolicy.DHTTimeout, "dhtTimeout", "", p2p.DefaultCallPolicy.DHTTimeout, "timeout of a DHT request, 0 means no timeout")
+}
+
+// newDHT creates a DHT node joining the DHT through the peers of the book, the network is closed once the command is
+// done
+func newDHT(self p2p.DHTContact, book *p2p.PeerBook) (*p2p.DHT, *rpc.DHTNetwork) {
+	self.ID = p2p.NewDHTKey()
+	network := rpc.NewDHTNetwork(tlsConfig, swarmKey, callPolicy)
+	dht := p2p.NewDHT(self, network)
+	dht.SetPeerBook(book)
+	return dht, network
+}
+
+// loadSwarmKey reads the swarm key from its file, when given
+func loadSwarmKey() error {
+	if swarmKeyFile == "" {
+		return nil
+	}
+	if swarmKey != "" {
+		return errors.New("swarmKey and swarmKeyFile can't be used together")
+	}
+	key, err := ioutil.ReadFile(swarmKeyFile)
+	if err != nil {
+		return err
+	}
+	swarmKey = strings.TrimSpace(string(key))
+	if swarmKey == "" {
+		return fmt.Errorf("swarm key file %s is empty", swarmKeyFile)
+	}
+	return nil
+}
+
 // Execute main root command line
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
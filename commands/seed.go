@@ -10,6 +10,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"fileshare/p2p"
+	"fileshare/p2p/http"
 	"fileshare/p2p/rpc"
 )
 
@@ -25,19 +26,57 @@ func init() {
 	seedCmd.Flags().DurationVarP(&listenTimout, "time", "t", 60*time.Minute, "how long to listen, i.e 10s 30m 1h")
 	seedCmd.Flags().StringVarP(&listenAddress, "address", "a", "", "address to listen")
 	seedCmd.Flags().BoolVarP(&seedPartial, "allowPartial", "s", false, "allow partial files / paused files to be seeded")
+	seedCmd.Flags().Int64VarP(&uploadLimit, "uploadLimit", "", 0, "upload limit to all peers in KB/s, 0 means no limit")
+	seedCmd.Flags().Int64VarP(&peerUploadLimit, "peerUploadLimit", "", 0, "upload limit to every peer in KB/s, 0 means no limit")
 	seedCmd.MarkFlagRequired("address")
+	addNetworkFlags(seedCmd)
+	addDHTFlags(seedCmd)
+}
+
+// peerWatchPeriod is how often a seeding node discovers the peers it sends to peers asking for them
+const peerWatchPeriod = time.Minute
+
+// seeder is a node serving files with one of the transports
+type seeder interface {
+	p2p.Server
+	SetUploadLimits(upload, peerUpload int64)
+	SetTLS(config rpc.TLSConfig)
+	SetSwarmKey(key string)
+	SetPeerBook(book *p2p.PeerBook)
+	TrackerFiles() []p2p.TrackerFile
+}
+
+// newSeeder creates a node serving files with the chosen transport
+func newSeeder() seeder {
+	if transport == httpTransport {
+		return http.NewNode(serviceName, dbPath, verbose)
+	}
+	return rpc.NewNode(serviceName, dbPath, verbose)
 }
 
 func seedFiles(cmd *cobra.Command, args []string) {
-	service := rpc.NewNode(serviceName, dbPath, verbose)
+	service := newSeeder()
+	service.SetUploadLimits(uploadLimit*1024, peerUploadLimit*1024)
+	service.SetTLS(tlsConfig)
+	service.SetSwarmKey(swarmKey)
 	ctx, cancel := context.WithTimeout(context.Background(), listenTimout)
 	// Seed will cancel and stop after listen timeout expires
 	defer cancel()
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
+	// Peers discovered by the node are sent to peers asking for them, so they can reach peers they can't discover
+	book := p2p.NewPeerBook(0)
+	service.SetPeerBook(book)
+	if useDHT {
+		dht, network := newDHT(p2p.DHTContact{Name: serviceName, Addr: listenAddress, Port: port}, book)
+		defer network.Close()
+		service.(*rpc.Node).SetDHT(dht)
+	}
 	// Run seed in a goroutine
-	r := &p2p.SimplePeerDiscovery{Payload: p2p.DiscoveryPayload{Name: serviceName, Addr: listenAddress, Port: port}, ClientFactory: rpc.NewClient}
+	r := peerResolver(p2p.SimplePeerDiscovery{Payload: p2p.DiscoveryPayload{Name: serviceName, Addr: listenAddress, Port: port,
+		Transport: transport}, ClientFactory: book.Track(clientFactory()), SwarmKey: swarmKey}, service.TrackerFiles)
 	go service.Seed(ctx, r, listenAddress, port, seedPartial)
+	go book.Watch(ctx, r, peerWatchPeriod)
 	select {
 	case <-c:
 		cancel()
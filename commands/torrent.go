@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"fileshare/p2p"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/afero"
+
+	"github.com/spf13/cobra"
+)
+
+var torrentCmd = &cobra.Command{
+	Use:   "torrent",
+	Short: "moves files between fileshare and BitTorrent",
+}
+
+var torrentExportCmd = &cobra.Command{
+	Use:   "export <hash>",
+	Short: "exports a complete file to a .torrent file",
+	Long:  "Exports a complete file to a .torrent file, the torrent can be imported by other peers to download the file from fileshare",
+	Args:  cobra.ExactArgs(1),
+	Run:   exportTorrent,
+}
+
+var torrentImportCmd = &cobra.Command{
+	Use:   "import <file.torrent>",
+	Short: "imports a .torrent file exported by fileshare, so it can be downloaded",
+	Args:  cobra.ExactArgs(1),
+	Run:   importTorrent,
+}
+
+func init() {
+	torrentCmd.AddCommand(torrentExportCmd)
+	torrentCmd.AddCommand(torrentImportCmd)
+	torrentExportCmd.Flags().StringVarP(&torrentPath, "output", "o", "", "path of the .torrent file, <file name>.torrent when not set")
+	torrentExportCmd.Flags().IntVarP(&torrentVersion, "version", "", p2p.TorrentV1, "torrent version 1|2")
+	torrentExportCmd.Flags().StringSliceVarP(&trackers, "tracker", "", nil, "announce URLs of BitTorrent trackers")
+	torrentExportCmd.MarkFlagFilename("output")
+	torrentImportCmd.Flags().StringVarP(&dlPath, "download", "p", "", "directory the file will be downloaded to")
+}
+
+func exportTorrent(cmd *cobra.Command, args []string) {
+	db, err := p2p.CreateDatabase(dbPath, verbose)
+	if err != nil {
+		log.Errorf("Failed to create db. Reason: %s", err)
+		return
+	}
+	output := torrentPath
+	if output == "" {
+		hash, _ := p2p.NormalizeHash(args[0])
+		var fm p2p.FileMetaData
+		if db.Where("hash = ?", hash).First(&fm).RecordNotFound() {
+			log.Errorf("File with hash %s doesn't exist", args[0])
+			return
+		}
+		output = fm.Name + ".torrent"
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		log.Errorf("Failed to create %s. Reason: %s", output, err)
+		return
+	}
+	defer f.Close()
+	infoHash, err := p2p.ExportTorrent(afero.NewOsFs(), db, args[0], torrentVersion, trackers, f)
+	if err != nil {
+		log.Errorf("Failed to export torrent. Reason: %s", err)
+		f.Close()
+		os.Remove(output)
+		return
+	}
+	log.Infof("Exported %s (info hash=%s)", output, infoHash)
+}
+
+func importTorrent(cmd *cobra.Command, args []string) {
+	db, err := p2p.CreateDatabase(dbPath, verbose)
+	if err != nil {
+		log.Errorf("Failed to create db. Reason: %s", err)
+		return
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		log.Errorf("Failed to open %s. Reason: %s", args[0], err)
+		return
+	}
+	defer f.Close()
+	fm, err := p2p.ImportTorrent(db, f, dlPath)
+	if err != nil {
+		log.Errorf("Failed to import torrent. Reason: %s", err)
+		return
+	}
+	log.Infof("Imported %s, download it with: fileshare download -p %q -f %s", fm.Name, dlPath, fm.Hash)
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"fileshare/p2p"
+)
+
+var trackerCmd = &cobra.Command{
+	Use:   "tracker",
+	Short: "Runs a tracker, a central index of the peers seeding every file",
+	Long: `Runs a tracker, seeding nodes started with --tracker announce their files to it and nodes downloading files ask it
+for peers. A coordination point for networks where peers can't discover each other, i.e without multicast`,
+	Run: runTracker,
+}
+
+func init() {
+	trackerCmd.Flags().StringVarP(&listenAddress, "address", "a", "", "address to listen, all interfaces when not set")
+	trackerCmd.Flags().IntVarP(&trackerPort, "port", "p", 7980, "port to listen")
+	trackerCmd.Flags().DurationVarP(&trackerTTL, "ttl", "", p2p.DefaultTrackerTTL, "how long peers are indexed after their last announcement")
+	trackerCmd.Flags().StringVarP(&swarmKey, "swarmKey", "", "", "pre-shared key of a private swarm, only peers holding it are served")
+	trackerCmd.Flags().StringVarP(&swarmKeyFile, "swarmKeyFile", "", "", "file holding the pre-shared key of a private swarm")
+	trackerCmd.MarkFlagFilename("swarmKeyFile")
+}
+
+func runTracker(cmd *cobra.Command, args []string) {
+	tracker := p2p.NewTracker(trackerTTL)
+	tracker.SetSwarmKey(swarmKey)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		<-c
+		cancel()
+	}()
+	if err := tracker.Serve(ctx, listenAddress, trackerPort); err != nil {
+		log.Errorf("Failed to serve tracker. Reason: %s", err)
+	}
+}
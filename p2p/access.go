@@ -0,0 +1,73 @@
+package p2p
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GroupPrefix marks an access entry allowing a group of peers instead of a single peer, i.e group:finance
+const GroupPrefix = "group:"
+
+// FileAccess allows a peer or a group of peers to list and download a file, files without access entries are public
+type FileAccess struct {
+	HashID string `gorm:"primary_key"` // This is the hash of the file
+	// Principal is the identity of the allowed peer, or a group prefixed with GroupPrefix
+	Principal string `gorm:"primary_key"`
+}
+
+// Access returns the peers and groups allowed to access a file, an empty list means the file is public
+func Access(db *gorm.DB, fileHash string) []string {
+	var entries []FileAccess
+	db.Where("hash_id = ?", fileHash).Find(&entries)
+	var principals []string
+	for _, e := range entries {
+		principals = append(principals, e.Principal)
+	}
+	return principals
+}
+
+// SetAccess replaces the peers and groups allowed to access a file, an empty list makes the file public
+func SetAccess(db *gorm.DB, fileHash string, principals []string) error {
+	tx := db.Begin()
+	if err := tx.Where("hash_id = ?", fileHash).Delete(&FileAccess{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, p := range principals {
+		p = strings.TrimSpace(p)
+		if p == "" || p == GroupPrefix {
+			continue
+		}
+		log.Debugf("Allowing %s to access file(hash=%s)", p, fileHash)
+		if err := tx.Save(&FileAccess{HashID: fileHash, Principal: p}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// Allowed checks if a peer with an identity and groups is allowed by the access entries of a file, peers without an
+// identity are only allowed to access public files
+func Allowed(principals []string, identity string, groups []string) bool {
+	if len(principals) == 0 {
+		return true
+	}
+	if identity == "" {
+		return false
+	}
+	for _, p := range principals {
+		if p == identity {
+			return true
+		}
+		for _, g := range groups {
+			if p == GroupPrefix+g {
+				return true
+			}
+		}
+	}
+	return false
+}
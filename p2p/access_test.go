@@ -0,0 +1,40 @@
+package p2p
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test files are only accessible by allowed peers and groups, files without access entries are public
+func TestAllowed(t *testing.T) {
+	assert.True(t, Allowed(nil, "", nil))
+	principals := []string{"hr-laptop", "group:finance"}
+	assert.True(t, Allowed(principals, "hr-laptop", nil))
+	assert.True(t, Allowed(principals, "cfo-desktop", []string{"management", "finance"}))
+	assert.False(t, Allowed(principals, "dev-laptop", []string{"dev"}))
+	assert.False(t, Allowed(principals, "", []string{"finance"}))
+	// Identities aren't groups
+	assert.False(t, Allowed(principals, "finance", nil))
+}
+
+// Test access entries are set on publish, kept when republishing and deleted with the file
+func TestPublishAccess(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewOsFs()
+	assert.Nil(t, Publish(fs, db, "file.go", SHA256, 0, []string{"hr-laptop", " group:finance", ""}))
+	var fm FileMetaData
+	assert.False(t, db.Where("file_path = ?", "file.go").First(&fm).RecordNotFound())
+	assert.ElementsMatch(t, []string{"hr-laptop", "group:finance"}, Access(db, fm.Hash))
+	assert.Nil(t, Publish(fs, db, "file.go", SHA256, 0, nil))
+	assert.Len(t, Access(db, fm.Hash), 2)
+	assert.Nil(t, Publish(fs, db, "file.go", SHA256, 0, []string{}))
+	assert.Empty(t, Access(db, fm.Hash))
+	assert.Nil(t, SetAccess(db, fm.Hash, []string{"hr-laptop"}))
+	assert.Nil(t, Delete(db, fm.Name))
+	assert.Empty(t, Access(db, fm.Hash))
+}
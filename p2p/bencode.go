@@ -0,0 +1,155 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// maxBencodeDepth limits the nesting of decoded values, so malicious torrents can't exhaust the stack
+const maxBencodeDepth = 64
+
+// bencode encodes v in the encoding of BitTorrent metainfo files. Supported values are strings, byte slices, integers,
+// lists of values and maps with string keys, which are sorted as required.
+func bencode(w io.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		_, err := fmt.Fprintf(w, "%d:%s", len(v), v)
+		return err
+	case []byte:
+		_, err := fmt.Fprintf(w, "%d:%s", len(v), v)
+		return err
+	case int:
+		_, err := fmt.Fprintf(w, "i%de", v)
+		return err
+	case int64:
+		_, err := fmt.Fprintf(w, "i%de", v)
+		return err
+	case []interface{}:
+		if _, err := io.WriteString(w, "l"); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := bencode(w, item); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "e")
+		return err
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if _, err := io.WriteString(w, "d"); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := bencode(w, k); err != nil {
+				return err
+			}
+			if err := bencode(w, v[k]); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "e")
+		return err
+	default:
+		return fmt.Errorf("Can't bencode %T", v)
+	}
+}
+
+// bencodeBytes encodes v to a byte slice, see bencode
+func bencodeBytes(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	err := bencode(&b, v)
+	return b.Bytes(), err
+}
+
+// bdecode decodes a single bencoded value, strings are decoded as strings, integers as int64, lists as []interface{}
+// and dictionaries as map[string]interface{}
+func bdecode(r io.Reader) (interface{}, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return bdecodeValue(br, 0)
+}
+
+func bdecodeValue(r *bufio.Reader, depth int) (interface{}, error) {
+	if depth > maxBencodeDepth {
+		return nil, errors.New("Bencoded value is nested too deep")
+	}
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c == 'i':
+		s, err := r.ReadString('e')
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(s[:len(s)-1], 10, 64)
+	case c == 'l':
+		list := []interface{}{}
+		for {
+			if next, err := r.Peek(1); err != nil {
+				return nil, err
+			} else if next[0] == 'e' {
+				r.ReadByte()
+				return list, nil
+			}
+			item, err := bdecodeValue(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+	case c == 'd':
+		dict := map[string]interface{}{}
+		for {
+			if next, err := r.Peek(1); err != nil {
+				return nil, err
+			} else if next[0] == 'e' {
+				r.ReadByte()
+				return dict, nil
+			}
+			key, err := bdecodeValue(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, errors.New("Bencoded dictionary keys must be strings")
+			}
+			value, err := bdecodeValue(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			dict[k] = value
+		}
+	case c >= '0' && c <= '9':
+		r.UnreadByte()
+		s, err := r.ReadString(':')
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid bencoded string length %s", s)
+		}
+		var b bytes.Buffer
+		if _, err := io.CopyN(&b, r, n); err != nil {
+			return nil, err
+		}
+		return b.String(), nil
+	default:
+		return nil, fmt.Errorf("Invalid bencoded value starting with %q", c)
+	}
+}
@@ -0,0 +1,27 @@
+package p2p
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test values are encoded with sorted dictionary keys and decoded back
+func TestBencode(t *testing.T) {
+	v := map[string]interface{}{"spam": []interface{}{"a", int64(-3)}, "cow": []byte("moo"), "n": 42}
+	b, err := bencodeBytes(v)
+	assert.Nil(t, err)
+	assert.Equal(t, "d3:cow3:moo1:ni42e4:spaml1:ai-3eee", string(b))
+	decoded, err := bdecode(bytes.NewReader(b))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"spam": []interface{}{"a", int64(-3)}, "cow": "moo", "n": int64(42)}, decoded)
+	_, err = bencodeBytes(map[string]interface{}{"f": 1.5})
+	assert.NotNil(t, err)
+	for _, invalid := range []string{"", "i12", "5:abc", "d1:ae", "di1ei2ee", "x", "-1:", "ixe"} {
+		_, err := bdecode(bytes.NewReader([]byte(invalid)))
+		assert.NotNil(t, err, invalid)
+	}
+	_, err = bdecode(bytes.NewReader(bytes.Repeat([]byte("l"), 100)))
+	assert.NotNil(t, err)
+}
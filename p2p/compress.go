@@ -0,0 +1,174 @@
+package p2p
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is an algorithm compressing fragments while they are transferred
+type Compression string
+
+// Supported compressions
+const (
+	// NoCompression sends data as is
+	NoCompression Compression = ""
+	Zstd          Compression = "zstd"
+	Gzip          Compression = "gzip"
+)
+
+// Compressions lists the supported compressions by preference, clients send it to seeders when requesting fragments
+var Compressions = []Compression{Zstd, Gzip}
+
+// minCompressionSaving is the part of the data compression must save, data that doesn't shrink enough is sent as is
+const minCompressionSaving = 0.05
+
+// maxDecompressedSize limits decompressed data, compressed data never holds more than a fragment
+const maxDecompressedSize = MaxChunkSize
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize), zstd.WithDecoderConcurrency(0))
+)
+
+// CompressionNames returns the names of compressions, as sent by clients
+func CompressionNames(compressions []Compression) []string {
+	var names []string
+	for _, c := range compressions {
+		names = append(names, string(c))
+	}
+	return names
+}
+
+// NegotiateCompression picks the first compression accepted by the client that is supported, NoCompression when
+// there is none. Names are matched without case, as in Accept-Encoding.
+func NegotiateCompression(accepted []string) Compression {
+	for _, name := range accepted {
+		for _, c := range Compressions {
+			if strings.EqualFold(strings.TrimSpace(name), string(c)) {
+				return c
+			}
+		}
+	}
+	return NoCompression
+}
+
+// Compress compresses data with c, data that doesn't shrink is returned as is with NoCompression
+func Compress(c Compression, data []byte) ([]byte, Compression) {
+	var compressed []byte
+	switch c {
+	case Zstd:
+		compressed = zstdEncoder.EncodeAll(data, nil)
+	case Gzip:
+		var b bytes.Buffer
+		w, _ := gzip.NewWriterLevel(&b, gzip.BestSpeed)
+		if _, err := w.Write(data); err != nil || w.Close() != nil {
+			return data, NoCompression
+		}
+		compressed = b.Bytes()
+	default:
+		return data, NoCompression
+	}
+	if float64(len(compressed)) > float64(len(data))*(1-minCompressionSaving) {
+		return data, NoCompression
+	}
+	return compressed, c
+}
+
+// Decompress decompresses data compressed with c
+func Decompress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return data, nil
+	case Zstd:
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		r, err := NewDecompressReader(c, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+}
+
+// NewDecompressReader decompresses data compressed with c as it is read from r
+func NewDecompressReader(c Compression, r io.Reader) (io.ReadCloser, error) {
+	var decompressed io.ReadCloser
+	switch c {
+	case NoCompression:
+		return ioutil.NopCloser(r), nil
+	case Zstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderMaxMemory(maxDecompressedSize), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		decompressed = d.IOReadCloser()
+	case Gzip:
+		d, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		decompressed = d
+	default:
+		return nil, fmt.Errorf("Unsupported compression %s", c)
+	}
+	return &limitedReadCloser{decompressed, maxDecompressedSize}, nil
+}
+
+// limitedReadCloser fails reads past its limit, so compressed data can't expand without end
+type limitedReadCloser struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	// Read a byte past the limit, to tell data ending at the limit from data that is too large
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return 0, errors.New("Decompressed data is too large")
+	}
+	return n, err
+}
+
+// CompressionStats counts the data of a transfer before and after compression, and the time spent compressing
+type CompressionStats struct {
+	Compression Compression
+	Raw         int64
+	Compressed  int64
+	Elapsed     time.Duration
+}
+
+// Add counts a part of the transfer
+func (s *CompressionStats) Add(raw, compressed int, elapsed time.Duration) {
+	s.Raw += int64(raw)
+	s.Compressed += int64(compressed)
+	s.Elapsed += elapsed
+}
+
+// Ratio returns how many times the data shrank
+func (s CompressionStats) Ratio() float64 {
+	if s.Compressed == 0 {
+		return 1
+	}
+	return float64(s.Raw) / float64(s.Compressed)
+}
+
+func (s CompressionStats) String() string {
+	c := s.Compression
+	if c == NoCompression {
+		c = "none"
+	}
+	return fmt.Sprintf("compression=%s, %d -> %d bytes, ratio=%.2f, time=%s", c, s.Raw, s.Compressed, s.Ratio(), s.Elapsed)
+}
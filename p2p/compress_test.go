@@ -0,0 +1,82 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test compressed data decompresses to the original data
+func TestCompress(t *testing.T) {
+	data := []byte(strings.Repeat("fileshare compresses fragments ", 1000))
+	for _, c := range Compressions {
+		compressed, compression := Compress(c, data)
+		assert.Equal(t, c, compression)
+		assert.True(t, len(compressed) < len(data))
+		decompressed, err := Decompress(c, compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, data, decompressed)
+		r, err := NewDecompressReader(c, bytes.NewReader(compressed))
+		assert.Nil(t, err)
+		decompressed, err = ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, data, decompressed)
+	}
+	_, err := NewDecompressReader("brotli", bytes.NewReader(data))
+	assert.NotNil(t, err)
+}
+
+// Test data that doesn't shrink is sent as is
+func TestCompressIncompressible(t *testing.T) {
+	data := make([]byte, 4096)
+	rand.Read(data)
+	for _, c := range append(Compressions, NoCompression) {
+		compressed, compression := Compress(c, data)
+		assert.Equal(t, NoCompression, compression)
+		assert.Equal(t, data, compressed)
+	}
+}
+
+// Test the first compression accepted by the client that is supported is picked
+func TestNegotiateCompression(t *testing.T) {
+	assert.Equal(t, Zstd, NegotiateCompression([]string{"zstd", "gzip"}))
+	assert.Equal(t, Gzip, NegotiateCompression([]string{"br", " GZIP", "zstd"}))
+	assert.Equal(t, NoCompression, NegotiateCompression([]string{"br", "deflate"}))
+	assert.Equal(t, NoCompression, NegotiateCompression(nil))
+	assert.Equal(t, []string{"zstd", "gzip"}, CompressionNames(Compressions))
+}
+
+// Test decompressed data can't be larger than a fragment
+func TestDecompressLimit(t *testing.T) {
+	for _, size := range []int{maxDecompressedSize, maxDecompressedSize + 1} {
+		compressed, c := Compress(Gzip, make([]byte, size))
+		assert.Equal(t, Gzip, c)
+		r, err := NewDecompressReader(c, bytes.NewReader(compressed))
+		assert.Nil(t, err)
+		data, err := ioutil.ReadAll(r)
+		if size > maxDecompressedSize {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, size, len(data))
+		}
+	}
+}
+
+// Test the stats of a transfer add up
+func TestCompressionStats(t *testing.T) {
+	stats := CompressionStats{Compression: Zstd}
+	assert.Equal(t, 1.0, stats.Ratio())
+	stats.Add(1000, 200, time.Millisecond)
+	stats.Add(1000, 300, time.Millisecond)
+	assert.Equal(t, int64(2000), stats.Raw)
+	assert.Equal(t, int64(500), stats.Compressed)
+	assert.Equal(t, 4.0, stats.Ratio())
+	assert.Equal(t, "compression=zstd, 2000 -> 500 bytes, ratio=4.00, time=2ms", stats.String())
+	assert.Contains(t, CompressionStats{}.String(), "compression=none")
+}
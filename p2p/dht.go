@@ -0,0 +1,580 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DHTKeySize is the size of node IDs and keys of the DHT in bytes
+const DHTKeySize = sha256.Size
+
+const (
+	// dhtK is the size of every bucket of the routing table, and how many nodes store every provider record
+	dhtK = 20
+	// dhtAlpha is how many nodes are queried at once during a lookup
+	dhtAlpha = 3
+	// providerTTL is how long provider records are kept, providers republish them before they expire
+	providerTTL = 30 * time.Minute
+	// republishPeriod is how often providers refresh their routing table and republish their records
+	republishPeriod = 10 * time.Minute
+	// joinRetry is how often a node that didn't join the DHT tries again
+	joinRetry = 10 * time.Second
+	// maxProviderRecords bounds the provider records a node stores for other nodes, the oldest records are dropped for
+	// new ones
+	maxProviderRecords = 10000
+	// maxAddrProviderRecords bounds the provider records a node stores for the nodes of a single address, so a node
+	// can't take over the records of the others
+	maxAddrProviderRecords = 500
+)
+
+// DHTKey is the ID of a node or the key of a file in the DHT, keys are compared by their XOR distance
+type DHTKey [DHTKeySize]byte
+
+// NewDHTKey returns a random key, nodes pick a new ID every time they start
+func NewDHTKey() DHTKey {
+	var key DHTKey
+	if _, err := rand.Read(key[:]); err != nil {
+		log.Fatalf("Failed to create node ID. Reason: %s", err)
+	}
+	return key
+}
+
+// FileKey returns the key of a file in the DHT, the hash of its self describing hash
+func FileKey(fileHash string) DHTKey {
+	return sha256.Sum256([]byte(fileHash))
+}
+
+// ParseDHTKey parses a key sent by a node
+func ParseDHTKey(b []byte) (DHTKey, error) {
+	var key DHTKey
+	if len(b) != DHTKeySize {
+		return key, fmt.Errorf("Invalid key size %d", len(b))
+	}
+	copy(key[:], b)
+	return key, nil
+}
+
+// String returns the first bytes of the key, enough to tell nodes apart in logs
+func (k DHTKey) String() string {
+	return hex.EncodeToString(k[:6])
+}
+
+// commonPrefix returns the number of leading bits two keys share
+func (k DHTKey) commonPrefix(other DHTKey) int {
+	for i := range k {
+		if x := k[i] ^ other[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return DHTKeySize * 8
+}
+
+// closer checks if a is closer to target than b
+func closer(target, a, b DHTKey) bool {
+	for i := range target {
+		if da, db := a[i]^target[i], b[i]^target[i]; da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+// DHTContact is a node of the DHT and the address it serves requests on, nodes that only send requests have no port
+type DHTContact struct {
+	ID   DHTKey
+	Name string
+	Addr string
+	Port int
+}
+
+func (c DHTContact) String() string {
+	return fmt.Sprintf("%s(%s@%s:%d)", c.ID, c.Name, c.Addr, c.Port)
+}
+
+// recordID identifies the records of a node by its ID and address, so nodes claiming the ID of another node don't
+// replace its records
+func (c DHTContact) recordID() string {
+	return c.ID.String() + "@" + net.JoinHostPort(c.Addr, strconv.Itoa(c.Port))
+}
+
+// serves checks if the node serves requests, only these nodes are added to routing tables
+func (c DHTContact) serves() bool {
+	return c.Addr != "" && c.Port > 0 && c.Port <= 65535
+}
+
+// payload returns the address the node serves files on, the DHT is served next to the files
+func (c DHTContact) payload() DiscoveryPayload {
+	return DiscoveryPayload{Name: c.Name, Addr: c.Addr, Port: c.Port}
+}
+
+// DHTNetwork sends DHT requests to nodes, every request introduces the sender so nodes learn about each other
+type DHTNetwork interface {
+	// Ping returns the contact of a node, so nodes known only by their address are added with their ID
+	Ping(ctx context.Context, from, to DHTContact) (DHTContact, error)
+	// FindNode returns the nodes closest to target a node knows
+	FindNode(ctx context.Context, from, to DHTContact, target DHTKey) ([]DHTContact, error)
+	// FindProviders returns the providers of a key a node knows, and the nodes closest to the key it knows
+	FindProviders(ctx context.Context, from, to DHTContact, key DHTKey) ([]DHTContact, []DHTContact, error)
+	// AddProvider stores a record of the sender providing a key on a node
+	AddProvider(ctx context.Context, from, to DHTContact, key DHTKey) error
+}
+
+// DHT locates the nodes providing files with Kademlia. Nodes serving files store provider records of the files they
+// seed on the nodes closest to the keys of the files, so the providers of a file are found asking a few nodes instead
+// of listing the files of every peer
+type DHT struct {
+	self    DHTContact
+	network DHTNetwork
+	table   *routingTable
+
+	// book holds peers the DHT is joined through, nil when the DHT is only joined through nodes sending requests
+	book *PeerBook
+
+	mu sync.Mutex
+	// providers holds the provider records of every key by recordID of the provider
+	providers map[DHTKey]map[string]providerRecord
+	records   int
+	// addrRecords counts the records of the providers of every address
+	addrRecords map[string]int
+}
+
+// providerRecord is a node providing a key until the record expires
+type providerRecord struct {
+	contact DHTContact
+	expires time.Time
+}
+
+// NewDHT creates a DHT node sending requests with network. Nodes that don't serve requests have no port in self, they
+// can still look up providers
+func NewDHT(self DHTContact, network DHTNetwork) *DHT {
+	return &DHT{self: self, network: network, table: &routingTable{self: self.ID},
+		providers: make(map[DHTKey]map[string]providerRecord), addrRecords: make(map[string]int)}
+}
+
+// SetPeerBook joins the DHT through the peers of the book, peers that don't serve the DHT are ignored
+func (d *DHT) SetPeerBook(book *PeerBook) {
+	d.book = book
+}
+
+// Self returns the contact of this node
+func (d *DHT) Self() DHTContact {
+	return d.self
+}
+
+// Size returns the number of nodes in the routing table
+func (d *DHT) Size() int {
+	return d.table.size()
+}
+
+// ================================================================================================================= //
+// *										Requests of remote nodes												   * //
+// ================================================================================================================= //
+
+// HandlePing replies to a ping with the contact of this node
+func (d *DHT) HandlePing(from DHTContact) DHTContact {
+	d.table.update(from)
+	return d.self
+}
+
+// HandleFindNode replies with the nodes closest to target
+func (d *DHT) HandleFindNode(from DHTContact, target DHTKey) []DHTContact {
+	d.table.update(from)
+	return d.table.closest(target, dhtK)
+}
+
+// HandleFindProviders replies with the providers of a key, and the nodes closest to it
+func (d *DHT) HandleFindProviders(from DHTContact, key DHTKey) ([]DHTContact, []DHTContact) {
+	d.table.update(from)
+	providers := d.localProviders(key)
+	if len(providers) > dhtK {
+		providers = providers[:dhtK]
+	}
+	return providers, d.table.closest(key, dhtK)
+}
+
+// HandleAddProvider stores a record of the sender providing a key
+func (d *DHT) HandleAddProvider(from DHTContact, key DHTKey) error {
+	if !from.serves() {
+		return errors.New("Providers must serve files")
+	}
+	d.table.update(from)
+	return d.addProvider(key, from)
+}
+
+// ================================================================================================================= //
+// *										Requests of this node													   * //
+// ================================================================================================================= //
+
+// Bootstrap joins the DHT through peers, known only by their address. Peers that reply are added to the routing table,
+// which is then filled with the nodes closest to this node
+func (d *DHT) Bootstrap(ctx context.Context, peers []DiscoveryPayload) error {
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer DiscoveryPayload) {
+			defer wg.Done()
+			contact, err := d.network.Ping(ctx, d.self, DHTContact{Name: peer.Name, Addr: peer.Addr, Port: peer.Port})
+			if err != nil {
+				log.Debugf("Peer %s doesn't serve the DHT. Reason: %s", peerKey(peer), err)
+				return
+			}
+			d.table.update(contact)
+		}(peer)
+	}
+	wg.Wait()
+	if d.table.size() == 0 {
+		return errors.New("Didn't find any DHT nodes")
+	}
+	// Looking up this node fills the routing table with the nodes closest to it
+	d.lookup(ctx, d.self.ID, false)
+	log.Debugf("Joined the DHT, knows %d nodes", d.table.size())
+	return nil
+}
+
+// Provide stores provider records of a file on the nodes closest to its key, and on this node
+func (d *DHT) Provide(ctx context.Context, fileHash string) error {
+	key := FileKey(fileHash)
+	if d.self.serves() {
+		d.addProvider(key, d.self)
+	}
+	closest, _ := d.lookup(ctx, key, false)
+	if len(closest) == 0 {
+		return errors.New("Didn't find any DHT nodes")
+	}
+	var mu sync.Mutex
+	stored := 0
+	var wg sync.WaitGroup
+	for _, c := range closest {
+		wg.Add(1)
+		go func(c DHTContact) {
+			defer wg.Done()
+			if err := d.network.AddProvider(ctx, d.self, c, key); err != nil {
+				log.Debugf("Failed to store provider record on %s. Reason: %s", c, err)
+				return
+			}
+			mu.Lock()
+			stored++
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	if stored == 0 {
+		return fmt.Errorf("No DHT node stored the provider record of %s", fileHash)
+	}
+	log.Debugf("Provided %s on %d nodes", fileHash, stored)
+	return nil
+}
+
+// FindProviders returns the addresses of the nodes providing a file, joining the DHT through the peer book first when
+// no nodes are known
+func (d *DHT) FindProviders(ctx context.Context, fileHash string) ([]DiscoveryPayload, error) {
+	if d.table.size() == 0 {
+		if err := d.join(ctx); err != nil {
+			return nil, err
+		}
+	}
+	key := FileKey(fileHash)
+	_, found := d.lookup(ctx, key, true)
+	var providers []DiscoveryPayload
+	seen := make(map[string]bool)
+	for _, c := range append(d.localProviders(key), found...) {
+		payload := c.payload()
+		if c.ID == d.self.ID || seen[peerKey(payload)] {
+			continue
+		}
+		seen[peerKey(payload)] = true
+		providers = append(providers, payload)
+	}
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("No providers of %s found", fileHash)
+	}
+	return providers, nil
+}
+
+// Run keeps this node in the DHT until ctx is done. The DHT is joined through the peer book, and every republishPeriod
+// the routing table is refreshed and the files returned by provided are provided again
+func (d *DHT) Run(ctx context.Context, provided func() []string) {
+	for {
+		wait := republishPeriod
+		if err := d.join(ctx); err != nil {
+			log.Debugf("Failed to join the DHT, retrying in %s. Reason: %s", joinRetry, err)
+			wait = joinRetry
+		} else {
+			hashes := provided()
+			for _, hash := range hashes {
+				if err := d.Provide(ctx, hash); err != nil {
+					log.Warnf("Failed to provide %s. Reason: %s", hash, err)
+				}
+			}
+			log.Infof("Provided %d files on the DHT, knows %d nodes", len(hashes), d.table.size())
+		}
+		d.expireProviders()
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// join joins the DHT through the peers of the book discovered most recently
+func (d *DHT) join(ctx context.Context) error {
+	return d.Bootstrap(ctx, d.book.Peers(dhtK))
+}
+
+// lookup queries the nodes closest to target iteratively, until the dhtK closest nodes found all replied or failed.
+// Providers of target are collected when providers is set, the lookup stops once dhtK providers were found. It returns
+// the closest nodes that replied, and the providers found
+func (d *DHT) lookup(ctx context.Context, target DHTKey, providers bool) ([]DHTContact, []DHTContact) {
+	shortlist := d.table.closest(target, dhtK)
+	seen := map[DHTKey]bool{d.self.ID: true}
+	for _, c := range shortlist {
+		seen[c.ID] = true
+	}
+	queried := make(map[DHTKey]bool)
+	var replied, found []DHTContact
+	foundIDs := make(map[DHTKey]bool)
+	for ctx.Err() == nil && len(found) < dhtK {
+		var batch []DHTContact
+		for i := 0; i < len(shortlist) && i < dhtK && len(batch) < dhtAlpha; i++ {
+			if !queried[shortlist[i].ID] {
+				queried[shortlist[i].ID] = true
+				batch = append(batch, shortlist[i])
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+		var mu sync.Mutex
+		failed := make(map[DHTKey]bool)
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			wg.Add(1)
+			go func(c DHTContact) {
+				defer wg.Done()
+				var nodes, provs []DHTContact
+				var err error
+				if providers {
+					provs, nodes, err = d.network.FindProviders(ctx, d.self, c, target)
+				} else {
+					nodes, err = d.network.FindNode(ctx, d.self, c, target)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					log.Debugf("DHT node %s failed. Reason: %s", c, err)
+					d.table.remove(c.ID)
+					failed[c.ID] = true
+					return
+				}
+				d.table.update(c)
+				replied = append(replied, c)
+				for _, p := range provs {
+					if p.serves() && !foundIDs[p.ID] {
+						foundIDs[p.ID] = true
+						found = append(found, p)
+					}
+				}
+				for _, n := range nodes {
+					if n.serves() && !seen[n.ID] {
+						seen[n.ID] = true
+						shortlist = append(shortlist, n)
+					}
+				}
+			}(c)
+		}
+		wg.Wait()
+		// Nodes that failed aren't among the closest nodes anymore
+		alive := shortlist[:0]
+		for _, c := range shortlist {
+			if !failed[c.ID] {
+				alive = append(alive, c)
+			}
+		}
+		shortlist = alive
+		sortByDistance(target, shortlist)
+	}
+	sortByDistance(target, replied)
+	if len(replied) > dhtK {
+		replied = replied[:dhtK]
+	}
+	return replied, found
+}
+
+// addProvider stores a record of a node providing a key, records of nodes providing it again are renewed. The oldest
+// record is dropped when there are too many
+func (d *DHT) addProvider(key DHTKey, provider DHTContact) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := provider.recordID()
+	if _, ok := d.providers[key][id]; !ok {
+		if d.addrRecords[provider.Addr] >= maxAddrProviderRecords {
+			return errors.New("Too many provider records of the address")
+		}
+		if d.records >= maxProviderRecords {
+			d.removeOldestProvider()
+		}
+		d.records++
+		d.addrRecords[provider.Addr]++
+	}
+	records, ok := d.providers[key]
+	if !ok {
+		records = make(map[string]providerRecord)
+		d.providers[key] = records
+	}
+	records[id] = providerRecord{provider, time.Now().Add(providerTTL)}
+	return nil
+}
+
+// removeOldestProvider removes the record that was renewed least recently, d.mu must be held
+func (d *DHT) removeOldestProvider() {
+	var oldest providerRecord
+	var oldestKey DHTKey
+	for key, records := range d.providers {
+		for _, record := range records {
+			if oldest.expires.IsZero() || record.expires.Before(oldest.expires) {
+				oldest, oldestKey = record, key
+			}
+		}
+	}
+	if !oldest.expires.IsZero() {
+		d.removeProvider(oldestKey, oldest.contact)
+	}
+}
+
+// removeProvider removes the record of a node providing a key, d.mu must be held
+func (d *DHT) removeProvider(key DHTKey, provider DHTContact) {
+	records := d.providers[key]
+	if _, ok := records[provider.recordID()]; !ok {
+		return
+	}
+	delete(records, provider.recordID())
+	d.records--
+	if d.addrRecords[provider.Addr]--; d.addrRecords[provider.Addr] == 0 {
+		delete(d.addrRecords, provider.Addr)
+	}
+	if len(records) == 0 {
+		delete(d.providers, key)
+	}
+}
+
+// localProviders returns the providers of a key this node stores records of
+func (d *DHT) localProviders(key DHTKey) []DHTContact {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var providers []DHTContact
+	for _, record := range d.providers[key] {
+		if time.Now().Before(record.expires) {
+			providers = append(providers, record.contact)
+		}
+	}
+	return providers
+}
+
+// expireProviders removes the records that weren't renewed in time
+func (d *DHT) expireProviders() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, records := range d.providers {
+		for _, record := range records {
+			if time.Now().After(record.expires) {
+				d.removeProvider(key, record.contact)
+			}
+		}
+	}
+}
+
+// routingTable holds the nodes known to a node in k-buckets, the bucket of a node is the length of the prefix its ID
+// shares with the ID of this node
+type routingTable struct {
+	self    DHTKey
+	mu      sync.Mutex
+	buckets [DHTKeySize * 8][]DHTContact
+}
+
+// update adds a node or moves it to the end of its bucket as the node seen most recently. Full buckets keep the nodes
+// they have, nodes that stop replying are removed by lookups. Nodes claiming the ID of a known node from another
+// address don't replace it
+func (t *routingTable) update(c DHTContact) {
+	if c.ID == t.self || !c.serves() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := t.self.commonPrefix(c.ID)
+	bucket := t.buckets[i]
+	for j, known := range bucket {
+		if known.ID == c.ID {
+			if known.Addr != c.Addr || known.Port != c.Port {
+				return
+			}
+			bucket = append(bucket[:j], bucket[j+1:]...)
+			break
+		}
+	}
+	if len(bucket) < dhtK {
+		bucket = append(bucket, c)
+	}
+	t.buckets[i] = bucket
+}
+
+// remove removes a node from its bucket
+func (t *routingTable) remove(id DHTKey) {
+	if id == t.self {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := t.self.commonPrefix(id)
+	for j, known := range t.buckets[i] {
+		if known.ID == id {
+			t.buckets[i] = append(t.buckets[i][:j], t.buckets[i][j+1:]...)
+			return
+		}
+	}
+}
+
+// closest returns up to n nodes closest to target
+func (t *routingTable) closest(target DHTKey, n int) []DHTContact {
+	t.mu.Lock()
+	var nodes []DHTContact
+	for _, bucket := range t.buckets {
+		nodes = append(nodes, bucket...)
+	}
+	t.mu.Unlock()
+	sortByDistance(target, nodes)
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// size returns the number of nodes in the table
+func (t *routingTable) size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, bucket := range t.buckets {
+		n += len(bucket)
+	}
+	return n
+}
+
+// sortByDistance sorts nodes by their distance to target, closest first
+func sortByDistance(target DHTKey, nodes []DHTContact) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return closer(target, nodes[i].ID, nodes[j].ID)
+	})
+}
@@ -0,0 +1,175 @@
+package p2p_test
+
+import (
+	"context"
+	"fileshare/p2p"
+	"fileshare/p2p/rpc"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// dhtNode is a DHT node served with gRPC on loopback
+type dhtNode struct {
+	dht     *p2p.DHT
+	server  *grpc.Server
+	network *rpc.DHTNetwork
+}
+
+// startDHTNode serves a new DHT node on a random loopback port
+func startDHTNode(t *testing.T, name string) dhtNode {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	network := rpc.NewDHTNetwork(rpc.TLSConfig{}, "", p2p.DefaultCallPolicy)
+	dht := p2p.NewDHT(p2p.DHTContact{ID: p2p.NewDHTKey(), Name: name, Addr: "127.0.0.1",
+		Port: lis.Addr().(*net.TCPAddr).Port}, network)
+	server := grpc.NewServer()
+	rpc.RegisterDHTServiceServer(server, rpc.NewDHTServer(dht))
+	go server.Serve(lis)
+	return dhtNode{dht, server, network}
+}
+
+func (n dhtNode) stop() {
+	n.server.Stop()
+	n.network.Close()
+}
+
+// payload returns the address the node is discovered with
+func (n dhtNode) payload() p2p.DiscoveryPayload {
+	self := n.dht.Self()
+	return p2p.DiscoveryPayload{Name: self.Name, Addr: self.Addr, Port: self.Port}
+}
+
+// providerNames returns the names of the providers of a file found by a node
+func providerNames(t *testing.T, n dhtNode, fileHash string) []string {
+	providers, err := n.dht.FindProviders(context.Background(), fileHash)
+	assert.Nil(t, err)
+	var names []string
+	for _, p := range providers {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// Test nodes joining through a single node find the providers of files, also once some nodes stopped
+func TestDHT(t *testing.T) {
+	ctx := context.Background()
+	nodes := make([]dhtNode, 40)
+	for i := range nodes {
+		nodes[i] = startDHTNode(t, fmt.Sprintf("node%d", i))
+		defer nodes[i].stop()
+	}
+	// Every node joins through a node that joined before it
+	for i := 1; i < len(nodes); i++ {
+		assert.Nil(t, nodes[i].dht.Bootstrap(ctx, []p2p.DiscoveryPayload{nodes[i/2].payload()}))
+	}
+	hash := "sha256:" + strings.Repeat("ab", 32)
+	assert.Nil(t, nodes[7].dht.Provide(ctx, hash))
+	assert.Nil(t, nodes[31].dht.Provide(ctx, hash))
+	for i, n := range nodes {
+		var expected []string
+		for _, provider := range []int{7, 31} {
+			// Nodes don't find themselves
+			if provider != i {
+				expected = append(expected, fmt.Sprintf("node%d", provider))
+			}
+		}
+		assert.ElementsMatch(t, expected, providerNames(t, n, hash), "node%d", i)
+	}
+	_, err := nodes[0].dht.FindProviders(ctx, "sha256:"+strings.Repeat("cd", 32))
+	assert.NotNil(t, err)
+	// Nodes that don't serve the DHT look up providers through the peers they know, they aren't added to routing tables
+	size := nodes[3].dht.Size()
+	network := rpc.NewDHTNetwork(rpc.TLSConfig{}, "", p2p.DefaultCallPolicy)
+	defer network.Close()
+	client := p2p.NewDHT(p2p.DHTContact{ID: p2p.NewDHTKey(), Name: "client"}, network)
+	book := p2p.NewPeerBook(0)
+	book.Add(nodes[3].payload())
+	client.SetPeerBook(book)
+	assert.ElementsMatch(t, []string{"node7", "node31"}, providerNames(t, dhtNode{dht: client}, hash))
+	assert.Equal(t, size, nodes[3].dht.Size())
+	// Lookups go on with other nodes when nodes stopped
+	for i := 20; i < 30; i++ {
+		nodes[i].stop()
+	}
+	other := "sha256:" + strings.Repeat("ef", 32)
+	assert.Nil(t, nodes[12].dht.Provide(ctx, other))
+	assert.Equal(t, []string{"node12"}, providerNames(t, nodes[35], other))
+	assert.Equal(t, []string{"node12"}, providerNames(t, nodes[1], other))
+}
+
+// Test nodes are known by the address their requests come from rather than the address they claim, and can't take the
+// place of the nodes whose ID they claim
+func TestDHTSender(t *testing.T) {
+	ctx := context.Background()
+	node := startDHTNode(t, "node")
+	defer node.stop()
+	network := rpc.NewDHTNetwork(rpc.TLSConfig{}, "", p2p.DefaultCallPolicy)
+	defer network.Close()
+	honest := p2p.DHTContact{ID: p2p.NewDHTKey(), Name: "honest", Addr: "127.0.0.1", Port: 7000}
+	liar := p2p.DHTContact{ID: p2p.NewDHTKey(), Name: "liar", Addr: "10.9.9.9", Port: 7001}
+	for _, from := range []p2p.DHTContact{honest, liar} {
+		_, err := network.Ping(ctx, from, node.dht.Self())
+		assert.Nil(t, err)
+	}
+	closer, err := network.FindNode(ctx, honest, node.dht.Self(), liar.ID)
+	assert.Nil(t, err)
+	assert.Len(t, closer, 2)
+	assert.Equal(t, liar.ID, closer[0].ID)
+	assert.Equal(t, "127.0.0.1", closer[0].Addr)
+	// Nodes claiming the ID of the honest node from another port don't replace it
+	impostor := honest
+	impostor.Port = 7002
+	_, err = network.Ping(ctx, impostor, node.dht.Self())
+	assert.Nil(t, err)
+	closer, err = network.FindNode(ctx, honest, node.dht.Self(), honest.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 7000, closer[0].Port)
+	hash := "sha256:" + strings.Repeat("ab", 32)
+	assert.Nil(t, network.AddProvider(ctx, honest, node.dht.Self(), p2p.FileKey(hash)))
+	assert.Nil(t, network.AddProvider(ctx, impostor, node.dht.Self(), p2p.FileKey(hash)))
+	providers, _, err := network.FindProviders(ctx, honest, node.dht.Self(), p2p.FileKey(hash))
+	assert.Nil(t, err)
+	assert.Len(t, providers, 2)
+}
+
+// Test every address stores a bounded number of provider records, and the oldest records make room for new ones
+func TestDHTProviderRecords(t *testing.T) {
+	dht := p2p.NewDHT(p2p.DHTContact{ID: p2p.NewDHTKey(), Name: "node", Addr: "127.0.0.1", Port: 7000}, nil)
+	provider := func(addr int) p2p.DHTContact {
+		return p2p.DHTContact{ID: p2p.NewDHTKey(), Name: "provider", Addr: fmt.Sprintf("10.0.0.%d", addr), Port: 7000}
+	}
+	first, renewed, oldest := provider(0), p2p.NewDHTKey(), p2p.NewDHTKey()
+	assert.Nil(t, dht.HandleAddProvider(first, renewed))
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, dht.HandleAddProvider(first, oldest))
+	time.Sleep(time.Millisecond)
+	// Addresses store up to 500 records
+	for i := 2; i < 500; i++ {
+		assert.Nil(t, dht.HandleAddProvider(first, p2p.NewDHTKey()))
+	}
+	assert.NotNil(t, dht.HandleAddProvider(first, p2p.NewDHTKey()))
+	// Renewing records doesn't count
+	assert.Nil(t, dht.HandleAddProvider(first, renewed))
+	time.Sleep(time.Millisecond)
+	// Nodes store up to 10000 records
+	for addr := 1; addr < 20; addr++ {
+		p := provider(addr)
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, dht.HandleAddProvider(p, p2p.NewDHTKey()))
+		}
+	}
+	key := p2p.NewDHTKey()
+	assert.Nil(t, dht.HandleAddProvider(provider(20), key))
+	providers, _ := dht.HandleFindProviders(first, key)
+	assert.Len(t, providers, 1)
+	providers, _ = dht.HandleFindProviders(first, renewed)
+	assert.Len(t, providers, 1)
+	providers, _ = dht.HandleFindProviders(first, oldest)
+	assert.Empty(t, providers)
+}
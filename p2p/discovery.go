@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/gob"
 	"errors"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -17,18 +18,35 @@ type DiscoveryPayload struct {
 	Name string
 	Addr string
 	Port int
+	// Proof proves the peer holds the swarm key, it is empty when the peer isn't in a private swarm
+	Proof []byte
+	// Transport is how the peer serves files, peers that don't announce it use DefaultTransport
+	Transport string
+}
+
+// DefaultTransport serves files with gRPC
+const DefaultTransport = "rpc"
+
+// transport returns the transport the peer serves files with
+func (dp DiscoveryPayload) transport() string {
+	if dp.Transport == "" {
+		return DefaultTransport
+	}
+	return dp.Transport
 }
 
 // SimplePeerDiscovery is a simple discovery package using mdns to locate peers in the LAN network
 type SimplePeerDiscovery struct {
 	Payload       DiscoveryPayload
 	ClientFactory CreateClient
+	// SwarmKey is the pre-shared key of a private swarm, peers that don't hold it are ignored
+	SwarmKey string
 }
 
 // Discover remote nodes on the LAN network using peerdiscovery package
 func (p SimplePeerDiscovery) Discover(ctx context.Context) ([]Client, error) {
 	discoveries, err := peerdiscovery.Discover(peerdiscovery.Settings{Limit: 0, AllowSelf: false, TimeLimit: 2 * time.Second,
-		Payload: encodePayload(p.Payload)})
+		Payload: encodePayload(p.announcement())})
 	if err != nil {
 		log.Errorf("Failed to discover. Reason: %s", err)
 		return nil, err
@@ -38,9 +56,19 @@ func (p SimplePeerDiscovery) Discover(ctx context.Context) ([]Client, error) {
 	}
 	var clients []Client
 	for _, d := range discoveries {
-		payload := decodePayloadFromBytes(d.Payload)
+		payload, err := decodePayloadFromBytes(d.Payload)
 		// Skip entries that didn't return any payload
-		if payload.Name == "" {
+		if err != nil || payload.Name == "" {
+			continue
+		}
+		// Peers outside of the swarm are ignored
+		if p.SwarmKey != "" && !VerifySwarmProof(p.SwarmKey, payload.message(), payload.Proof) {
+			log.Debugf("Ignored %s@%s:%d, it isn't in the swarm", payload.Name, payload.Addr, payload.Port)
+			continue
+		}
+		// Clients only speak a single transport
+		if payload.transport() != p.Payload.transport() {
+			log.Debugf("Ignored %s@%s:%d, it serves files with %s", payload.Name, payload.Addr, payload.Port, payload.transport())
 			continue
 		}
 		log.Debugf("Connecting to %s@%s:%d", payload.Name, payload.Addr, payload.Port)
@@ -57,10 +85,54 @@ func (p SimplePeerDiscovery) Discover(ctx context.Context) ([]Client, error) {
 // Listen allows to be discoverable for 1 hour
 func (p SimplePeerDiscovery) Listen(ctx context.Context, address string) {
 	log.Info("Node is now discoverable")
-	peerdiscovery.Discover(peerdiscovery.Settings{TimeLimit: time.Hour * 1, Payload: encodePayload(p.Payload)})
+	peerdiscovery.Discover(peerdiscovery.Settings{TimeLimit: time.Hour * 1, Payload: encodePayload(p.announcement())})
 	ctx.Done()
 }
 
+// announcement returns the payload sent to other peers, with a proof of the swarm key in a private swarm
+func (p SimplePeerDiscovery) announcement() DiscoveryPayload {
+	payload := p.Payload
+	payload.Proof = nil
+	if p.SwarmKey != "" {
+		payload.Proof = SwarmProof(p.SwarmKey, payload.message())
+	}
+	return payload
+}
+
+// MultiResolver discovers peers with several resolvers, i.e LAN discovery and static peers
+type MultiResolver []PeerResolver
+
+// Discover returns the clients discovered by all resolvers, failing only when all resolvers failed
+func (m MultiResolver) Discover(ctx context.Context) ([]Client, error) {
+	var clients []Client
+	var lastErr error
+	for _, r := range m {
+		found, err := r.Discover(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		clients = append(clients, found...)
+	}
+	if len(clients) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return clients, nil
+}
+
+// Listen makes this node discoverable by all resolvers
+func (m MultiResolver) Listen(ctx context.Context, address string) {
+	var wg sync.WaitGroup
+	for _, r := range m {
+		wg.Add(1)
+		go func(r PeerResolver) {
+			defer wg.Done()
+			r.Listen(ctx, address)
+		}(r)
+	}
+	wg.Wait()
+}
+
 // encodePayload encodes payload into bytes to send over remote network
 func encodePayload(dp DiscoveryPayload) []byte {
 	var buffer bytes.Buffer        // Stand-in for a network connection
@@ -73,14 +145,15 @@ func encodePayload(dp DiscoveryPayload) []byte {
 	return buffer.Bytes()
 }
 
-// decodePayloadFromBytes decodes bytes to receive the base info of the remote peer
-func decodePayloadFromBytes(buf []byte) DiscoveryPayload {
+// decodePayloadFromBytes decodes bytes to receive the base info of the remote peer, other applications may send
+// payloads that can't be decoded
+func decodePayloadFromBytes(buf []byte) (DiscoveryPayload, error) {
 	buffer := bytes.NewBuffer(buf)
 	dec := gob.NewDecoder(buffer) // Will write to network
 	var dp DiscoveryPayload
 	err := dec.Decode(&dp)
 	if err != nil {
-		log.Fatal("decode error:", err)
+		log.Debugf("Failed to decode payload. Reason: %s", err)
 	}
-	return dp
+	return dp, err
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"math"
+	"math/rand"
+	"sort"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -19,25 +21,74 @@ const (
 	HighestAvailability = 1
 )
 
+const (
+	// baseBackoff is how long a peer isn't requested after its first failure, doubled on every following failure
+	baseBackoff = 500 * time.Millisecond
+	// maxBackoff limits how long a peer isn't requested
+	maxBackoff = 30 * time.Second
+	// maxPeerFailures is the amount of failures in a row after which a peer is banned
+	maxPeerFailures = 5
+	// maxPeerRejects is the amount of corrupted fragments after which a peer is banned
+	maxPeerRejects = 3
+	// defaultEndgameFragments is the amount of missing fragments from which fragments are requested from several peers
+	defaultEndgameFragments = 4
+)
+
+// ErrBackoff is returned by NextFragment when missing fragments are only available from peers that recently failed,
+// fragments should be requested again later
+var ErrBackoff = errors.New("Peers with missing fragments are backing off")
+
 type availableFragments struct {
 	FragmentID int
 	Peers      []string
 }
 
-// AvailabilityDownloader downloads the fragment with highest availability picking the peer with least requests
+// peerScore tracks how well a peer behaves during a request
+type peerScore struct {
+	// failures is the amount of failed downloads in a row
+	failures int
+	// rejects is the amount of fragments that failed verification
+	rejects int
+	// latency is a moving average of the time it takes to download a fragment
+	latency time.Duration
+	// backoff is when the peer can be requested again
+	backoff time.Time
+	banned  bool
+}
+
+// AvailabilityDownloader downloads the rarest fragments first, in random order between fragments of the same
+// availability, picking the peer with least requests. Peers that fail are backed off exponentially and banned if they
+// keep failing or sending corrupted data. Once only a few fragments are missing, they are requested from several peers.
 type AvailabilityDownloader struct {
 	RefreshPeriod time.Duration
 	Priority      Priority
+	// MaxPeerRequests limits how many fragments are downloaded at once from a single peer, 0 means no limit
+	MaxPeerRequests int
+	// EndgameFragments is the amount of missing fragments from which fragments that are already downloaded are
+	// requested from other peers as well, 0 disables endgame
+	EndgameFragments int
 
 	availabilityCount map[int][]availableFragments
 	lastRefresh       time.Time
 	peerRequests      map[string]int
+	// rejected holds for each fragment the peers that sent corrupted data
+	rejected map[int]map[string]bool
+	// inFlight holds the amount of fragments currently downloaded from each peer
+	inFlight map[string]int
+	// pending holds fragments that are currently downloaded, from what peers and when their download started
+	pending map[int]map[string]time.Time
+	// scores holds the behaviour of every peer that was requested
+	scores map[string]*peerScore
+	random *rand.Rand
 }
 
 // NewHighAvailabilityDownloader creates a new HighAvailabilityDownloader with a defined refresh period for fragment availability
-func NewHighAvailabilityDownloader(refreshPeriod time.Duration) DownloadMethod {
-	return &AvailabilityDownloader{refreshPeriod, LowestAvailability, make(map[int][]availableFragments), time.Now().Add(-refreshPeriod),
-		make(map[string]int)}
+// and a limit of fragments downloaded at once from a single peer
+func NewHighAvailabilityDownloader(refreshPeriod time.Duration, maxPeerRequests int) DownloadMethod {
+	return &AvailabilityDownloader{refreshPeriod, LowestAvailability, maxPeerRequests, defaultEndgameFragments,
+		make(map[int][]availableFragments), time.Now().Add(-refreshPeriod), make(map[string]int),
+		make(map[int]map[string]bool), make(map[string]int), make(map[int]map[string]time.Time),
+		make(map[string]*peerScore), rand.New(rand.NewSource(time.Now().UnixNano()))}
 }
 
 // NextFragment - simple aglorithim for downloading from peers
@@ -47,25 +98,158 @@ func (h *AvailabilityDownloader) NextFragment(ctx context.Context, peers map[str
 		h.countFragments(ctx, peers, fm)
 		h.lastRefresh = time.Now()
 	}
-	// go from highest availability fragment
-	for i := 0; i <= len(peers); i++ {
-		for _, f := range h.availabilityCount[i] {
-			if fm.FragmentExists(f.FragmentID) {
-				continue
+	backingOff := false
+	// Missing fragments that are already downloaded are only requested again in endgame
+	endgame := h.EndgameFragments > 0 && fm.FragmentsCount-len(fm.AvailableFragments) <= h.EndgameFragments
+	for _, duplicate := range []bool{false, endgame} {
+		// go from lowest availability fragment
+		for i := 0; i <= len(peers); i++ {
+			for _, f := range h.availabilityCount[i] {
+				if fm.FragmentExists(f.FragmentID) || (len(h.pending[f.FragmentID]) > 0) != duplicate {
+					continue
+				}
+				candidates, waiting := h.validPeers(f)
+				backingOff = backingOff || waiting
+				if len(candidates) == 0 {
+					continue
+				}
+				// pick peer with lowest request count out of peers that have this fragment
+				log.Debugf("Picking from peers %s", candidates)
+				pn := h.pickPeer(candidates...)
+				if duplicate {
+					log.Debugf("Endgame, downloading fragment(id=%d)@%s as well", f.FragmentID, pn)
+				} else {
+					log.Debugf("Downloading fragment(id=%d)@%s", f.FragmentID, pn)
+				}
+				if _, ok := h.pending[f.FragmentID]; !ok {
+					h.pending[f.FragmentID] = make(map[string]time.Time)
+				}
+				h.pending[f.FragmentID][pn] = time.Now()
+				h.inFlight[pn]++
+				return peers[pn], f.FragmentID, nil
 			}
-			if len(f.Peers) == 0 {
-				continue
-			}
-			// pick peer with lowest request count out of peers that have this fragment
-			log.Debugf("Picking from peers %s", f.Peers)
-			pn := h.pickPeer(f.Peers...)
-			log.Debugf("Downloading fragment(id=%d)@%s", f.FragmentID, pn)
-			return peers[pn], f.FragmentID, nil
 		}
 	}
+	if banned := h.Banned(); len(banned) > 0 {
+		log.Debugf("Banned peers %s", banned)
+	}
+	if backingOff {
+		return nil, 0, ErrBackoff
+	}
 	return nil, 0, errors.New("No Fragment found to download")
 }
 
+// Done marks that a fragment download from a peer finished, allowing more requests from that peer. Failing peers
+// aren't requested for a while, and are banned if they keep failing.
+func (h *AvailabilityDownloader) Done(peerName string, fragmentID int, successful bool) {
+	started, ok := h.pending[fragmentID][peerName]
+	h.Cancel(peerName, fragmentID)
+	score := h.score(peerName)
+	if !successful {
+		score.failures++
+		h.backoff(peerName, score.failures, score.failures >= maxPeerFailures)
+		return
+	}
+	score.failures = 0
+	if ok {
+		// Moving average, so a single slow download won't change the peer's latency much
+		if score.latency == 0 {
+			score.latency = time.Since(started)
+		} else {
+			score.latency = (3*score.latency + time.Since(started)) / 4
+		}
+	}
+}
+
+// Cancel marks that a fragment download from a peer was canceled, since the fragment was downloaded from another peer
+func (h *AvailabilityDownloader) Cancel(peerName string, fragmentID int) {
+	if _, ok := h.pending[fragmentID][peerName]; !ok {
+		return
+	}
+	delete(h.pending[fragmentID], peerName)
+	if len(h.pending[fragmentID]) == 0 {
+		delete(h.pending, fragmentID)
+	}
+	if h.inFlight[peerName] > 0 {
+		h.inFlight[peerName]--
+	}
+}
+
+// Reject marks that a peer sent corrupted data for a fragment, the fragment won't be requested from that peer again
+// and the peer is banned if it keeps sending corrupted data
+func (h *AvailabilityDownloader) Reject(peerName string, fragmentID int) {
+	if _, ok := h.rejected[fragmentID]; !ok {
+		h.rejected[fragmentID] = make(map[string]bool)
+	}
+	h.rejected[fragmentID][peerName] = true
+	score := h.score(peerName)
+	score.rejects++
+	h.backoff(peerName, score.rejects, score.rejects >= maxPeerRejects)
+}
+
+// Banned returns the peers that won't be requested anymore
+func (h *AvailabilityDownloader) Banned() []string {
+	var banned []string
+	for p, score := range h.scores {
+		if score.banned {
+			banned = append(banned, p)
+		}
+	}
+	sort.Strings(banned)
+	return banned
+}
+
+// backoff stops requesting a peer for a period growing exponentially with the amount of times it failed, or bans it
+func (h *AvailabilityDownloader) backoff(peerName string, times int, ban bool) {
+	score := h.score(peerName)
+	if ban {
+		if !score.banned {
+			log.Warnf("Peer %s keeps failing, it won't be requested again", peerName)
+			log.Debugf("Banned peers %s", h.Banned())
+		}
+		score.banned = true
+		return
+	}
+	period := maxBackoff
+	if times < 16 && baseBackoff<<uint(times-1) < maxBackoff {
+		period = baseBackoff << uint(times-1)
+	}
+	log.Debugf("Backing off peer %s for %s", peerName, period)
+	score.backoff = time.Now().Add(period)
+}
+
+// score returns the score of a peer, creating it if the peer wasn't requested yet
+func (h *AvailabilityDownloader) score(peerName string) *peerScore {
+	score, ok := h.scores[peerName]
+	if !ok {
+		score = &peerScore{}
+		h.scores[peerName] = score
+	}
+	return score
+}
+
+// validPeers returns peers that have the fragment, excluding banned peers, peers that already sent corrupted data for
+// it or are downloading it and peers that reached their limit of fragments downloaded at once. It also reports if
+// peers were excluded only because they are backing off.
+func (h *AvailabilityDownloader) validPeers(f availableFragments) ([]string, bool) {
+	var peers []string
+	backingOff := false
+	for _, p := range f.Peers {
+		if _, downloading := h.pending[f.FragmentID][p]; downloading || h.rejected[f.FragmentID][p] || h.score(p).banned {
+			continue
+		}
+		if time.Now().Before(h.score(p).backoff) {
+			backingOff = true
+			continue
+		}
+		if h.MaxPeerRequests > 0 && h.inFlight[p] >= h.MaxPeerRequests {
+			continue
+		}
+		peers = append(peers, p)
+	}
+	return peers, backingOff
+}
+
 // countFragments implements a counting sort for each fragment and to what peers have it.
 func (h *AvailabilityDownloader) countFragments(ctx context.Context, peers map[string]Client, fm FileMetaData) {
 	// Sorts each fragment and how many peers have that fragment
@@ -76,9 +260,22 @@ func (h *AvailabilityDownloader) countFragments(ctx context.Context, peers map[s
 	c := make([][]string, fm.FragmentsCount)
 	// Count for every peer, finding the "most available chunk" etc
 	for _, peer := range peers {
-		// if peer is dead for some reason, it will return 0 fragments that are avialable since it's down now.
-		af := peer.FragmentsAvailable(ctx, fm.Hash)
+		if h.score(peer.Name()).banned {
+			continue
+		}
+		// Peers that can't tell what fragments they have are backed off, they may have any of them once they are back
+		af, err := peer.FragmentsAvailable(ctx, fm.Hash)
+		if err != nil {
+			log.Debugf("Failed to check available fragments of %s. Reason: %s", peer.Name(), err)
+			score := h.score(peer.Name())
+			score.failures++
+			h.backoff(peer.Name(), score.failures, score.failures >= maxPeerFailures)
+			continue
+		}
 		for _, i := range af {
+			if i < 0 || i >= len(c) {
+				continue
+			}
 			c[i] = append(c[i], peer.Name())
 		}
 	}
@@ -95,21 +292,22 @@ func (h *AvailabilityDownloader) countFragments(ctx context.Context, peers map[s
 			fragmentAvailability[len(peers)] = append(availability, availableFragments{i, peers})
 		}
 	}
+	// Shuffle fragments of the same availability, so peers downloading the same file won't all request the same fragments
+	for _, availability := range fragmentAvailability {
+		h.random.Shuffle(len(availability), func(i, j int) {
+			availability[i], availability[j] = availability[j], availability[i]
+		})
+	}
 	h.availabilityCount = fragmentAvailability
 }
 
-// pickPeer picks peer with lowest request count
+// pickPeer picks peer with lowest request count, peers with the same count are picked by their latency
 func (h *AvailabilityDownloader) pickPeer(peers ...string) string {
 	lowestPeerReq := math.MaxInt64
 	var pickedPeer string
 	for _, p := range peers {
-		req, ok := h.peerRequests[p]
-		// Peers was never requested
-		if !ok {
-			h.peerRequests[p] = 0
-			req = 0
-		}
-		if req < lowestPeerReq {
+		req := h.peerRequests[p]
+		if pickedPeer == "" || req < lowestPeerReq || (req == lowestPeerReq && h.score(p).latency < h.score(pickedPeer).latency) {
 			lowestPeerReq = req
 			pickedPeer = p
 		}
@@ -2,6 +2,7 @@ package p2p_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -15,32 +16,37 @@ import (
 
 func TestOnePeerDownload(t *testing.T) {
 	fragments := []p2p.Fragment{}
-	fm := p2p.FileMetaData{"Test", "C:\\file\\path\test.exe", "TestPub", "13c405d80e97aa7b46d3389180b19eb3", 666, 2, fragments, 1}
-	dl := p2p.NewHighAvailabilityDownloader(time.Second * 30)
+	fm := p2p.FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: p2p.FileChunkSize, FragmentsCount: 2, AvailableFragments: fragments, Status: 1}
+	dl := p2p.NewHighAvailabilityDownloader(time.Second*30, 0)
 	client := &mocks.Client{}
 	client.On("Name").Return("testClient")
-	client.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1}).Times(3)
+	client.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1}, nil).Times(3)
 	peers := make(map[string]p2p.Client)
 	peers[client.Name()] = client
 	ctx := context.Background()
 	c, i, err := dl.NextFragment(ctx, peers, fm)
 	assert.Nil(t, err)
 	assert.Equal(t, c, client)
-	assert.Equal(t, 0, i)
-	fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{0, ""})
+	// Fragments with the same availability are picked in random order
+	assert.Contains(t, []int{0, 1}, i)
+	dl.Done(c.Name(), i, true)
+	fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{i, ""})
+	first := i
 	c, i, err = dl.NextFragment(ctx, peers, fm)
 	assert.Nil(t, err)
-	assert.Equal(t, 1, i)
-	fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{1, ""})
+	assert.Equal(t, 1-first, i)
+	dl.Done(c.Name(), i, true)
+	fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{i, ""})
 	c, i, err = dl.NextFragment(ctx, peers, fm)
 	assert.Error(t, err)
 	// Test Case where we have 2 fragments but peer has only 1, we expect after 1 iteration that it will fail
-	client.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{1}).Twice()
+	client.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{1}, nil).Twice()
 	fm.AvailableFragments = []p2p.Fragment{}
 	c, i, err = dl.NextFragment(ctx, peers, fm)
 	assert.Nil(t, err)
 	assert.Equal(t, c, client)
-	assert.Equal(t, 0, i)
+	assert.Contains(t, []int{0, 1}, i)
+	dl.Done(c.Name(), i, true)
 	fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{1, ""})
 	c, i, err = dl.NextFragment(ctx, peers, fm)
 	assert.Nil(t, err)
@@ -51,14 +57,14 @@ func TestTwoPeerDownload(t *testing.T) {
 	peerCount := make(map[string]int)
 	fragments := []p2p.Fragment{}
 	// We will create a file with 4 fragments
-	fm := p2p.FileMetaData{"Test", "C:\\file\\path\test.exe", "TestPub", "13c405d80e97aa7b46d3389180b19eb3", 666, 4, fragments, 1}
-	dl := p2p.NewHighAvailabilityDownloader(time.Second * 30)
+	fm := p2p.FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: p2p.FileChunkSize, FragmentsCount: 4, AvailableFragments: fragments, Status: 1}
+	dl := p2p.NewHighAvailabilityDownloader(time.Second*30, 0)
 	client1 := &mocks.Client{}
 	client1.On("Name").Return("testClient1")
-	client1.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3})
+	client1.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3}, nil)
 	client2 := &mocks.Client{}
 	client2.On("Name").Return("testClient2")
-	client2.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3})
+	client2.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3}, nil)
 	peers := make(map[string]p2p.Client)
 	peers[client1.Name()] = client1
 	peers[client2.Name()] = client2
@@ -66,9 +72,10 @@ func TestTwoPeerDownload(t *testing.T) {
 	for fid := 0; fid < fm.FragmentsCount; fid++ {
 		c, i, err := dl.NextFragment(ctx, peers, fm)
 		assert.Nil(t, err)
-		assert.Equal(t, fid, i)
+		assert.False(t, fm.FragmentExists(i))
 		peerCount[c.Name()] += 1
-		fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{fid, ""})
+		dl.Done(c.Name(), i, true)
+		fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{i, ""})
 	}
 	assert.Equal(t, peerCount[client1.Name()], peerCount[client2.Name()])
 	_, _, err := dl.NextFragment(ctx, peers, fm)
@@ -79,27 +86,208 @@ func TestTwoPeerDownloadMissingFiles(t *testing.T) {
 	peerCount := make(map[string]int)
 	fragments := []p2p.Fragment{}
 	// We will create a file with 4 fragments
-	fm := p2p.FileMetaData{"Test", "C:\\file\\path\test.exe", "TestPub", "13c405d80e97aa7b46d3389180b19eb3", 666, 4, fragments, 1}
-	dl := p2p.NewHighAvailabilityDownloader(time.Second * 30)
+	fm := p2p.FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: p2p.FileChunkSize, FragmentsCount: 4, AvailableFragments: fragments, Status: 1}
+	dl := p2p.NewHighAvailabilityDownloader(time.Second*30, 0)
 	client1 := &mocks.Client{}
 	client1.On("Name").Return("testClient1")
-	client1.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3})
+	client1.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3}, nil)
 	client2 := &mocks.Client{}
 	client2.On("Name").Return("testClient2")
-	client2.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 3})
+	client2.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 3}, nil)
 	peers := make(map[string]p2p.Client)
 	peers[client1.Name()] = client1
 	peers[client2.Name()] = client2
 	ctx := context.Background()
-	expectedFragment := []int{1, 2, 0, 3}
+	// Rarest fragments are downloaded first, in random order
+	expectedFragments := [][]int{{1, 2}, {1, 2}, {0, 3}, {0, 3}}
 	for fid := 0; fid < fm.FragmentsCount; fid++ {
 		c, i, err := dl.NextFragment(ctx, peers, fm)
 		assert.Nil(t, err)
-		assert.Equal(t, expectedFragment[fid], i)
+		assert.Contains(t, expectedFragments[fid], i)
+		assert.False(t, fm.FragmentExists(i))
 		peerCount[c.Name()] += 1
+		dl.Done(c.Name(), i, true)
 		fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{i, ""})
 	}
 	assert.Equal(t, peerCount[client1.Name()], peerCount[client2.Name()])
 	_, _, err := dl.NextFragment(ctx, peers, fm)
 	assert.Error(t, err)
 }
+
+// Test a peer that sent a corrupted fragment won't be asked for that fragment again
+func TestRejectedPeerDownload(t *testing.T) {
+	fragments := []p2p.Fragment{}
+	fm := p2p.FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: p2p.FileChunkSize, FragmentsCount: 1, AvailableFragments: fragments, Status: 1}
+	dl := p2p.NewHighAvailabilityDownloader(time.Second*30, 0)
+	client1 := &mocks.Client{}
+	client1.On("Name").Return("testClient1")
+	client1.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0}, nil)
+	client2 := &mocks.Client{}
+	client2.On("Name").Return("testClient2")
+	client2.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0}, nil)
+	peers := make(map[string]p2p.Client)
+	peers[client1.Name()] = client1
+	peers[client2.Name()] = client2
+	ctx := context.Background()
+	c, i, err := dl.NextFragment(ctx, peers, fm)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, i)
+	dl.Done(c.Name(), i, true)
+	dl.Reject(c.Name(), i)
+	other, i, err := dl.NextFragment(ctx, peers, fm)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, i)
+	assert.NotEqual(t, c.Name(), other.Name())
+	dl.Done(other.Name(), i, true)
+	dl.Reject(other.Name(), i)
+	_, _, err = dl.NextFragment(ctx, peers, fm)
+	assert.Error(t, err)
+}
+
+// Test fragments are downloaded concurrently without exceeding the requests limit of each peer
+func TestConcurrentDownload(t *testing.T) {
+	fragments := []p2p.Fragment{}
+	fm := p2p.FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: p2p.FileChunkSize, FragmentsCount: 6, AvailableFragments: fragments, Status: 1}
+	dl := p2p.NewHighAvailabilityDownloader(time.Second*30, 2)
+	client1 := &mocks.Client{}
+	client1.On("Name").Return("testClient1")
+	client1.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3, 4, 5}, nil)
+	client2 := &mocks.Client{}
+	client2.On("Name").Return("testClient2")
+	client2.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3, 4, 5}, nil)
+	peers := make(map[string]p2p.Client)
+	peers[client1.Name()] = client1
+	peers[client2.Name()] = client2
+	ctx := context.Background()
+	// Without finishing any download, 2 fragments can be requested from each peer
+	requested := make(map[int]string)
+	for j := 0; j < 4; j++ {
+		c, i, err := dl.NextFragment(ctx, peers, fm)
+		assert.Nil(t, err)
+		_, ok := requested[i]
+		assert.False(t, ok, "fragment %d was requested twice", i)
+		requested[i] = c.Name()
+	}
+	_, _, err := dl.NextFragment(ctx, peers, fm)
+	assert.Error(t, err)
+	// Once a download is done the peer can be requested again
+	var done int
+	for done = range requested {
+		break
+	}
+	dl.Done(requested[done], done, true)
+	fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{done, ""})
+	c, i, err := dl.NextFragment(ctx, peers, fm)
+	assert.Nil(t, err)
+	assert.Equal(t, requested[done], c.Name())
+	_, ok := requested[i]
+	assert.False(t, ok, "fragment %d was requested twice", i)
+}
+
+// Test failing peers are backed off and banned once they keep failing or sending corrupted data
+func TestPeerBackoffAndBan(t *testing.T) {
+	fragments := []p2p.Fragment{}
+	fm := p2p.FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: p2p.FileChunkSize, FragmentsCount: 4, AvailableFragments: fragments, Status: 1}
+	dl := p2p.NewHighAvailabilityDownloader(time.Second*30, 0)
+	client1 := &mocks.Client{}
+	client1.On("Name").Return("testClient1")
+	client1.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3}, nil)
+	client2 := &mocks.Client{}
+	client2.On("Name").Return("testClient2")
+	client2.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3}, nil)
+	peers := make(map[string]p2p.Client)
+	peers[client1.Name()] = client1
+	peers[client2.Name()] = client2
+	ctx := context.Background()
+	// A peer that failed isn't requested until its backoff is over
+	c, i, err := dl.NextFragment(ctx, peers, fm)
+	assert.Nil(t, err)
+	dl.Done(c.Name(), i, false)
+	other, j, err := dl.NextFragment(ctx, peers, fm)
+	assert.Nil(t, err)
+	assert.Equal(t, i, j)
+	assert.NotEqual(t, c.Name(), other.Name())
+	dl.Done(other.Name(), j, false)
+	_, _, err = dl.NextFragment(ctx, peers, fm)
+	assert.Equal(t, p2p.ErrBackoff, err)
+	// Peers that keep failing or sending corrupted data are banned
+	for k := 0; k < 5; k++ {
+		dl.Done(client1.Name(), 0, false)
+	}
+	for k := 0; k < 3; k++ {
+		dl.Reject(client2.Name(), k)
+	}
+	assert.Equal(t, []string{"testClient1", "testClient2"}, dl.(*p2p.AvailabilityDownloader).Banned())
+	_, _, err = dl.NextFragment(ctx, peers, fm)
+	assert.Error(t, err)
+	assert.NotEqual(t, p2p.ErrBackoff, err)
+}
+
+// Test the last missing fragments are requested from several peers, and slower downloads are canceled
+func TestEndgameDownload(t *testing.T) {
+	fragments := []p2p.Fragment{}
+	fm := p2p.FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: p2p.FileChunkSize, FragmentsCount: 6, AvailableFragments: fragments, Status: 1}
+	dl := p2p.NewHighAvailabilityDownloader(time.Second*30, 0)
+	client1 := &mocks.Client{}
+	client1.On("Name").Return("testClient1")
+	client1.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3, 4, 5}, nil)
+	client2 := &mocks.Client{}
+	client2.On("Name").Return("testClient2")
+	client2.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1, 2, 3, 4, 5}, nil)
+	peers := make(map[string]p2p.Client)
+	peers[client1.Name()] = client1
+	peers[client2.Name()] = client2
+	other := map[string]string{client1.Name(): client2.Name(), client2.Name(): client1.Name()}
+	ctx := context.Background()
+	// Download 2 fragments and request the rest, the 4 missing fragments are in endgame
+	requested := make(map[int]string)
+	for j := 0; j < 6; j++ {
+		c, i, err := dl.NextFragment(ctx, peers, fm)
+		assert.Nil(t, err)
+		if j < 2 {
+			dl.Done(c.Name(), i, true)
+			fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{i, ""})
+		} else {
+			requested[i] = c.Name()
+		}
+	}
+	// Every missing fragment is requested once more from the other peer
+	for j := 0; j < 4; j++ {
+		c, i, err := dl.NextFragment(ctx, peers, fm)
+		assert.Nil(t, err)
+		assert.Equal(t, other[requested[i]], c.Name())
+	}
+	_, _, err := dl.NextFragment(ctx, peers, fm)
+	assert.Error(t, err)
+	// Once a fragment is downloaded the slower download is canceled
+	for i, peerName := range requested {
+		dl.Done(peerName, i, true)
+		dl.Cancel(other[peerName], i)
+		fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{i, ""})
+	}
+	_, _, err = dl.NextFragment(ctx, peers, fm)
+	assert.Error(t, err)
+}
+
+// Test peers that can't tell what fragments they have aren't requested, while other peers are
+func TestUnreachablePeerDownload(t *testing.T) {
+	fm := p2p.FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: p2p.FileChunkSize, FragmentsCount: 2, AvailableFragments: []p2p.Fragment{}, Status: 1}
+	dl := p2p.NewHighAvailabilityDownloader(0, 0)
+	unreachable := &mocks.Client{}
+	unreachable.On("Name").Return("unreachable")
+	unreachable.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return(nil, errors.New("unavailable"))
+	client := &mocks.Client{}
+	client.On("Name").Return("testClient")
+	client.On("FragmentsAvailable", mock.Anything, mock.AnythingOfType("string")).Return([]int{0, 1}, nil)
+	peers := map[string]p2p.Client{unreachable.Name(): unreachable, client.Name(): client}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		c, id, err := dl.NextFragment(ctx, peers, fm)
+		assert.Nil(t, err)
+		assert.Equal(t, client, c)
+		dl.Done(c.Name(), id, true)
+		fm.AvailableFragments = append(fm.AvailableFragments, p2p.Fragment{id, ""})
+	}
+	_, _, err := dl.NextFragment(ctx, peers, fm)
+	assert.Error(t, err)
+}
@@ -0,0 +1,318 @@
+package p2p
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Schemes deriving the key of an encrypted file
+const (
+	// PassphraseEncryption derives the key from a passphrase with scrypt
+	PassphraseEncryption = "scrypt"
+	// RecipientEncryption derives the key from an x25519 key exchange with the recipient's public key
+	RecipientEncryption = "x25519"
+)
+
+// EncryptedExtension is added to the name of encrypted files, downloaded files are decrypted to a file without it
+const EncryptedExtension = ".enc"
+
+// sealOverhead is the size of the authentication tag added to every encrypted fragment
+const sealOverhead = 16
+
+// keyCheckMessage is signed with the key of a file, so a wrong key is detected before downloading the file
+const keyCheckMessage = "fileshare-key-check"
+
+// NewPassphraseKey creates the key of a file from a passphrase and a random salt, it returns the key and the encryption
+// of the file i.e scrypt:<salt>:<key check>
+func NewPassphraseKey(passphrase string) ([]byte, string, error) {
+	if passphrase == "" {
+		return nil, "", errors.New("Passphrase is empty")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, "", err
+	}
+	key, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, formatEncryption(PassphraseEncryption, salt, key), nil
+}
+
+// NewRecipientKey creates the key of a file only the owner of the recipient's private key can derive, it returns the
+// key and the encryption of the file i.e x25519:<ephemeral public key>:<key check>
+func NewRecipientKey(publicKey string) ([]byte, string, error) {
+	recipient, err := decodeKey(publicKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid public key. Reason: %s", err)
+	}
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, "", err
+	}
+	ephemeralPublic, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := recipientKey(ephemeral, recipient, ephemeralPublic, recipient)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, formatEncryption(RecipientEncryption, ephemeralPublic, key), nil
+}
+
+// DeriveKey derives the key of an encrypted file from its encryption and a passphrase or a private key, depending on
+// the scheme the file was encrypted with
+func DeriveKey(encryption string, secret string) ([]byte, error) {
+	parts := strings.Split(encryption, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Invalid encryption %s", encryption)
+	}
+	params, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid encryption %s", encryption)
+	}
+	var key []byte
+	switch parts[0] {
+	case PassphraseEncryption:
+		key, err = passphraseKey(secret, params)
+	case RecipientEncryption:
+		var private, public []byte
+		if private, err = decodeKey(secret); err != nil {
+			return nil, fmt.Errorf("Invalid private key. Reason: %s", err)
+		}
+		if public, err = curve25519.X25519(private, curve25519.Basepoint); err != nil {
+			return nil, err
+		}
+		key, err = recipientKey(private, params, params, public)
+	default:
+		return nil, fmt.Errorf("Unsupported encryption %s", parts[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(keyCheck(key)), []byte(parts[2])) {
+		return nil, errors.New("Wrong key")
+	}
+	return key, nil
+}
+
+// GenerateKeyPair creates a hex encoded x25519 key pair for receiving encrypted files
+func GenerateKeyPair() (publicKey string, privateKey string, err error) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		return "", "", err
+	}
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(public), hex.EncodeToString(private), nil
+}
+
+// passphraseKey derives a key from a passphrase
+func passphraseKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// recipientKey derives a key from the shared secret of an x25519 key exchange, both public keys are bound to the key
+func recipientKey(private, peer, ephemeralPublic, recipientPublic []byte) ([]byte, error) {
+	shared, err := curve25519.X25519(private, peer)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	salt := append(append([]byte{}, ephemeralPublic...), recipientPublic...)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("fileshare-file-key")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// decodeKey decodes a hex encoded x25519 key
+func decodeKey(key string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != curve25519.PointSize {
+		return nil, fmt.Errorf("Key must be %d bytes", curve25519.PointSize)
+	}
+	return b, nil
+}
+
+func formatEncryption(scheme string, params []byte, key []byte) string {
+	return fmt.Sprintf("%s:%s:%s", scheme, hex.EncodeToString(params), keyCheck(key))
+}
+
+// keyCheck proves a key is the key of a file without revealing it
+func keyCheck(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyCheckMessage))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// newAEAD creates the cipher sealing fragments
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// fragmentNonce returns the nonce of a fragment, every file has its own key so nonces are never reused
+func fragmentNonce(aead cipher.AEAD, id int) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(id))
+	return nonce
+}
+
+// EncryptFile encrypts src to dst fragment by fragment, every fragment of chunkSize bytes in dst holds chunkSize minus
+// the authentication tag bytes of src, so fragments can be decrypted as soon as they are downloaded
+func EncryptFile(fs afero.Fs, src string, dst string, key []byte, chunkSize int64) error {
+	if chunkSize <= sealOverhead || chunkSize > MaxChunkSize {
+		return fmt.Errorf("Chunk size must be between %d and %d bytes", sealOverhead+1, MaxChunkSize)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	buffer := make([]byte, chunkSize-sealOverhead)
+	sealed := make([]byte, 0, chunkSize)
+	for id := 0; ; id++ {
+		n, err := io.ReadFull(in, buffer)
+		if n > 0 {
+			if _, err := out.Write(aead.Seal(sealed[:0], fragmentNonce(aead, id), buffer[:n], nil)); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// PublishEncrypted encrypts a file to the same path with EncryptedExtension and publishes the encrypted file, peers
+// only hold and serve the encrypted file. The key is created by NewPassphraseKey or NewRecipientKey, the file is
+// encrypted again with every publish so its hash changes.
+func PublishEncrypted(fs afero.Fs, db *gorm.DB, filePath string, algorithm HashAlgorithm, chunkSize int64, allow []string,
+	key []byte, encryption string) error {
+	stats, err := fs.Stat(filePath)
+	if err != nil {
+		log.Errorf("File to publish %s doesn't exist", filePath)
+		return err
+	}
+	if chunkSize == 0 {
+		chunkSize = ChooseChunkSize(stats.Size())
+	}
+	encryptedPath := filePath + EncryptedExtension
+	var fm FileMetaData
+	if !db.Where("file_path = ?", encryptedPath).First(&fm).RecordNotFound() {
+		log.Infof("Replacing previously encrypted file(hash=%s)", fm.Hash)
+		if allow == nil {
+			allow = Access(db, fm.Hash)
+		}
+		if err := deleteMeta(db, fm); err != nil {
+			return err
+		}
+	}
+	log.Infof("Encrypting %s to %s", filePath, encryptedPath)
+	if err := EncryptFile(fs, filePath, encryptedPath, key, chunkSize); err != nil {
+		return err
+	}
+	return publish(fs, db, encryptedPath, algorithm, chunkSize, allow, encryption)
+}
+
+// Encrypted checks if the file was published encrypted
+func (fm FileMetaData) Encrypted() bool {
+	return fm.Encryption != ""
+}
+
+// PlainSize returns the size of an encrypted file once decrypted
+func (fm FileMetaData) PlainSize() int64 {
+	if !fm.Encrypted() {
+		return fm.Size
+	}
+	return fm.Size - int64(fm.FragmentsCount)*sealOverhead
+}
+
+// PlainName returns the name of an encrypted file once decrypted
+func (fm FileMetaData) PlainName() string {
+	if strings.HasSuffix(fm.Name, EncryptedExtension) && len(fm.Name) > len(EncryptedExtension) {
+		return strings.TrimSuffix(fm.Name, EncryptedExtension)
+	}
+	return fm.Name + ".dec"
+}
+
+// Decrypter decrypts fragments of an encrypted file once they are verified, writing the plain data to its place in
+// another file
+type Decrypter struct {
+	fm   FileMetaData
+	aead cipher.AEAD
+	w    io.WriterAt
+}
+
+// NewDecrypter creates a Decrypter writing to w, the key is derived from a passphrase or a private key, see DeriveKey
+func (fm FileMetaData) NewDecrypter(secret string, w io.WriterAt) (*Decrypter, error) {
+	if !fm.Encrypted() {
+		return nil, errors.New("File isn't encrypted")
+	}
+	if fm.Chunk() <= sealOverhead {
+		return nil, fmt.Errorf("Invalid chunk size %d for an encrypted file", fm.Chunk())
+	}
+	key, err := DeriveKey(fm.Encryption, secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Decrypter{fm, aead, w}, nil
+}
+
+// Decrypt reads an encrypted fragment from r and writes it decrypted
+func (d *Decrypter) Decrypt(r io.ReaderAt, id int) error {
+	sealed := make([]byte, d.fm.FragmentSize(id))
+	if _, err := r.ReadAt(sealed, d.fm.FragmentOffset(id)); err != nil && err != io.EOF {
+		return err
+	}
+	data, err := d.aead.Open(sealed[:0], fragmentNonce(d.aead, id), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt fragment %d. Reason: %s", id, err)
+	}
+	_, err = d.w.WriteAt(data, int64(id)*(d.fm.Chunk()-sealOverhead))
+	return err
+}
@@ -0,0 +1,104 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test keys are derived again only with the same passphrase or the recipient's private key
+func TestDeriveKey(t *testing.T) {
+	key, encryption, err := NewPassphraseKey("correct horse")
+	assert.Nil(t, err)
+	derived, err := DeriveKey(encryption, "correct horse")
+	assert.Nil(t, err)
+	assert.Equal(t, key, derived)
+	_, err = DeriveKey(encryption, "battery staple")
+	assert.NotNil(t, err)
+	_, _, err = NewPassphraseKey("")
+	assert.NotNil(t, err)
+
+	publicKey, privateKey, err := GenerateKeyPair()
+	assert.Nil(t, err)
+	key, encryption, err = NewRecipientKey(publicKey)
+	assert.Nil(t, err)
+	derived, err = DeriveKey(encryption, privateKey)
+	assert.Nil(t, err)
+	assert.Equal(t, key, derived)
+	_, otherKey, _ := GenerateKeyPair()
+	_, err = DeriveKey(encryption, otherKey)
+	assert.NotNil(t, err)
+	_, err = DeriveKey(encryption, "not a key")
+	assert.NotNil(t, err)
+	_, _, err = NewRecipientKey("abcd")
+	assert.NotNil(t, err)
+	_, err = DeriveKey("rot13:00:00", "key")
+	assert.NotNil(t, err)
+}
+
+// Test encrypted files are published encrypted and every fragment decrypts to its place in the plain file
+func TestPublishEncrypted(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewMemMapFs()
+	plain := make([]byte, 1000)
+	rand.Read(plain)
+	assert.Nil(t, afero.WriteFile(fs, "secret.pdf", plain, 0644))
+	key, encryption, err := NewPassphraseKey("correct horse")
+	assert.Nil(t, err)
+	assert.Nil(t, PublishEncrypted(fs, db, "secret.pdf", SHA256, 256, []string{"hr-laptop"}, key, encryption))
+	var fm FileMetaData
+	assert.False(t, db.Where("file_path = ?", "secret.pdf"+EncryptedExtension).First(&fm).RecordNotFound())
+	assert.True(t, fm.Encrypted())
+	assert.Equal(t, encryption, fm.Encryption)
+	assert.Equal(t, "secret.pdf", fm.PlainName())
+	assert.Equal(t, 5, fm.FragmentsCount)
+	assert.Equal(t, int64(len(plain)), fm.PlainSize())
+	assert.Equal(t, []string{"hr-laptop"}, Access(db, fm.Hash))
+	sealed, err := afero.ReadFile(fs, fm.FilePath)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(sealed, plain[:100]))
+	f, err := fs.Open(fm.FilePath)
+	assert.Nil(t, err)
+	defer f.Close()
+	ok, err := fm.VerifyFile(f)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	_, err = fm.NewDecrypter("battery staple", nil)
+	assert.NotNil(t, err)
+	out, err := fs.Create("decrypted.pdf")
+	assert.Nil(t, err)
+	dec, err := fm.NewDecrypter("correct horse", out)
+	assert.Nil(t, err)
+	for _, id := range []int{3, 1, 4, 0, 2} {
+		assert.Nil(t, dec.Decrypt(f, id))
+	}
+	out.Close()
+	decrypted, _ := afero.ReadFile(fs, "decrypted.pdf")
+	assert.Equal(t, plain, decrypted)
+
+	// Fragments are sealed with their id, a fragment can't take the place of another
+	copy(sealed[256:512], sealed[:256])
+	assert.Nil(t, afero.WriteFile(fs, "tampered.enc", sealed, 0644))
+	tampered, _ := fs.Open("tampered.enc")
+	defer tampered.Close()
+	assert.NotNil(t, dec.Decrypt(tampered, 1))
+
+	// Encrypting again replaces the previous encrypted file and keeps its access
+	hash := fm.Hash
+	key, encryption, err = NewPassphraseKey("correct horse")
+	assert.Nil(t, err)
+	assert.Nil(t, PublishEncrypted(fs, db, "secret.pdf", SHA256, 256, nil, key, encryption))
+	fm = FileMetaData{}
+	assert.False(t, db.Where("file_path = ?", "secret.pdf"+EncryptedExtension).First(&fm).RecordNotFound())
+	assert.NotEqual(t, hash, fm.Hash)
+	assert.Equal(t, []string{"hr-laptop"}, Access(db, fm.Hash))
+	assert.Empty(t, Access(db, hash))
+	assert.NotNil(t, PublishEncrypted(fs, db, "secret.pdf", SHA256, sealOverhead, nil, key, encryption))
+}
@@ -0,0 +1,147 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxExchangedPeers bounds the peers sent in a single peer exchange, and the peers taken from one
+const MaxExchangedPeers = 32
+
+// maxKnownPeers bounds the peers a PeerBook holds, the peers seen least recently are forgotten first
+const maxKnownPeers = 256
+
+// defaultPeerTTL is how long a peer is known after it was last discovered
+const defaultPeerTTL = 10 * time.Minute
+
+// PeerBook holds the peers a node currently knows about, so they can be exchanged with peers that can't discover them,
+// i.e peers on another broadcast domain
+type PeerBook struct {
+	mu    sync.Mutex
+	peers map[string]knownPeer
+	ttl   time.Duration
+}
+
+// knownPeer is a peer of a PeerBook and when it was last discovered
+type knownPeer struct {
+	payload DiscoveryPayload
+	seen    time.Time
+}
+
+// NewPeerBook creates an empty book, peers are forgotten once they weren't discovered for ttl. defaultPeerTTL is used
+// when ttl isn't set
+func NewPeerBook(ttl time.Duration) *PeerBook {
+	if ttl <= 0 {
+		ttl = defaultPeerTTL
+	}
+	return &PeerBook{peers: make(map[string]knownPeer), ttl: ttl}
+}
+
+// Add adds a discovered peer to the book, or refreshes it when it is known
+func (b *PeerBook) Add(peer DiscoveryPayload) {
+	if err := validExchangedPeer(peer); err != nil {
+		log.Debugf("Skipped peer %s@%s:%d. Reason: %s", peer.Name, peer.Addr, peer.Port, err)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	peer.Proof = nil
+	b.peers[peerKey(peer)] = knownPeer{peer, time.Now()}
+	if len(b.peers) > maxKnownPeers {
+		for _, k := range b.expire()[maxKnownPeers:] {
+			delete(b.peers, peerKey(k.payload))
+		}
+	}
+}
+
+// Peers returns up to limit peers of the book, the peers discovered most recently first. 0 means no limit, a nil book
+// knows no peers
+func (b *PeerBook) Peers(limit int) []DiscoveryPayload {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	known := b.expire()
+	if limit > 0 && limit < len(known) {
+		known = known[:limit]
+	}
+	peers := make([]DiscoveryPayload, 0, len(known))
+	for _, k := range known {
+		peers = append(peers, k.payload)
+	}
+	return peers
+}
+
+// expire forgets peers that weren't discovered for the book's ttl, returning the peers left ordered by when they were
+// discovered, most recently first. The lock must be held
+func (b *PeerBook) expire() []knownPeer {
+	var known []knownPeer
+	for key, k := range b.peers {
+		if time.Since(k.seen) > b.ttl {
+			delete(b.peers, key)
+			continue
+		}
+		known = append(known, k)
+	}
+	sort.Slice(known, func(i, j int) bool {
+		return known[i].seen.After(known[j].seen)
+	})
+	return known
+}
+
+// Track wraps a client factory, adding every peer a client is created for to the book. Resolvers using the wrapped
+// factory fill the book as they discover peers
+func (b *PeerBook) Track(factory CreateClient) CreateClient {
+	return func(name, addr string, port int) (Client, error) {
+		client, err := factory(name, addr, port)
+		if err == nil {
+			b.Add(DiscoveryPayload{Name: name, Addr: addr, Port: port})
+		}
+		return client, err
+	}
+}
+
+// Watch discovers peers every period until ctx is done, the resolver must create clients with a factory tracked by
+// the book. Clients are only created to learn the peers, they are closed right away
+func (b *PeerBook) Watch(ctx context.Context, resolver PeerResolver, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		clients, _ := resolver.Discover(ctx)
+		for _, client := range clients {
+			client.Close()
+		}
+		log.Debugf("Knows %d peers", len(b.Peers(0)))
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// validExchangedPeer checks an exchanged peer can be connected to, peers may send anything
+func validExchangedPeer(peer DiscoveryPayload) error {
+	if peer.Name == "" {
+		return errors.New("Missing name")
+	}
+	if peer.Addr == "" {
+		return errors.New("Missing address")
+	}
+	if peer.Port <= 0 || peer.Port > 65535 {
+		return fmt.Errorf("Invalid port %d", peer.Port)
+	}
+	return nil
+}
+
+// peerKey identifies a peer by its name and address
+func peerKey(peer DiscoveryPayload) string {
+	return fmt.Sprintf("%s@%s:%d", peer.Name, peer.Addr, peer.Port)
+}
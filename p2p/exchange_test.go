@@ -0,0 +1,78 @@
+package p2p_test
+
+import (
+	"context"
+	"fileshare/p2p"
+	"fileshare/p2p/mocks"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// Test the book holds valid peers once, the peers discovered most recently first, until they expire
+func TestPeerBook(t *testing.T) {
+	a := p2p.DiscoveryPayload{Name: "a", Addr: "10.0.0.1", Port: 7979}
+	b := p2p.DiscoveryPayload{Name: "b", Addr: "10.0.0.2", Port: 7979}
+	book := p2p.NewPeerBook(time.Hour)
+	book.Add(a)
+	time.Sleep(time.Millisecond)
+	book.Add(b)
+	book.Add(p2p.DiscoveryPayload{Name: "c", Addr: "10.0.0.3"})
+	assert.Equal(t, []p2p.DiscoveryPayload{b, a}, book.Peers(0))
+	time.Sleep(time.Millisecond)
+	book.Add(a)
+	assert.Equal(t, []p2p.DiscoveryPayload{a}, book.Peers(1))
+	// Peers are added as clients are created for them
+	c, err := book.Track(factory)("c", "10.0.0.3", 7979)
+	assert.Nil(t, err)
+	assert.Equal(t, "c@7979", c.Name())
+	assert.Equal(t, 3, len(book.Peers(0)))
+	var none *p2p.PeerBook
+	assert.Empty(t, none.Peers(0))
+	expiring := p2p.NewPeerBook(time.Millisecond)
+	expiring.Add(a)
+	time.Sleep(5 * time.Millisecond)
+	assert.Empty(t, expiring.Peers(0))
+}
+
+// exchangeClient creates a mock client listing a single file named by the client, knowing the given peers
+func exchangeClient(name string, port int, peers ...p2p.DiscoveryPayload) *mocks.Client {
+	client := &mocks.Client{}
+	client.On("Name").Return(fmt.Sprintf("%s@%d", name, port))
+	client.On("List", mock.Anything).Return([]p2p.FileMetaData{{Name: name}}, nil)
+	client.On("PeerExchange", mock.Anything).Return(peers, nil)
+	client.On("Close").Return(nil)
+	return client
+}
+
+// Test peers learned from discovered peers are added once, until the request has the most peers allowed
+func TestRequestPeerExchange(t *testing.T) {
+	a := exchangeClient("a", 1,
+		p2p.DiscoveryPayload{Name: "b", Addr: "10.0.0.2", Port: 2},
+		p2p.DiscoveryPayload{Name: "b", Addr: "10.0.0.2", Port: 2},
+		p2p.DiscoveryPayload{Name: "a", Addr: "10.0.0.1", Port: 1},
+		p2p.DiscoveryPayload{Name: "", Addr: "10.0.0.9", Port: 9},
+		p2p.DiscoveryPayload{Name: "c", Addr: "10.0.0.3", Port: 0},
+		p2p.DiscoveryPayload{Name: "d", Addr: "10.0.0.4", Port: 4},
+		p2p.DiscoveryPayload{Name: "e", Addr: "10.0.0.5", Port: 5},
+	)
+	var created []string
+	exchange := func(name, addr string, port int) (p2p.Client, error) {
+		created = append(created, name)
+		return exchangeClient(name, port), nil
+	}
+	resolver := resolverFunc(func(ctx context.Context) ([]p2p.Client, error) { return []p2p.Client{a}, nil })
+	request := p2p.NewRequest("", nil, resolver, nil, 1)
+	request.SetPeerExchange(exchange, 3)
+	var names []string
+	for _, f := range request.List(context.Background()) {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "d"}, names)
+	// Invalid, duplicate and known peers aren't connected to, nor peers beyond the limit
+	assert.Equal(t, []string{"b", "d"}, created)
+	a.AssertNumberOfCalls(t, "PeerExchange", 1)
+}
@@ -1,12 +1,17 @@
 package p2p
 
 import (
-	"crypto/md5"
+	"bytes"
+	"database/sql/driver"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"math"
 	"os"
+	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
@@ -29,6 +34,8 @@ const (
 	Downloading        = 2
 	Finished           = 3
 	Seeding            = 4
+	// Corrupt files were downloaded completely, but don't match their hash
+	Corrupt = 5
 )
 
 // Allow Status to get a human printable form
@@ -44,6 +51,8 @@ func (e Status) String() string {
 		return "Finished"
 	case Seeding:
 		return "Seeding"
+	case Corrupt:
+		return "Corrupt"
 	default:
 		return fmt.Sprintf("%d", int(e))
 	}
@@ -57,16 +66,23 @@ type FileMetaData struct {
 	FilePath string
 	// Who is publishing the file, usually the node we are downloading from
 	Publisher string
-	// Hash is the unique identifier of the file
+	// Hash is the unique identifier of the file, the merkle root of the file's fragments prefixed with the algorithm
+	// that created it i.e sha256:<digest>
 	Hash string `gorm:"primary_key"`
 	// Size of file
 	Size int64
+	// ChunkSize is the size of every fragment but the last, chosen when the file is published
+	ChunkSize int64
 	// FragmentsCount specifies the amount of chunks the file is split
 	FragmentsCount int
+	// FragmentHashes holds the leaf hash of every fragment ordered by fragment id, recorded when the file is published
+	FragmentHashes Checksums `gorm:"type:text"`
 	// AvailbleFragments specifices all fragments available on the file
 	AvailableFragments []Fragment `gorm:"foreignkey:Hash"`
-	// Status of file PAUSED|DOWNLOADING|FINISHED|SEEDING
+	// Status of file PAUSED|DOWNLOADING|FINISHED|SEEDING|CORRUPT
 	Status Status
+	// Encryption describes how the file's fragments were encrypted when it was published, empty for plain files
+	Encryption string
 }
 
 // FragmentExists checks if a fragment is available on this file
@@ -79,6 +95,250 @@ func (fm FileMetaData) FragmentExists(id int) bool {
 	return false
 }
 
+// Chunk returns the chunk size of the file, files published by older versions are split to FileChunkSize chunks
+func (fm FileMetaData) Chunk() int64 {
+	if fm.ChunkSize <= 0 {
+		return FileChunkSize
+	}
+	return fm.ChunkSize
+}
+
+// FragmentOffset returns the offset of a fragment in the file
+func (fm FileMetaData) FragmentOffset(id int) int64 {
+	return int64(id) * fm.Chunk()
+}
+
+// FragmentSize returns the size of a fragment, the last fragment may be smaller than the chunk size
+func (fm FileMetaData) FragmentSize(id int) int64 {
+	size := fm.Size - fm.FragmentOffset(id)
+	if size > fm.Chunk() {
+		return fm.Chunk()
+	}
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// VerifyFragment checks data of a fragment against the file hash using the inclusion proof sent by the peer, when the
+// proof isn't valid data is checked against the fragment hashes.
+func (fm FileMetaData) VerifyFragment(id int, data []byte, proof [][]byte) bool {
+	return fm.verifyLeaf(id, leafHash(fm.Algorithm().fragmentAlgorithm(), data), proof)
+}
+
+// verifyLeaf checks the leaf hash of a fragment, see VerifyFragment
+func (fm FileMetaData) verifyLeaf(id int, leaf []byte, proof [][]byte) bool {
+	if leaf == nil {
+		return false
+	}
+	if fm.Algorithm() != MD5 && verifyMerkleProof(fm.Algorithm(), fm.root(), leaf, id, fm.FragmentsCount, proof) {
+		return true
+	}
+	if id < 0 || id >= len(fm.FragmentHashes) {
+		return false
+	}
+	return hex.EncodeToString(leaf) == fm.FragmentHashes[id]
+}
+
+// VerifyFile reads the whole file from r and checks it against the file hash
+func (fm FileMetaData) VerifyFile(r io.ReaderAt) (bool, error) {
+	algorithm := fm.Algorithm()
+	h, err := algorithm.New()
+	if err != nil {
+		return false, err
+	}
+	// Legacy files are identified by the md5 of their content
+	if algorithm == MD5 {
+		if _, err := io.Copy(h, io.NewSectionReader(r, 0, fm.Size)); err != nil {
+			return false, err
+		}
+		return bytes.Equal(h.Sum(nil), fm.root()), nil
+	}
+	// Padding a file would otherwise add fragments matching the hash
+	if err := fm.checkLayout(); err != nil {
+		return false, err
+	}
+	leaves := make([][]byte, fm.FragmentsCount)
+	buffer := make([]byte, fm.Chunk())
+	for id := range leaves {
+		data := buffer[:fm.FragmentSize(id)]
+		if n, err := r.ReadAt(data, fm.FragmentOffset(id)); n != len(data) {
+			return false, err
+		}
+		leaves[id] = leafHash(algorithm, data)
+	}
+	return bytes.Equal(merkleRoot(algorithm, leaves), fm.root()), nil
+}
+
+// checkLayout checks the size and chunk size of the file split it to its fragment count. Meta data sent by peers may
+// be anything, files published by older versions have no chunk size
+func (fm FileMetaData) checkLayout() error {
+	if fm.ChunkSize < 0 || fm.Chunk() > MaxChunkSize {
+		return fmt.Errorf("Invalid chunk size %d", fm.ChunkSize)
+	}
+	if fm.Size < 0 {
+		return fmt.Errorf("Invalid size %d", fm.Size)
+	}
+	count := fm.Size / fm.Chunk()
+	if fm.Size%fm.Chunk() != 0 {
+		count++
+	}
+	if int64(fm.FragmentsCount) != count {
+		return fmt.Errorf("%d bytes are split to %d fragments, not %d", fm.Size, count, fm.FragmentsCount)
+	}
+	return nil
+}
+
+// VerifyFragmentHashes checks that the fragment hashes build the file hash, so they can be trusted
+func (fm FileMetaData) VerifyFragmentHashes() bool {
+	// Legacy files aren't identified by a merkle root, their fragment hashes can't be verified
+	if fm.Algorithm() == MD5 {
+		return len(fm.FragmentHashes) == fm.FragmentsCount
+	}
+	leaves, err := fm.leaves()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(merkleRoot(fm.Algorithm(), leaves), fm.root())
+}
+
+// FragmentProof returns the inclusion proof of a fragment, it allows peers to verify the fragment with the file hash
+func (fm FileMetaData) FragmentProof(id int) ([][]byte, error) {
+	leaves, err := fm.leaves()
+	if err != nil {
+		return nil, err
+	}
+	if id < 0 || id >= len(leaves) {
+		return nil, fmt.Errorf("Fragment %d doesn't exist", id)
+	}
+	return merkleProof(fm.Algorithm(), leaves, id), nil
+}
+
+// leaves decodes the fragment hashes into the leaves of the file's merkle tree
+func (fm FileMetaData) leaves() ([][]byte, error) {
+	if fm.Algorithm() == MD5 || fm.Algorithm() == "" {
+		return nil, errors.New("File isn't identified by a merkle root")
+	}
+	if len(fm.FragmentHashes) != fm.FragmentsCount {
+		return nil, errors.New("Fragment hashes are missing")
+	}
+	leaves := make([][]byte, len(fm.FragmentHashes))
+	for i, h := range fm.FragmentHashes {
+		leaf, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, err
+		}
+		leaves[i] = leaf
+	}
+	return leaves, nil
+}
+
+// root returns the digest of the file hash
+func (fm FileMetaData) root() []byte {
+	_, digest, err := ParseHash(fm.Hash)
+	if err != nil {
+		return nil
+	}
+	root, _ := hex.DecodeString(digest)
+	return root
+}
+
+// Algorithm returns the hash algorithm that identifies the file
+func (fm FileMetaData) Algorithm() HashAlgorithm {
+	algorithm, _, err := ParseHash(fm.Hash)
+	if err != nil {
+		return ""
+	}
+	return algorithm
+}
+
+// FragmentWriter writes the data of a fragment to its place in a file as it is received, the data is hashed along the
+// way so the fragment can be verified without holding all of it in memory.
+type FragmentWriter struct {
+	fm      FileMetaData
+	id      int
+	w       io.WriterAt
+	offset  int64
+	written int64
+	leaf    hash.Hash
+	// mu guards closed, so no data is written once Close returns
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewFragmentWriter creates a FragmentWriter for fragment id, writing to w at the fragment's offset
+func (fm FileMetaData) NewFragmentWriter(w io.WriterAt, id int) *FragmentWriter {
+	leaf, err := fm.Algorithm().fragmentAlgorithm().New()
+	if err == nil {
+		leaf.Write(leafPrefix)
+	}
+	return &FragmentWriter{fm: fm, id: id, w: w, offset: fm.FragmentOffset(id), leaf: leaf}
+}
+
+// Write writes the next part of the fragment, data past the fragment size is padding and is discarded
+func (fw *FragmentWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.closed {
+		return 0, errors.New("Fragment writer is closed")
+	}
+	n := len(p)
+	if remaining := fw.fm.FragmentSize(fw.id) - fw.written; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	if _, err := fw.w.WriteAt(p, fw.offset+fw.written); err != nil {
+		return 0, err
+	}
+	if fw.leaf != nil {
+		fw.leaf.Write(p)
+	}
+	fw.written += int64(len(p))
+	return n, nil
+}
+
+// Close stops writing the fragment, writes after Close fail
+func (fw *FragmentWriter) Close() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.closed = true
+	return nil
+}
+
+// Verify checks that the whole fragment was written and matches the file hash, see VerifyFragment
+func (fw *FragmentWriter) Verify(proof [][]byte) bool {
+	if fw.leaf == nil || fw.written != fw.fm.FragmentSize(fw.id) {
+		return false
+	}
+	return fw.fm.verifyLeaf(fw.id, fw.leaf.Sum(nil), proof)
+}
+
+// Checksums is a list of fragment hashes ordered by fragment id, saved as a single comma separated column
+type Checksums []string
+
+// Value converts checksums to a database value
+func (c Checksums) Value() (driver.Value, error) {
+	return strings.Join(c, ","), nil
+}
+
+// Scan reads checksums from a database value
+func (c *Checksums) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("Unsupported checksums type %T", value)
+	}
+	*c = nil
+	if s != "" {
+		*c = strings.Split(s, ",")
+	}
+	return nil
+}
+
 // Fragment is a single part of a file
 type Fragment struct {
 	FragmentID int    `gorm:"primary_key;type:INTEGER; DEFAULT:0"`
@@ -95,10 +355,43 @@ func CreateDatabase(dbPath string, verbose bool) (*gorm.DB, error) {
 	}
 	db.Exec("PRAGMA foreign_keys = ON")
 	db.LogMode(verbose)
-	db.AutoMigrate(&FileMetaData{}, &Fragment{})
+	db.AutoMigrate(&FileMetaData{}, &Fragment{}, &FileAccess{})
+	if err := migrateLegacyHashes(db); err != nil {
+		log.Errorf("Failed to migrate legacy hashes. Reason: %s", err)
+		return nil, err
+	}
 	return db, nil
 }
 
+// migrateLegacyHashes prefixes md5 hashes saved by older versions with their algorithm, these files are rebuilt with
+// the default algorithm once published again
+func migrateLegacyHashes(db *gorm.DB) error {
+	var files []FileMetaData
+	db.Where("hash NOT LIKE ?", "%:%").Find(&files)
+	if len(files) == 0 {
+		return nil
+	}
+	tx := db.Begin()
+	for _, fm := range files {
+		hash, err := NormalizeHash(fm.Hash)
+		if err != nil {
+			log.Warnf("Skipped File(%s), unknown hash %s", fm.Name, fm.Hash)
+			continue
+		}
+		log.Infof("Migrating file(name=%s) hash %s to %s", fm.Name, fm.Hash, hash)
+		// Update tables directly, gorm won't update a primary key of a model
+		if err := tx.Table("fragments").Where("hash_id = ?", fm.Hash).Update("hash_id", hash).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Table("file_meta_data").Where("hash = ?", fm.Hash).Update("hash", hash).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
 // List Files available locally for downloading from other peers
 func List(fs afero.Fs, db *gorm.DB) []FileMetaData {
 	var files []FileMetaData
@@ -116,7 +409,19 @@ func List(fs afero.Fs, db *gorm.DB) []FileMetaData {
 }
 
 // Publish a file to be available for sharing, files that aren't published won't show in list or be availble in when seeding.
-func Publish(fs afero.Fs, db *gorm.DB, filePath string) error {
+// New files are identified by a hash of the given algorithm and split to chunks of chunkSize, when chunkSize is 0 it is
+// chosen from the file size. Access to the file is replaced by the allowed peers and groups, a nil list keeps the current
+// access and an empty list makes the file public.
+func Publish(fs afero.Fs, db *gorm.DB, filePath string, algorithm HashAlgorithm, chunkSize int64, allow []string) error {
+	return publish(fs, db, filePath, algorithm, chunkSize, allow, "")
+}
+
+// publish a file, new files are saved with the given encryption, see Publish
+func publish(fs afero.Fs, db *gorm.DB, filePath string, algorithm HashAlgorithm, chunkSize int64, allow []string, encryption string) error {
+	// md5 is only recognized for files of older versions, it isn't collision resistant
+	if algorithm != SHA256 && algorithm != BLAKE3 {
+		return fmt.Errorf("Files can't be published with %q hashes, use %s or %s", algorithm, SHA256, BLAKE3)
+	}
 	ok, _ := afero.Exists(fs, filePath)
 	if !ok {
 		log.Errorf("File to publish %s doesn't exist", filePath)
@@ -125,12 +430,22 @@ func Publish(fs afero.Fs, db *gorm.DB, filePath string) error {
 	log.Info("Checking if meta file exists in database")
 	var fm FileMetaData
 	var err error
-	if db.Where("file_path = ?", filePath).First(&fm).RecordNotFound() {
+	exists := !db.Where("file_path = ?", filePath).First(&fm).RecordNotFound()
+	// Files published by older versions are identified by md5 and don't have fragment hashes, rebuild complete files
+	if exists && fm.Algorithm() == MD5 && fm.Status != Paused && fm.Status != Downloading && fm.Status != Corrupt {
+		log.Infof("File(%s) has a legacy hash %s, rebuilding meta file", fm.Name, fm.Hash)
+		if err := deleteMeta(db, fm); err != nil {
+			return err
+		}
+		exists = false
+	}
+	if !exists {
 		log.Debug("Meta doesn't exist in database, building meta file")
-		fm, err = createMetaFile(fs, filePath)
+		fm, err = createMetaFile(fs, filePath, algorithm, chunkSize)
 		if err != nil {
 			return err
 		}
+		fm.Encryption = encryption
 		// Save fragments information
 		for _, fragment := range fm.AvailableFragments {
 			log.Debugf("Saving fragment id=%d", fragment.FragmentID)
@@ -139,10 +454,19 @@ func Publish(fs afero.Fs, db *gorm.DB, filePath string) error {
 	} else {
 		log.Debug("Meta file exists, only updating status")
 	}
+	if allow != nil {
+		if err := SetAccess(db, fm.Hash, allow); err != nil {
+			return err
+		}
+	}
 	// Don't update files that are paused or downloading.
 	if fm.Status == Paused || fm.Status == Downloading {
 		return nil
 	}
+	if fm.Status == Corrupt {
+		log.Errorf("File(%s) doesn't match its hash, download it again", fm.Name)
+		return errors.New("File is corrupt")
+	}
 	fm.Status = Seeding
 	log.Info("Saving file meta data to database")
 	err = db.Save(&fm).Error
@@ -160,7 +484,7 @@ func Unpublish(db *gorm.DB, fileName string) {
 		log.Debug("Meta file doesn't exist, file is counted as unpublished")
 		return
 	}
-	if fm.Status == Paused {
+	if fm.Status == Paused || fm.Status == Corrupt {
 		log.Warn("file isn't completed, can't be marked as finished")
 		return
 	}
@@ -180,6 +504,11 @@ func Delete(db *gorm.DB, fileName string) error {
 		log.Debug("Meta file doesn't exist, file is counted as deleted")
 		return nil
 	}
+	return deleteMeta(db, fm)
+}
+
+// deleteMeta deletes file meta data and all of its fragments
+func deleteMeta(db *gorm.DB, fm FileMetaData) error {
 	var fragments []Fragment
 	db.Where("hash_id = ?", fm.Hash).Find(&fragments)
 	tx := db.Begin()
@@ -190,6 +519,10 @@ func Delete(db *gorm.DB, fileName string) error {
 			return err
 		}
 	}
+	if err := tx.Where("hash_id = ?", fm.Hash).Delete(&FileAccess{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
 	// Delete file
 	if err := tx.Delete(&fm).Error; err != nil {
 		tx.Rollback()
@@ -199,7 +532,17 @@ func Delete(db *gorm.DB, fileName string) error {
 	return nil
 }
 
-func createMetaFile(fs afero.Fs, filePath string) (FileMetaData, error) {
+// ChooseChunkSize picks a chunk size splitting a file to about 256 fragments, small files use MinChunkSize and large
+// files use MaxChunkSize
+func ChooseChunkSize(size int64) int64 {
+	chunkSize := int64(MinChunkSize)
+	for chunkSize < MaxChunkSize && size/chunkSize > 256 {
+		chunkSize <<= 1
+	}
+	return chunkSize
+}
+
+func createMetaFile(fs afero.Fs, filePath string, algorithm HashAlgorithm, chunkSize int64) (FileMetaData, error) {
 	f, err := fs.Open(filePath)
 	if err != nil {
 		log.Errorf("Failed to open file(%s). Reason: %s", filePath, err)
@@ -212,12 +555,36 @@ func createMetaFile(fs afero.Fs, filePath string) (FileMetaData, error) {
 		log.Error("Failed to find file stats")
 		return FileMetaData{}, err
 	}
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
+	if _, err := algorithm.New(); err != nil {
 		return FileMetaData{}, err
 	}
-	fileHash := hex.EncodeToString(h.Sum(nil))
-	fragmentCount := int(math.Ceil(float64(stats.Size()) / float64(FileChunkSize)))
+	if chunkSize == 0 {
+		chunkSize = ChooseChunkSize(stats.Size())
+	}
+	if chunkSize < 0 || chunkSize > MaxChunkSize {
+		return FileMetaData{}, fmt.Errorf("Chunk size must be between 1 and %d bytes", MaxChunkSize)
+	}
+	log.Debugf("Splitting file to chunks of %d bytes", chunkSize)
+	// Hash every fragment as a leaf of the merkle tree identifying the file
+	var fragmentHashes Checksums
+	var leaves [][]byte
+	buffer := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(f, buffer)
+		if n > 0 {
+			leaf := leafHash(algorithm, buffer[:n])
+			leaves = append(leaves, leaf)
+			fragmentHashes = append(fragmentHashes, hex.EncodeToString(leaf))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return FileMetaData{}, err
+		}
+	}
+	fileHash := FormatHash(algorithm, merkleRoot(algorithm, leaves))
+	fragmentCount := int(math.Ceil(float64(stats.Size()) / float64(chunkSize)))
 	var availableFragments []Fragment
 	for i := 0; i < int(fragmentCount); i++ {
 		availableFragments = append(availableFragments, Fragment{FragmentID: i, HashID: fileHash})
@@ -226,7 +593,9 @@ func createMetaFile(fs afero.Fs, filePath string) (FileMetaData, error) {
 	if err != nil {
 		return FileMetaData{}, err
 	}
-	return FileMetaData{stats.Name(), filePath, host, fileHash, stats.Size(), fragmentCount, availableFragments, Finished}, nil
+	return FileMetaData{Name: stats.Name(), FilePath: filePath, Publisher: host, Hash: fileHash, Size: stats.Size(),
+		ChunkSize: chunkSize, FragmentsCount: fragmentCount, FragmentHashes: fragmentHashes,
+		AvailableFragments: availableFragments, Status: Finished}, nil
 }
 
 // PrintFiles prints an array of files in a human readable table
@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"testing"
 
@@ -12,14 +13,14 @@ import (
 
 // Test status is printable a human readable version
 func TestStatusString(t *testing.T) {
-	s := []Status{Status(0), Status(1), Status(2), Status(3), Status(4)}
-	assert.Equal(t, "[New Paused Downloading Finished Seeding]", fmt.Sprintf("%s", s))
+	s := []Status{Status(0), Status(1), Status(2), Status(3), Status(4), Status(5)}
+	assert.Equal(t, "[New Paused Downloading Finished Seeding Corrupt]", fmt.Sprintf("%s", s))
 }
 
 // Test FileMetaData struct and it's functions
 func TestFileMetaData(t *testing.T) {
 	fragments := []Fragment{Fragment{FragmentID: 0, HashID: "13c405d80e97aa7b46d3389180b19eb3"}, Fragment{FragmentID: 2, HashID: "13c405d80e97aa7b46d3389180b19eb3"}}
-	fm := FileMetaData{"Test", "C:\\file\\path\test.exe", "TestPub", "13c405d80e97aa7b46d3389180b19eb3", 666, 2, fragments, 1}
+	fm := FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: FileChunkSize, FragmentsCount: 2, AvailableFragments: fragments, Status: 1}
 	assert.True(t, fm.FragmentExists(0))
 	assert.False(t, fm.FragmentExists(3))
 }
@@ -44,7 +45,7 @@ func TestList(t *testing.T) {
 	files := List(fs, db)
 	assert.Empty(t, files)
 	fragments := []Fragment{Fragment{FragmentID: 0, HashID: "13c405d80e97aa7b46d3389180b19eb3"}, Fragment{FragmentID: 2, HashID: "13c405d80e97aa7b46d3389180b19eb3"}}
-	fm := FileMetaData{"Test", "C:\\file\\path\test.exe", "TestPub", "13c405d80e97aa7b46d3389180b19eb3", 666, 2, fragments, 1}
+	fm := FileMetaData{Name: "Test", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: FileChunkSize, FragmentsCount: 2, AvailableFragments: fragments, Status: 1}
 	for _, f := range fragments {
 		db.Save(&f)
 	}
@@ -54,7 +55,7 @@ func TestList(t *testing.T) {
 	assert.Len(t, files, 1)
 	assert.Equal(t, files[0], fm)
 	fragments = []Fragment{Fragment{FragmentID: 1, HashID: "13c405d80e97aa7b46d3389180b19eb4"}, Fragment{FragmentID: 3, HashID: "13c405d80e97aa7b46d3389180b19eb4"}}
-	fm2 := FileMetaData{"Test2", "C:\\file\\path\test.exe", "TestPub", "13c405d80e97aa7b46d3389180b19eb4", 666, 3, fragments, 1}
+	fm2 := FileMetaData{Name: "Test2", FilePath: "C:\\file\\path\test.exe", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb4", Size: 666, ChunkSize: FileChunkSize, FragmentsCount: 3, AvailableFragments: fragments, Status: 1}
 	for _, f := range fragments {
 		db.Save(&f)
 	}
@@ -75,7 +76,7 @@ func TestPublish(t *testing.T) {
 	var fm FileMetaData
 	assert.True(t, db.Where("file_path = ?", "file.go").First(&fm).RecordNotFound())
 	// Lets publish the file we are testing
-	err = Publish(fs, db, "file.go")
+	err = Publish(fs, db, "file.go", SHA256, 0, nil)
 	assert.Nil(t, err)
 	// Now we'll check db that all is fine
 	assert.False(t, db.Where("file_path = ?", "file.go").First(&fm).RecordNotFound())
@@ -83,6 +84,11 @@ func TestPublish(t *testing.T) {
 	assert.Len(t, files, 1)
 	assert.Equal(t, files[0].Status, Status(Seeding))
 	assert.Equal(t, files[0].FragmentsCount, 1)
+	// New files are only identified by sha256 or blake3
+	for _, algorithm := range []HashAlgorithm{MD5, "sha1", ""} {
+		assert.NotNil(t, Publish(fs, db, "hash.go", algorithm, 0, nil))
+	}
+	assert.True(t, db.Where("file_path = ?", "hash.go").First(&fm).RecordNotFound())
 }
 
 func TestUnpublish(t *testing.T) {
@@ -94,7 +100,7 @@ func TestUnpublish(t *testing.T) {
 	// Now lets publish a file
 	fs := afero.NewOsFs()
 	var fm FileMetaData
-	err = Publish(fs, db, "file.go")
+	err = Publish(fs, db, "file.go", SHA256, 0, nil)
 	assert.Nil(t, err)
 	assert.False(t, db.Where("file_path = ?", "file.go").First(&fm).RecordNotFound())
 	assert.Equal(t, fm.Status, Status(Seeding))
@@ -105,7 +111,7 @@ func TestUnpublish(t *testing.T) {
 
 func TestCreateMetaFile(t *testing.T) {
 	fs := afero.NewOsFs()
-	fm, err := createMetaFile(fs, "file.go")
+	fm, err := createMetaFile(fs, "file.go", SHA256, 0)
 	assert.Nil(t, err)
 	// File should be 1 fragment (size < 1mb)
 	assert.Equal(t, 1, fm.FragmentsCount)
@@ -113,7 +119,147 @@ func TestCreateMetaFile(t *testing.T) {
 	assert.Equal(t, "file.go", fm.Name)
 	assert.Equal(t, "file.go", fm.FilePath)
 	assert.Len(t, fm.AvailableFragments, 1)
+	assert.Len(t, fm.FragmentHashes, 1)
+	assert.Equal(t, SHA256, fm.Algorithm())
+	data, err := afero.ReadFile(fs, "file.go")
+	assert.Nil(t, err)
+	assert.True(t, fm.VerifyFragment(0, data, nil))
+	assert.True(t, fm.VerifyFragmentHashes())
+	assert.Equal(t, int64(MinChunkSize), fm.ChunkSize)
+	// Smaller chunks split the file to more fragments, and identify it by another hash
+	small, err := createMetaFile(fs, "file.go", SHA256, 4096)
+	assert.Nil(t, err)
+	assert.Equal(t, int(math.Ceil(float64(fm.Size)/4096)), small.FragmentsCount)
+	assert.NotEqual(t, fm.Hash, small.Hash)
+	proof, err := small.FragmentProof(1)
+	assert.Nil(t, err)
+	assert.True(t, small.VerifyFragment(1, data[4096:8192], proof))
+	// The whole file is verified against its hash
+	f, err := fs.Open("file.go")
+	assert.Nil(t, err)
+	defer f.Close()
+	ok, err := small.VerifyFile(f)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	small.Size--
+	ok, _ = small.VerifyFile(f)
+	assert.False(t, ok)
+	// Sizes must split to the fragment count, padding doesn't verify
+	small.Size += 4096
+	ok, err = small.VerifyFile(f)
+	assert.False(t, ok)
+	assert.NotNil(t, err)
+	legacy := FileMetaData{Hash: FormatHash(MD5, MD5.sum(data)), Size: int64(len(data))}
+	ok, err = legacy.VerifyFile(f)
+	assert.True(t, ok)
+	_, err = createMetaFile(fs, "file.go", SHA256, MaxChunkSize+1)
+	assert.NotNil(t, err)
 	// file shouldn't exist
-	fm, err = createMetaFile(fs, "fildde.go")
+	fm, err = createMetaFile(fs, "fildde.go", SHA256, 0)
 	assert.NotNil(t, err)
 }
+
+// Test chunk size grows with the file size
+func TestChooseChunkSize(t *testing.T) {
+	assert.Equal(t, int64(MinChunkSize), ChooseChunkSize(0))
+	assert.Equal(t, int64(MinChunkSize), ChooseChunkSize(64*(1<<20)))
+	assert.Equal(t, int64(1<<20), ChooseChunkSize(256*(1<<20)))
+	assert.Equal(t, int64(MaxChunkSize), ChooseChunkSize(8*(1<<30)))
+	// Files without a chunk size were published by older versions
+	assert.Equal(t, int64(FileChunkSize), FileMetaData{}.Chunk())
+}
+
+// Test fragments are verified with their proofs against the file hash, or against trusted fragment hashes
+func TestVerifyFragment(t *testing.T) {
+	data := [][]byte{[]byte("fragment 0"), []byte("fragment 1"), []byte("fragment 2")}
+	var leaves [][]byte
+	var hashes Checksums
+	for _, d := range data {
+		leaves = append(leaves, leafHash(SHA256, d))
+		hashes = append(hashes, SHA256.Sum(append([]byte{0}, d...)))
+	}
+	fm := FileMetaData{Hash: FormatHash(SHA256, merkleRoot(SHA256, leaves)), Size: 2*FileChunkSize + 10, FragmentsCount: 3}
+	proof := merkleProof(SHA256, leaves, 1)
+	assert.True(t, fm.VerifyFragment(1, data[1], proof))
+	assert.False(t, fm.VerifyFragment(1, []byte("corrupted data"), proof))
+	assert.False(t, fm.VerifyFragment(0, data[1], proof))
+	assert.False(t, fm.VerifyFragment(1, data[1], nil))
+	// Without a proof fragments are checked against the fragment hashes
+	fm.FragmentHashes = hashes
+	assert.True(t, fm.VerifyFragmentHashes())
+	assert.True(t, fm.VerifyFragment(1, data[1], nil))
+	assert.False(t, fm.VerifyFragment(3, data[1], nil))
+	proof, err := fm.FragmentProof(2)
+	assert.Nil(t, err)
+	assert.True(t, fm.VerifyFragment(2, data[2], proof))
+	// Fragment hashes that don't build the file hash can't be trusted
+	fm.FragmentHashes = Checksums{hashes[0], hashes[0], hashes[2]}
+	assert.False(t, fm.VerifyFragmentHashes())
+	assert.Equal(t, int64(FileChunkSize), fm.FragmentSize(0))
+	assert.Equal(t, int64(10), fm.FragmentSize(2))
+}
+
+// Test fragments written in frames are placed at their offset and verified, padding is discarded
+func TestFragmentWriter(t *testing.T) {
+	data := [][]byte{make([]byte, FileChunkSize), []byte("last fragment")}
+	var leaves [][]byte
+	for _, d := range data {
+		leaves = append(leaves, leafHash(SHA256, d))
+	}
+	fm := FileMetaData{Hash: FormatHash(SHA256, merkleRoot(SHA256, leaves)), Size: FileChunkSize + 13, FragmentsCount: 2}
+	f, err := afero.NewMemMapFs().Create("test.bin")
+	assert.Nil(t, err)
+	fw := fm.NewFragmentWriter(f, 1)
+	fw.Write([]byte("last "))
+	assert.False(t, fw.Verify(merkleProof(SHA256, leaves, 1)))
+	n, err := fw.Write(append([]byte("fragment"), 0, 0, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, n)
+	assert.True(t, fw.Verify(merkleProof(SHA256, leaves, 1)))
+	written := make([]byte, 13)
+	f.ReadAt(written, FileChunkSize)
+	assert.Equal(t, data[1], written)
+	// Data of another fragment fails verification
+	fw = fm.NewFragmentWriter(f, 0)
+	fw.Write(append(make([]byte, FileChunkSize-1), 1))
+	assert.False(t, fw.Verify(merkleProof(SHA256, leaves, 0)))
+}
+
+// Test fragment hashes are saved and loaded from the database
+func TestChecksums(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	defer db.Close()
+	assert.Nil(t, err)
+	fm := FileMetaData{Name: "Test", Hash: "13c405d80e97aa7b46d3389180b19eb3", FragmentsCount: 2, FragmentHashes: Checksums{"aa", "bb"}}
+	db.Save(&fm)
+	var loaded FileMetaData
+	assert.False(t, db.Where("hash = ?", fm.Hash).First(&loaded).RecordNotFound())
+	assert.Equal(t, fm.FragmentHashes, loaded.FragmentHashes)
+}
+
+// Test md5 hashes saved by older versions are migrated and rebuilt on publish
+func TestMigrateLegacyHashes(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fragments := []Fragment{Fragment{FragmentID: 0, HashID: "13c405d80e97aa7b46d3389180b19eb3"}}
+	fm := FileMetaData{Name: "file.go", FilePath: "file.go", Publisher: "TestPub", Hash: "13c405d80e97aa7b46d3389180b19eb3", Size: 666, ChunkSize: FileChunkSize, FragmentsCount: 1, AvailableFragments: fragments, Status: Seeding}
+	db.Save(&fragments[0])
+	db.Save(&fm)
+	db.Close()
+	db, err = CreateDatabase("test.db", false)
+	defer db.Close()
+	assert.Nil(t, err)
+	files := List(afero.NewOsFs(), db)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "md5:13c405d80e97aa7b46d3389180b19eb3", files[0].Hash)
+	assert.Equal(t, "md5:13c405d80e97aa7b46d3389180b19eb3", files[0].AvailableFragments[0].HashID)
+	// Publishing again rebuilds the file with the requested algorithm
+	err = Publish(afero.NewOsFs(), db, "file.go", BLAKE3, 0, nil)
+	assert.Nil(t, err)
+	files = List(afero.NewOsFs(), db)
+	assert.Len(t, files, 1)
+	assert.Equal(t, BLAKE3, files[0].Algorithm())
+	assert.Len(t, files[0].FragmentHashes, 1)
+}
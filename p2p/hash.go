@@ -0,0 +1,105 @@
+package p2p
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// HashAlgorithm is the algorithm used to identify files, every file hash is prefixed with it i.e sha256:<digest>.
+// The digest of a file is the root of a merkle tree over its fragments, except for legacy md5 hashes.
+type HashAlgorithm string
+
+const (
+	// MD5 is only kept to recognize files published by older versions, it isn't used to publish new files
+	MD5 HashAlgorithm = "md5"
+	// SHA256 is the default algorithm for publishing files
+	SHA256 HashAlgorithm = "sha256"
+	// BLAKE3 is a faster alternative to sha256 for large files
+	BLAKE3 HashAlgorithm = "blake3"
+)
+
+// DefaultHashAlgorithm is used when no algorithm is specified
+const DefaultHashAlgorithm = SHA256
+
+var hashAlgorithms = map[HashAlgorithm]func() hash.Hash{
+	MD5:    md5.New,
+	SHA256: sha256.New,
+	BLAKE3: func() hash.Hash { return blake3.New(32, nil) },
+}
+
+// New creates a new hash of the algorithm
+func (a HashAlgorithm) New() (hash.Hash, error) {
+	h, ok := hashAlgorithms[a]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported hash algorithm %s", a)
+	}
+	return h(), nil
+}
+
+// Sum returns the hex encoded digest of data, unsupported algorithms return an empty digest
+func (a HashAlgorithm) Sum(data []byte) string {
+	return hex.EncodeToString(a.sum(data))
+}
+
+// sum returns the digest of all parts, unsupported algorithms return a nil digest
+func (a HashAlgorithm) sum(parts ...[]byte) []byte {
+	h, err := a.New()
+	if err != nil {
+		return nil
+	}
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// fragmentAlgorithm returns the algorithm used to hash the fragments of a file, legacy md5 files aren't identified
+// by a merkle root and use sha256 for their fragments
+func (a HashAlgorithm) fragmentAlgorithm() HashAlgorithm {
+	if a == MD5 {
+		return SHA256
+	}
+	return a
+}
+
+// FormatHash creates a self describing file hash from an algorithm and a digest
+func FormatHash(a HashAlgorithm, digest []byte) string {
+	return fmt.Sprintf("%s:%s", a, hex.EncodeToString(digest))
+}
+
+// ParseHash splits a file hash into its algorithm and hex digest. Hashes without an algorithm are legacy md5 hashes
+// (32 characters) or sha256 hashes (64 characters).
+func ParseHash(fileHash string) (HashAlgorithm, string, error) {
+	var algorithm HashAlgorithm
+	digest := strings.ToLower(fileHash)
+	if i := strings.Index(digest, ":"); i >= 0 {
+		algorithm, digest = HashAlgorithm(digest[:i]), digest[i+1:]
+	} else if len(digest) == 2*md5.Size {
+		algorithm = MD5
+	} else {
+		algorithm = SHA256
+	}
+	h, err := algorithm.New()
+	if err != nil {
+		return "", "", err
+	}
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != h.Size() {
+		return "", "", fmt.Errorf("Invalid %s hash %s", algorithm, fileHash)
+	}
+	return algorithm, digest, nil
+}
+
+// NormalizeHash returns the self describing form of a file hash, allowing legacy hashes to be used
+func NormalizeHash(fileHash string) (string, error) {
+	algorithm, digest, err := ParseHash(fileHash)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s", algorithm, digest), nil
+}
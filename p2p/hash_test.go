@@ -0,0 +1,42 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test hashes are parsed with their algorithm and legacy hashes are recognized
+func TestParseHash(t *testing.T) {
+	algorithm, digest, err := ParseHash("13c405d80e97aa7b46d3389180b19eb3")
+	assert.Nil(t, err)
+	assert.Equal(t, MD5, algorithm)
+	assert.Equal(t, "13c405d80e97aa7b46d3389180b19eb3", digest)
+	sha := SHA256.Sum([]byte("data"))
+	algorithm, digest, err = ParseHash("sha256:" + sha)
+	assert.Nil(t, err)
+	assert.Equal(t, SHA256, algorithm)
+	assert.Equal(t, sha, digest)
+	algorithm, _, err = ParseHash(sha)
+	assert.Nil(t, err)
+	assert.Equal(t, SHA256, algorithm)
+	algorithm, _, err = ParseHash("blake3:" + BLAKE3.Sum([]byte("data")))
+	assert.Nil(t, err)
+	assert.Equal(t, BLAKE3, algorithm)
+	// Wrong digest size, unknown algorithms and bad hex aren't valid
+	_, _, err = ParseHash("md5:" + sha)
+	assert.Error(t, err)
+	_, _, err = ParseHash("crc32:" + sha)
+	assert.Error(t, err)
+	_, _, err = ParseHash("sha256:xyz")
+	assert.Error(t, err)
+}
+
+func TestNormalizeHash(t *testing.T) {
+	hash, err := NormalizeHash("13C405D80E97AA7B46D3389180B19EB3")
+	assert.Nil(t, err)
+	assert.Equal(t, "md5:13c405d80e97aa7b46d3389180b19eb3", hash)
+	hash, err = NormalizeHash(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, "md5:13c405d80e97aa7b46d3389180b19eb3", hash)
+}
@@ -0,0 +1,317 @@
+package http
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fileshare/p2p"
+	"fileshare/p2p/rpc"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// P2PClient of http package allows connection to http based servers
+type P2PClient struct {
+	name     string
+	url      string
+	client   *http.Client
+	swarmKey string
+	policy   p2p.CallPolicy
+
+	// peer describes the node once the handshake is done
+	mu   sync.Mutex
+	peer *p2p.PeerInfo
+}
+
+// NewClient creates a new http client to connect to a remote peer
+func NewClient(name string, addr string, port int) (p2p.Client, error) {
+	return ClientFactory(rpc.TLSConfig{}, "", p2p.DefaultCallPolicy)(name, addr, port)
+}
+
+// ClientFactory creates http clients connecting with TLS when the config is enabled, the certificate of every remote
+// peer must be issued for its name. In a private swarm every request proves holding the swarm key. Requests are
+// bounded and retried by policy.
+func ClientFactory(config rpc.TLSConfig, swarmKey string, policy p2p.CallPolicy) p2p.CreateClient {
+	return func(name string, addr string, port int) (p2p.Client, error) {
+		scheme := "http"
+		transport := &http.Transport{}
+		if config.Enabled() {
+			tlsConfig, err := config.ClientConfig(name)
+			if err != nil {
+				log.Errorf("Failed to load TLS configuration. Reason: %s", err)
+				return nil, err
+			}
+			scheme = "https"
+			transport.TLSClientConfig = tlsConfig
+		}
+		host := net.JoinHostPort(addr, strconv.Itoa(port))
+		return &P2PClient{name: fmt.Sprintf("%s@%d", name, port), url: fmt.Sprintf("%s://%s", scheme, host),
+			client: &http.Client{Transport: transport}, swarmKey: swarmKey, policy: policy}, nil
+	}
+}
+
+// handshake requests the description of the node before the first request, nodes without one are legacy peers. Nodes
+// speaking an incompatible protocol version are refused.
+func (p *P2PClient) handshake(ctx context.Context) (p2p.PeerInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peer != nil {
+		return *p.peer, p.peer.Compatible()
+	}
+	info := p2p.LegacyPeerInfo(p.Name())
+	var reply Hello
+	err := p.policy.Call(ctx, p.policy.ListTimeout, transient, func(ctx context.Context) error {
+		return p.getJSON(ctx, "/hello", &reply)
+	})
+	statusErr, _ := err.(*statusError)
+	switch {
+	case statusErr != nil && statusErr.code == http.StatusNotFound:
+		log.Debugf("%s doesn't support the handshake, assuming protocol version %d", p.Name(), info.ProtocolVersion)
+	case err != nil:
+		return info, err
+	default:
+		info = p2p.PeerInfo{NodeID: reply.NodeID, ProtocolVersion: reply.ProtocolVersion,
+			MinProtocolVersion: reply.MinProtocolVersion, SoftwareVersion: reply.SoftwareVersion, Capabilities: reply.Capabilities}
+	}
+	p.peer = &info
+	if err := info.Compatible(); err != nil {
+		log.Warnf("Refused %s. Reason: %s", p.Name(), err)
+		return info, err
+	}
+	log.Debugf("Connected to %s", info)
+	return info, nil
+}
+
+// List remote files on remote node
+func (p *P2PClient) List(ctx context.Context) ([]p2p.FileMetaData, error) {
+	remote, err := p.handshake(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reply []MetaData
+	err = p.policy.Call(ctx, p.policy.ListTimeout, transient, func(ctx context.Context) error {
+		return p.getJSON(ctx, "/files", &reply)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var files []p2p.FileMetaData
+	for _, f := range reply {
+		hash, err := p2p.NormalizeHash(f.Hash)
+		if err != nil {
+			log.Debugf("Skipped file %s. Reason: %s", f.Name, err)
+			continue
+		}
+		// Statuses of newer protocol versions would be misread
+		if !remote.KnownStatus(f.Status) {
+			log.Debugf("Skipped file %s, status %d is unknown to protocol version %d", f.Name, f.Status, remote.Version())
+			continue
+		}
+		var fragments []p2p.Fragment
+		for _, id := range f.AvailableFragments {
+			fragments = append(fragments, p2p.Fragment{FragmentID: id, HashID: hash})
+		}
+		files = append(files, p2p.FileMetaData{Name: f.Name, FilePath: "", Publisher: p.Name(),
+			Hash: hash, Size: f.Size, ChunkSize: f.ChunkSize, Encryption: f.Encryption, FragmentsCount: f.FragmentCount,
+			FragmentHashes: f.FragmentHashes, AvailableFragments: fragments, Status: f.Status})
+	}
+	return files, nil
+}
+
+// Name of client
+func (p *P2PClient) Name() string {
+	return p.name
+}
+
+// Download a fragment of a file from remote client, the body of the reply is written to w as it is received
+func (p *P2PClient) Download(ctx context.Context, fileHash string, fragmentID int, w io.Writer, out chan p2p.DownloadResult) {
+	_, err := p.handshake(ctx)
+	var proof [][]byte
+	if err == nil {
+		written := p2p.NewCountingWriter(w)
+		err = p.policy.Download(ctx, written, transient, func(ctx context.Context) (err error) {
+			proof, err = p.fragment(ctx, fileHash, fragmentID, written)
+			return err
+		})
+	}
+	if err == nil {
+		out <- p2p.DownloadResult{FragmentID: fragmentID, PeerName: p.Name(), Proof: proof, Successful: true}
+		return
+	}
+	if ctx.Err() == context.Canceled {
+		return
+	}
+	log.Debugf("Failed to download fragment. Reason: %s", err)
+	out <- p2p.DownloadResult{FragmentID: fragmentID, PeerName: p.Name(), Successful: false}
+}
+
+// fragment writes the body of a fragment to w, returning the proof of the fragment
+func (p *P2PClient) fragment(ctx context.Context, fileHash string, fragmentID int, w io.Writer) ([][]byte, error) {
+	header := http.Header{"Accept-Encoding": {strings.Join(p2p.CompressionNames(p2p.Compressions), ", ")}}
+	resp, err := p.get(ctx, fmt.Sprintf("/files/%s/fragments/%d", url.PathEscape(fileHash), fragmentID), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var proof [][]byte
+	if header := resp.Header.Get(ProofHeader); header != "" {
+		for _, h := range strings.Split(header, ",") {
+			b, err := hex.DecodeString(h)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid proof. Reason: %s", err)
+			}
+			proof = append(proof, b)
+		}
+	}
+	stats := p2p.CompressionStats{Compression: p2p.Compression(resp.Header.Get("Content-Encoding"))}
+	body := &countingReader{r: resp.Body}
+	decompressed, err := p2p.NewDecompressReader(stats.Compression, body)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+	start := time.Now()
+	n, err := io.Copy(w, decompressed)
+	if err != nil {
+		return nil, err
+	}
+	stats.Add(int(n), int(body.n), time.Since(start))
+	log.Debugf("Received fragment(%d)@%s, %s", fragmentID, p.Name(), stats)
+	return proof, nil
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// FragmentsAvailable returns the fragments of a file available on remote client, an error means the fragments are
+// unknown, not that there are none
+func (p *P2PClient) FragmentsAvailable(ctx context.Context, fileHash string) ([]int, error) {
+	if _, err := p.handshake(ctx); err != nil {
+		return nil, err
+	}
+	var reply FragmentsReply
+	err := p.policy.Call(ctx, p.policy.FragmentsTimeout, transient, func(ctx context.Context) error {
+		return p.getJSON(ctx, fmt.Sprintf("/files/%s/fragments", url.PathEscape(fileHash)), &reply)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reply.AvailableFragments == nil {
+		return make([]int, 0), nil
+	}
+	return reply.AvailableFragments, nil
+}
+
+// PeerExchange returns the peers the remote node knows about, nodes without the pex capability know none
+func (p *P2PClient) PeerExchange(ctx context.Context) ([]p2p.DiscoveryPayload, error) {
+	remote, err := p.handshake(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !remote.Supports(p2p.PeerExchangeCapability) {
+		return nil, nil
+	}
+	var reply PeersReply
+	err = p.policy.Call(ctx, p.policy.ListTimeout, transient, func(ctx context.Context) error {
+		return p.getJSON(ctx, "/peers", &reply)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var peers []p2p.DiscoveryPayload
+	for _, peer := range reply.Peers {
+		peers = append(peers, p2p.DiscoveryPayload{Name: peer.Name, Addr: peer.Addr, Port: peer.Port})
+	}
+	return peers, nil
+}
+
+// Alive checks if http client is alive, http clients connect on every request so they are always alive
+func (p *P2PClient) Alive() bool {
+	return true
+}
+
+// Close closes the idle connections to the remote node, connections in use are closed once their requests are done
+func (p *P2PClient) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
+
+// get sends a GET request with optional headers to the node, replies that aren't successful are returned as errors
+func (p *P2PClient) get(ctx context.Context, path string, header ...http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for _, h := range header {
+		for k, v := range h {
+			req.Header[k] = v
+		}
+	}
+	if p.swarmKey != "" {
+		req.Header.Set(SwarmHeader, p2p.SwarmToken(p.swarmKey, p2p.SwarmResource(req), time.Now()))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &statusError{resp.StatusCode, fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(message)))}
+	}
+	return resp, nil
+}
+
+// statusError is the error of a reply that isn't successful
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// transient checks if a request failed with an error that may not happen again, such as a connection error, a timeout
+// or an overloaded node
+func transient(err error) bool {
+	if statusErr, ok := err.(*statusError); ok {
+		switch statusErr.code {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// getJSON sends a GET request to the node and decodes the JSON reply into v
+func (p *P2PClient) getJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := p.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,439 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fileshare/p2p"
+	"fileshare/p2p/rpc"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/afero"
+)
+
+// Headers sent with requests and replies
+const (
+	// SwarmHeader holds the token proving the swarm key sent with every request, see p2p.SwarmToken
+	SwarmHeader = "X-Fileshare-Swarm"
+	// ProofHeader holds the inclusion proof of a fragment, comma separated hex hashes
+	ProofHeader = "X-Fileshare-Proof"
+)
+
+// MetaData describes a file in the JSON listing of a node
+type MetaData struct {
+	Name               string     `json:"name"`
+	Publisher          string     `json:"publisher"`
+	Hash               string     `json:"hash"`
+	Size               int64      `json:"size"`
+	ChunkSize          int64      `json:"chunkSize"`
+	Encryption         string     `json:"encryption,omitempty"`
+	FragmentCount      int        `json:"fragmentCount"`
+	AvailableFragments []int      `json:"availableFragments"`
+	Status             p2p.Status `json:"status"`
+	// FragmentHashes holds the hash of every fragment ordered by fragment id
+	FragmentHashes []string `json:"fragmentHashes,omitempty"`
+}
+
+// Hello describes a node and the protocol versions it speaks, clients request it before any other request. See
+// p2p.PeerInfo
+type Hello struct {
+	NodeID             string   `json:"nodeId"`
+	ProtocolVersion    int      `json:"protocolVersion"`
+	MinProtocolVersion int      `json:"minProtocolVersion"`
+	SoftwareVersion    string   `json:"softwareVersion"`
+	Capabilities       []string `json:"capabilities"`
+}
+
+// FragmentsReply lists the fragments of a file available on a node
+type FragmentsReply struct {
+	AvailableFragments []int `json:"availableFragments"`
+	// FragmentHashes holds the hash of every fragment ordered by fragment id, empty when no fragments are available
+	FragmentHashes []string `json:"fragmentHashes,omitempty"`
+}
+
+// PeerAddress is the address a peer serves files on
+type PeerAddress struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+	Port int    `json:"port"`
+}
+
+// PeersReply lists the peers a node discovered recently, most recently first
+type PeersReply struct {
+	Peers []PeerAddress `json:"peers"`
+}
+
+// Node is an HTTP server that serves files to P2PClients, browsers and any other HTTP client.
+//
+//	GET /hello                               JSON description of the node, see Hello
+//	GET /peers                               JSON list of the peers the node knows, see PeersReply
+//	GET /files                               JSON listing of the files
+//	GET /files/<hash>                        the whole file, Range requests are supported
+//	GET /files/<hash>/fragments              JSON list of the available fragments
+//	GET /files/<hash>/fragments/<id>         a single fragment with its proof, Range requests are supported
+type Node struct {
+	ServiceName string
+	fs          afero.Fs
+	db          *gorm.DB
+	seedPartial bool
+
+	// limits the upload rate to all peers and to every peer, nil when there is no limit
+	limits *p2p.PeerLimiters
+
+	// tls secures connections to the node, connections are insecure when it isn't enabled
+	tls rpc.TLSConfig
+	// swarm admits requests of peers holding the pre-shared key of a private swarm, nil when the swarm is public
+	swarm *p2p.SwarmGate
+	// peers are the peers known to the node, sent to peers asking for them. nil when the node knows no peers
+	peers *p2p.PeerBook
+}
+
+// NewNode creates a new Node to serve incoming requests on the network
+func NewNode(name, dbPath string, verbose bool) *Node {
+	db, err := p2p.CreateDatabase(dbPath, verbose)
+	if err != nil {
+		log.Fatalf("Failed to create Database. Reason: %s", err)
+		return nil
+	}
+	return &Node{ServiceName: name, fs: afero.NewOsFs(), db: db}
+}
+
+// SetTLS secures connections to the node with TLS, or mutual TLS to only allow peers with a certificate signed by the CA
+func (r *Node) SetTLS(config rpc.TLSConfig) {
+	r.tls = config
+}
+
+// SetSwarmKey makes the node part of a private swarm, only peers holding the key can send requests
+func (r *Node) SetSwarmKey(key string) {
+	if key != "" {
+		r.swarm = p2p.NewSwarmGate(key)
+	}
+}
+
+// SetPeerBook sets the peers known to the node, they are sent to peers asking for them
+func (r *Node) SetPeerBook(book *p2p.PeerBook) {
+	r.peers = book
+}
+
+// SetUploadLimits limits the bytes per second uploaded to all peers, and to every single peer. 0 means no limit
+func (r *Node) SetUploadLimits(upload, peerUpload int64) {
+	r.limits = p2p.NewPeerLimiters(upload, peerUpload)
+}
+
+// ================================================================================================================= //
+// *										Server interface implementation										   * //
+// ================================================================================================================= //
+
+// Seed listens and becomes discoverable for incoming list/downlaod requests on the share network, it stops once ctx is
+// done
+func (r *Node) Seed(ctx context.Context, resolver p2p.PeerResolver, addr string, port int, seedPartial bool) {
+	// seed paused / partial files if requested by user
+	if seedPartial {
+		r.seedPartial = true
+	}
+	log.Infof("Listening on %s:%d", addr, port)
+	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", addr, port))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	if r.tls.Enabled() {
+		config, err := r.tls.ServerConfig()
+		if err != nil {
+			log.Fatalf("failed to load TLS configuration: %v", err)
+		}
+		log.Infof("TLS enabled, mutual=%t", r.tls.Mutual)
+		lis = tls.NewListener(lis, config)
+	}
+	var restricted int
+	r.db.Model(&p2p.FileAccess{}).Count(&restricted)
+	if restricted > 0 && !r.tls.Mutual {
+		log.Warn("Peers are identified only with mutual TLS, files with access lists won't be available")
+	}
+	server := &http.Server{Handler: r.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	// Only listen so other peers will be able to discover this node
+	go resolver.Listen(ctx, addr)
+	// start listening
+	if err := server.Serve(lis); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+// Handler returns the handler serving the node's files
+func (r *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", r.intercept(r.hello))
+	mux.HandleFunc("/peers", r.intercept(r.peerExchange))
+	mux.HandleFunc("/files", r.intercept(r.list))
+	mux.HandleFunc("/files/", r.intercept(r.file))
+	return mux
+}
+
+// ================================================================================================================= //
+// *										HTTP handlers implementation										   * //
+// ================================================================================================================= //
+
+// hello replies with the description of the node, clients check they speak a compatible protocol version
+func (r *Node) hello(w http.ResponseWriter, req *http.Request) {
+	log.Infof("Received hello from %s", requester(req))
+	info := p2p.LocalPeerInfo(r.ServiceName)
+	writeJSON(w, Hello{NodeID: info.NodeID, ProtocolVersion: info.ProtocolVersion, MinProtocolVersion: info.MinProtocolVersion,
+		SoftwareVersion: info.SoftwareVersion, Capabilities: info.Capabilities})
+}
+
+// peerExchange replies with the peers the node discovered recently, so peers can reach nodes they can't discover
+func (r *Node) peerExchange(w http.ResponseWriter, req *http.Request) {
+	peers := r.peers.Peers(p2p.MaxExchangedPeers)
+	log.Debugf("Sending %d peers to %s", len(peers), requester(req))
+	reply := PeersReply{Peers: []PeerAddress{}}
+	for _, peer := range peers {
+		reply.Peers = append(reply.Peers, PeerAddress{Name: peer.Name, Addr: peer.Addr, Port: peer.Port})
+	}
+	writeJSON(w, reply)
+}
+
+// TrackerFiles returns the files the node announces to trackers while seeding
+func (r *Node) TrackerFiles() []p2p.TrackerFile {
+	return p2p.TrackerFiles(r.fs, r.db, r.seedPartial)
+}
+
+// list replies with the files available for download
+func (r *Node) list(w http.ResponseWriter, req *http.Request) {
+	log.Infof("Received list request from %s", requester(req))
+	ff := p2p.ServedFiles(r.fs, r.db, r.seedPartial, requestPeer(req))
+	files := []MetaData{}
+	for _, f := range ff {
+		fragments := []int{}
+		for _, fragment := range f.AvailableFragments {
+			fragments = append(fragments, fragment.FragmentID)
+		}
+		m := MetaData{Name: f.Name,
+			Publisher:          r.ServiceName,
+			Hash:               f.Hash,
+			Size:               f.Size,
+			ChunkSize:          f.ChunkSize,
+			Encryption:         f.Encryption,
+			FragmentCount:      f.FragmentsCount,
+			AvailableFragments: fragments,
+			Status:             f.Status,
+			FragmentHashes:     f.FragmentHashes,
+		}
+		log.Infof("Added file %s (hash=%s, fragments=%d/%d, size=%d, status=%s)",
+			m.Name, f.Hash, len(m.AvailableFragments), m.FragmentCount, m.Size, f.Status)
+		files = append(files, m)
+	}
+	writeJSON(w, files)
+}
+
+// file routes requests for a single file, /files/<hash>[/fragments[/<id>]]
+func (r *Node) file(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/files/"), "/")
+	switch {
+	case len(parts) == 1:
+		r.download(w, req, parts[0])
+	case len(parts) == 2 && parts[1] == "fragments":
+		r.fragmentsAvailable(w, req, parts[0])
+	case len(parts) == 3 && parts[1] == "fragments":
+		id, err := strconv.Atoi(parts[2])
+		if err != nil {
+			http.Error(w, "Invalid fragment id", http.StatusBadRequest)
+			return
+		}
+		r.fragment(w, req, parts[0], id)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// download sends a whole file, only complete files can be downloaded
+func (r *Node) download(w http.ResponseWriter, req *http.Request, hash string) {
+	log.Infof("Received download request for file(hash=%s, range=%s) from %s", hash, req.Header.Get("Range"), requester(req))
+	fm, f, err := r.openFile(req, hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	if fm.Status != p2p.Seeding {
+		http.Error(w, "File isn't complete, download its fragments", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fm.Name}))
+	http.ServeContent(r.limit(w, req), req, fm.Name, time.Time{}, io.NewSectionReader(f, 0, fm.Size))
+}
+
+// fragment sends a fragment of a file with its inclusion proof
+func (r *Node) fragment(w http.ResponseWriter, req *http.Request, hash string, id int) {
+	log.Infof("Received download request for fragment file(hash=%s, fragment=%d) from %s", hash, id, requester(req))
+	fm, f, err := r.openFile(req, hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	if id < 0 || id >= fm.FragmentsCount {
+		http.Error(w, fmt.Sprintf("Fragment %d doesn't exist", id), http.StatusNotFound)
+		return
+	}
+	// Partial seeders may not know all fragment hashes, peers will verify these fragments without a proof
+	proof, err := fm.FragmentProof(id)
+	if err != nil {
+		log.Debugf("Failed to create fragment proof. Reason: %s", err)
+	}
+	var hashes []string
+	for _, p := range proof {
+		hashes = append(hashes, hex.EncodeToString(p))
+	}
+	if len(hashes) > 0 {
+		w.Header().Set(ProofHeader, strings.Join(hashes, ","))
+	}
+	fragment := io.NewSectionReader(f, fm.FragmentOffset(id), fm.FragmentSize(id))
+	w.Header().Add("Vary", "Accept-Encoding")
+	// Ranges are of the fragment's data, so only whole fragments are compressed
+	if c := p2p.NegotiateCompression(acceptedEncodings(req)); c != p2p.NoCompression && req.Header.Get("Range") == "" {
+		data := make([]byte, fm.FragmentSize(id))
+		if _, err := io.ReadFull(fragment, data); err != nil {
+			log.Errorf("Failed to read. Reason: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stats := p2p.CompressionStats{Compression: c}
+		start := time.Now()
+		compressed, compression := p2p.Compress(c, data)
+		stats.Add(len(data), len(compressed), time.Since(start))
+		log.Debugf("Sending fragment(%d) of file(hash=%s), %s", id, fm.Hash, stats)
+		if compression != p2p.NoCompression {
+			w.Header().Set("Content-Encoding", string(compression))
+			w.Header().Set("Content-Length", strconv.Itoa(len(compressed)))
+			r.limit(w, req).Write(compressed)
+			return
+		}
+		fragment = io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data)))
+	}
+	http.ServeContent(r.limit(w, req), req, "", time.Time{}, fragment)
+}
+
+// acceptedEncodings returns the encodings of the Accept-Encoding header by preference, encodings the client refuses with
+// q=0 are skipped
+func acceptedEncodings(req *http.Request) []string {
+	var encodings []string
+	for _, header := range req.Header["Accept-Encoding"] {
+		for _, entry := range strings.Split(header, ",") {
+			parts := strings.Split(entry, ";")
+			refused := false
+			for _, param := range parts[1:] {
+				if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+					weight, err := strconv.ParseFloat(q[2:], 64)
+					refused = err != nil || weight == 0
+				}
+			}
+			if !refused {
+				encodings = append(encodings, strings.TrimSpace(parts[0]))
+			}
+		}
+	}
+	return encodings
+}
+
+// fragmentsAvailable replies with the fragments of a file available on the node
+func (r *Node) fragmentsAvailable(w http.ResponseWriter, req *http.Request, hash string) {
+	fragments, hashes, err := p2p.ServedFragments(r.db, hash, r.seedPartial, requestPeer(req))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, FragmentsReply{AvailableFragments: fragments, FragmentHashes: hashes})
+}
+
+// intercept rejects requests from peers outside of the swarm, and requests that don't read files
+func (r *Node) intercept(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.swarm != nil {
+			if err := r.swarm.Admit(req.Header.Get(SwarmHeader), p2p.SwarmResource(req), time.Now()); err != nil {
+				log.Warnf("Rejected %s from %s. Reason: %s", req.URL.Path, requester(req), err)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		handler(w, req)
+	}
+}
+
+// limitedResponse writes the body of a response through the upload limiters
+type limitedResponse struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (l limitedResponse) Write(p []byte) (int, error) {
+	return l.w.Write(p)
+}
+
+// limit limits the upload rate of a response to the peer sending the request
+func (r *Node) limit(w http.ResponseWriter, req *http.Request) http.ResponseWriter {
+	var limited io.Writer = w
+	for _, l := range r.limits.Limiters(requester(req)) {
+		limited = p2p.LimitWriter(req.Context(), limited, l)
+	}
+	return limitedResponse{w, limited}
+}
+
+// requester returns the verified identity of the peer sending a request, peers without one are identified by their host
+// since they connect from different ports
+func requester(req *http.Request) string {
+	if identity, _, ok := peerIdentity(req); ok {
+		return identity
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// peerIdentity returns the identity and groups of the peer sending a request, it is only known when the peer presented
+// a verified certificate with mutual TLS
+func peerIdentity(req *http.Request) (string, []string, bool) {
+	if req.TLS == nil {
+		return "", nil, false
+	}
+	return rpc.CertificateIdentity(*req.TLS)
+}
+
+// requestPeer returns the peer sending a request, with the identity and groups of its verified certificate
+func requestPeer(req *http.Request) p2p.Requester {
+	identity, groups, _ := peerIdentity(req)
+	return p2p.Requester{Name: requester(req), Identity: identity, Groups: groups}
+}
+
+// openFile opens a file for reading if it is available for download by the peer sending the request
+func (r *Node) openFile(req *http.Request, hash string) (p2p.FileMetaData, afero.File, error) {
+	return p2p.OpenServedFile(r.fs, r.db, hash, r.seedPartial, requestPeer(req))
+}
+
+// writeJSON replies with v encoded as JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to write reply. Reason: %s", err)
+	}
+}
@@ -0,0 +1,147 @@
+package p2p_test
+
+import (
+	"context"
+	"encoding/json"
+	"fileshare/p2p"
+	p2phttp "fileshare/p2p/http"
+	"fileshare/p2p/rpc"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// httpClient creates a client of an HTTP node served by server
+func httpClient(t *testing.T, server *httptest.Server, swarmKey string) p2p.Client {
+	addr := server.Listener.Addr().(*net.TCPAddr)
+	client, err := p2phttp.ClientFactory(rpc.TLSConfig{}, swarmKey, p2p.DefaultCallPolicy)("node", addr.IP.String(), addr.Port)
+	assert.Nil(t, err)
+	return client
+}
+
+// httpGet sends a GET request with optional headers to server
+func httpGet(t *testing.T, server *httptest.Server, path string, header http.Header) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	assert.Nil(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp, body
+}
+
+// Test files are served whole with Range requests and by fragments with their proofs, restricted files aren't served to
+// peers that aren't allowed, and only GET and HEAD requests are served
+func TestHTTPNode(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := p2p.CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewOsFs()
+	assert.Nil(t, p2p.Publish(fs, db, "file.go", p2p.SHA256, 4096, nil))
+	assert.Nil(t, p2p.Publish(fs, db, "access.go", p2p.SHA256, 0, []string{"hr-laptop"}))
+	files := make(map[string]p2p.FileMetaData)
+	for _, f := range p2p.List(fs, db) {
+		files[f.Name] = f
+	}
+	fm, restricted := files["file.go"], files["access.go"]
+	data, err := afero.ReadFile(fs, "file.go")
+	assert.Nil(t, err)
+	server := httptest.NewServer(p2phttp.NewNode("node", "test.db", false).Handler())
+	defer server.Close()
+
+	resp, body := httpGet(t, server, "/files/"+fm.Hash, http.Header{"Range": {"bytes=10-19"}})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, data[10:20], body)
+	resp, body = httpGet(t, server, "/files/"+fm.Hash, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, data, body)
+
+	// Available fragments are listed with the hashes verifying them
+	resp, body = httpGet(t, server, "/files/"+fm.Hash+"/fragments", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var fragments p2phttp.FragmentsReply
+	assert.Nil(t, json.Unmarshal(body, &fragments))
+	assert.Len(t, fragments.AvailableFragments, fm.FragmentsCount)
+	assert.Equal(t, []string(fm.FragmentHashes), fragments.FragmentHashes)
+
+	// Fragments are sent with the proof verifying them
+	resp, _ = httpGet(t, server, "/files/"+fm.Hash+"/fragments/1", nil)
+	assert.NotEmpty(t, resp.Header.Get(p2phttp.ProofHeader))
+	client := httpClient(t, server, "")
+	listed, err := client.List(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, listed, 1)
+	assert.Equal(t, fm.Hash, listed[0].Hash)
+	downloaded := make(memoryFile, len(data))
+	fw := fm.NewFragmentWriter(downloaded, 1)
+	out := make(chan p2p.DownloadResult, 1)
+	client.Download(context.Background(), fm.Hash, 1, fw, out)
+	result := <-out
+	assert.True(t, result.Successful)
+	assert.NotEmpty(t, result.Proof)
+	assert.True(t, fw.Verify(result.Proof))
+	assert.Equal(t, data[4096:8192], []byte(downloaded[4096:8192]))
+
+	// Peers without an identity aren't allowed to tell restricted files exist
+	resp, _ = httpGet(t, server, "/files/"+restricted.Hash, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = httpGet(t, server, "/files/"+restricted.Hash+"/fragments/0", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		req, err := http.NewRequest(method, server.URL+"/files/"+fm.Hash, nil)
+		assert.Nil(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
+
+// Test a node of a private swarm only serves requests with a valid swarm token, which is sent once
+func TestHTTPNodeSwarmKey(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := p2p.CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewOsFs()
+	assert.Nil(t, p2p.Publish(fs, db, "file.go", p2p.SHA256, 0, nil))
+	node := p2phttp.NewNode("node", "test.db", false)
+	node.SetSwarmKey("secret")
+	server := httptest.NewServer(node.Handler())
+	defer server.Close()
+
+	resp, _ := httpGet(t, server, "/files", nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	_, err = httpClient(t, server, "other").List(context.Background())
+	assert.NotNil(t, err)
+	listed, err := httpClient(t, server, "secret").List(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, listed, 1)
+	// Tokens are bound to their request and can't be sent again
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/files", nil)
+	token := p2p.SwarmToken("secret", p2p.SwarmResource(req), time.Now())
+	resp, _ = httpGet(t, server, "/hello", http.Header{p2phttp.SwarmHeader: {token}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = httpGet(t, server, "/files", http.Header{p2phttp.SwarmHeader: {token}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = httpGet(t, server, "/files", http.Header{p2phttp.SwarmHeader: {token}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+// memoryFile is a file held in memory
+type memoryFile []byte
+
+func (f memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	return copy(f[off:], p), nil
+}
@@ -0,0 +1,153 @@
+package p2p
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting how many bytes are transferred per second, a nil RateLimiter doesn't limit
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing bytesPerSecond with bursts of up to a second, when bytesPerSecond isn't
+// positive there is no limit and nil is returned
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+// Wait blocks until n bytes can be transferred or ctx is done. Transfers larger than the bucket are allowed, the
+// following transfers wait until they are paid for.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitAll blocks until n bytes can be transferred by all limiters
+func WaitAll(ctx context.Context, n int, limiters ...*RateLimiter) error {
+	for _, l := range limiters {
+		if err := l.Wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limitedWriter waits for its limiter before every write
+type limitedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *RateLimiter
+}
+
+// LimitWriter limits the bytes per second written to w, writes fail once ctx is done
+func LimitWriter(ctx context.Context, w io.Writer, limiter *RateLimiter) io.Writer {
+	if limiter == nil {
+		return w
+	}
+	return &limitedWriter{ctx, w, limiter}
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if err := lw.limiter.Wait(lw.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return lw.w.Write(p)
+}
+
+// CountingWriter counts the bytes written to w and remembers when the last write happened, so a transfer can tell
+// whether it makes progress
+type CountingWriter struct {
+	w    io.Writer
+	n    int64
+	last int64
+}
+
+// NewCountingWriter creates a CountingWriter writing to w
+func NewCountingWriter(w io.Writer) *CountingWriter {
+	return &CountingWriter{w: w}
+}
+
+func (cw *CountingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n > 0 {
+		atomic.AddInt64(&cw.n, int64(n))
+		atomic.StoreInt64(&cw.last, time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// Written returns the bytes written so far
+func (cw *CountingWriter) Written() int64 {
+	return atomic.LoadInt64(&cw.n)
+}
+
+// LastWrite returns when data was last written, the zero time when nothing was written
+func (cw *CountingWriter) LastWrite() time.Time {
+	last := atomic.LoadInt64(&cw.last)
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// PeerLimiters limits the upload rate to all peers, and to every single peer
+type PeerLimiters struct {
+	all     *RateLimiter
+	perPeer int64
+	peers   map[string]*RateLimiter
+	mu      sync.Mutex
+}
+
+// NewPeerLimiters creates PeerLimiters allowing bytesPerSecond to all peers and peerBytesPerSecond to every peer, 0
+// means no limit
+func NewPeerLimiters(bytesPerSecond, peerBytesPerSecond int64) *PeerLimiters {
+	return &PeerLimiters{all: NewRateLimiter(bytesPerSecond), perPeer: peerBytesPerSecond, peers: make(map[string]*RateLimiter)}
+}
+
+// Limiters returns the limiters of a transfer to a peer, a nil PeerLimiters doesn't limit
+func (l *PeerLimiters) Limiters(peer string) []*RateLimiter {
+	if l == nil {
+		return nil
+	}
+	if l.perPeer <= 0 {
+		return []*RateLimiter{l.all}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.peers[peer]; !ok {
+		l.peers[peer] = NewRateLimiter(l.perPeer)
+	}
+	return []*RateLimiter{l.all, l.peers[peer]}
+}
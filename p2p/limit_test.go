@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test transfers wait once the bucket is empty, and stop waiting when the context is done
+func TestRateLimiter(t *testing.T) {
+	var unlimited *RateLimiter
+	assert.Nil(t, NewRateLimiter(0))
+	assert.Nil(t, unlimited.Wait(context.Background(), 1<<30))
+	l := NewRateLimiter(10000)
+	start := time.Now()
+	assert.Nil(t, l.Wait(context.Background(), 10000))
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+	assert.Nil(t, l.Wait(context.Background(), 2000))
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, l.Wait(ctx, 10000))
+}
+
+// Test limited writers write all data
+func TestLimitWriter(t *testing.T) {
+	var b bytes.Buffer
+	assert.Equal(t, &b, LimitWriter(context.Background(), &b, nil))
+	w := LimitWriter(context.Background(), &b, NewRateLimiter(1<<20))
+	n, err := w.Write([]byte("fragment"))
+	assert.Nil(t, err)
+	assert.Equal(t, 8, n)
+	assert.Equal(t, "fragment", b.String())
+}
+
+// Test every peer has its own limiter, and all peers share the upload limiter
+func TestPeerLimiters(t *testing.T) {
+	var unlimited *PeerLimiters
+	assert.Empty(t, unlimited.Limiters("peer"))
+	l := NewPeerLimiters(1000, 0)
+	assert.Equal(t, []*RateLimiter{l.all}, l.Limiters("peer"))
+	l = NewPeerLimiters(0, 100)
+	first := l.Limiters("first")
+	assert.Len(t, first, 2)
+	assert.Nil(t, first[0])
+	assert.Equal(t, first, l.Limiters("first"))
+	assert.NotEqual(t, first[1], l.Limiters("second")[1])
+}
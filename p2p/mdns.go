@@ -0,0 +1,213 @@
+package p2p
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// MDNSService is the DNS-SD service type fileshare nodes are advertised as
+const MDNSService = "_fileshare._tcp"
+
+// mdnsDomain is the domain of multicast DNS
+const mdnsDomain = "local."
+
+// defaultBrowseTimeout is how long peers are browsed for on every discovery
+const defaultBrowseTimeout = 2 * time.Second
+
+// Keys of the TXT records of a node
+const (
+	mdnsName      = "name"
+	mdnsNodeID    = "id"
+	mdnsPort      = "port"
+	mdnsProtocol  = "protocol"
+	mdnsVersion   = "version"
+	mdnsTransport = "transport"
+	mdnsProof     = "proof"
+)
+
+// MDNSResolver discovers peers by browsing the _fileshare._tcp DNS-SD service with multicast DNS, and advertises this
+// node as an instance of the service. The name, node ID, port and protocol version of every node are in its TXT records,
+// so nodes can be inspected with standard tools, i.e avahi-browse -r _fileshare._tcp
+type MDNSResolver struct {
+	// Payload describes this node, the address is used only to pick the address advertised
+	Payload DiscoveryPayload
+	// NodeID identifies this node, the name is used when it isn't set
+	NodeID        string
+	ClientFactory CreateClient
+	// SwarmKey is the pre-shared key of a private swarm, peers that don't hold it are ignored
+	SwarmKey string
+	// BrowseTimeout is how long peers are browsed for, defaultBrowseTimeout is used when it isn't set
+	BrowseTimeout time.Duration
+}
+
+// Discover browses for nodes and returns clients of the nodes this node can talk to
+func (m MDNSResolver) Discover(ctx context.Context) ([]Client, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		log.Errorf("Failed to discover. Reason: %s", err)
+		return nil, err
+	}
+	timeout := m.BrowseTimeout
+	if timeout <= 0 {
+		timeout = defaultBrowseTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Browse(ctx, MDNSService, mdnsDomain, entries); err != nil {
+		log.Errorf("Failed to discover. Reason: %s", err)
+		return nil, err
+	}
+	var clients []Client
+	seen := make(map[string]bool)
+	// Entries are closed once browsing is done
+	for entry := range entries {
+		payload, err := m.peer(entry)
+		if err != nil {
+			log.Debugf("Ignored %s. Reason: %s", entry.Instance, err)
+			continue
+		}
+		key := fmt.Sprintf("%s@%s:%d", payload.Name, payload.Addr, payload.Port)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		log.Debugf("Connecting to %s", key)
+		client, err := m.ClientFactory(payload.Name, payload.Addr, payload.Port)
+		if err != nil {
+			log.Errorf("Failed to create client %s. Reason: %s", key, err)
+			continue
+		}
+		clients = append(clients, client)
+	}
+	if len(clients) == 0 {
+		return nil, errors.New("Didn't find any peers")
+	}
+	return clients, nil
+}
+
+// Listen advertises this node until ctx is done
+func (m MDNSResolver) Listen(ctx context.Context, address string) {
+	var server *zeroconf.Server
+	var err error
+	ip := net.ParseIP(address)
+	if ip == nil || ip.IsUnspecified() {
+		server, err = zeroconf.Register(m.Payload.Name, MDNSService, mdnsDomain, m.Payload.Port, m.text(), nil)
+	} else {
+		// Only the address the node listens on is advertised
+		server, err = zeroconf.RegisterProxy(m.Payload.Name, MDNSService, mdnsDomain, m.Payload.Port, mdnsHost(m.Payload.Name),
+			[]string{ip.String()}, m.text(), nil)
+	}
+	if err != nil {
+		log.Errorf("Failed to advertise node. Reason: %s", err)
+		return
+	}
+	defer server.Shutdown()
+	log.Infof("Node is now advertised as %s.%s.%s", m.Payload.Name, MDNSService, mdnsDomain)
+	<-ctx.Done()
+}
+
+// nodeID returns the ID of this node
+func (m MDNSResolver) nodeID() string {
+	if m.NodeID == "" {
+		return m.Payload.Name
+	}
+	return m.NodeID
+}
+
+// text returns the TXT records of this node, with a proof of the swarm key in a private swarm
+func (m MDNSResolver) text() []string {
+	txt := []string{
+		mdnsName + "=" + m.Payload.Name,
+		mdnsNodeID + "=" + m.nodeID(),
+		mdnsPort + "=" + strconv.Itoa(m.Payload.Port),
+		mdnsProtocol + "=" + strconv.Itoa(ProtocolVersion),
+		mdnsVersion + "=" + SoftwareVersion,
+		mdnsTransport + "=" + m.Payload.transport(),
+	}
+	if m.SwarmKey != "" {
+		proof := SwarmProof(m.SwarmKey, mdnsMessage(m.nodeID(), m.Payload.Name, m.Payload.Port))
+		txt = append(txt, mdnsProof+"="+hex.EncodeToString(proof))
+	}
+	return txt
+}
+
+// peer returns the payload of a discovered node, nodes that this node can't talk to are rejected
+func (m MDNSResolver) peer(entry *zeroconf.ServiceEntry) (DiscoveryPayload, error) {
+	txt := parseText(entry.Text)
+	payload := DiscoveryPayload{Name: txt[mdnsName], Port: entry.Port, Transport: txt[mdnsTransport]}
+	if payload.Name == "" {
+		payload.Name = entry.Instance
+	}
+	if m.nodeID() != "" && txt[mdnsNodeID] == m.nodeID() {
+		return payload, errors.New("It is this node")
+	}
+	if len(entry.AddrIPv4) > 0 {
+		payload.Addr = entry.AddrIPv4[0].String()
+	} else {
+		// Link-local addresses can't be dialed without the zone of the interface they were received on
+		for _, ip := range entry.AddrIPv6 {
+			if !ip.IsLinkLocalUnicast() {
+				payload.Addr = ip.String()
+				break
+			}
+		}
+	}
+	if payload.Addr == "" {
+		return payload, errors.New("It has no address")
+	}
+	if m.SwarmKey != "" {
+		proof, err := hex.DecodeString(txt[mdnsProof])
+		if err != nil || !VerifySwarmProof(m.SwarmKey, mdnsMessage(txt[mdnsNodeID], payload.Name, entry.Port), proof) {
+			return payload, errors.New("It isn't in the swarm")
+		}
+	}
+	// Clients only speak a single transport
+	if payload.transport() != m.Payload.transport() {
+		return payload, fmt.Errorf("It serves files with %s", payload.transport())
+	}
+	if protocol, err := strconv.Atoi(txt[mdnsProtocol]); err == nil && protocol < MinProtocolVersion {
+		return payload, fmt.Errorf("It speaks protocol version %d", protocol)
+	}
+	return payload, nil
+}
+
+// mdnsMessage is the message proving a node advertised with multicast DNS holds the swarm key
+func mdnsMessage(nodeID, name string, port int) string {
+	return fmt.Sprintf("fileshare-mdns:%s:%s:%d", nodeID, name, port)
+}
+
+// parseText parses key=value TXT records, records without a value are ignored
+func parseText(records []string) map[string]string {
+	txt := make(map[string]string)
+	for _, record := range records {
+		if i := strings.Index(record, "="); i > 0 {
+			txt[strings.ToLower(record[:i])] = record[i+1:]
+		}
+	}
+	return txt
+}
+
+// mdnsHost returns the host name of an advertised node, a DNS label derived from its name
+func mdnsHost(name string) string {
+	label := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+	if label == "" {
+		return "fileshare"
+	}
+	return label
+}
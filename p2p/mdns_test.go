@@ -0,0 +1,74 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/stretchr/testify/assert"
+)
+
+// entry returns the service entry of a node advertised by r
+func entry(r MDNSResolver, ips ...string) *zeroconf.ServiceEntry {
+	e := zeroconf.NewServiceEntry(r.Payload.Name, MDNSService, mdnsDomain)
+	e.Port = r.Payload.Port
+	e.Text = r.text()
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed.To4() != nil {
+			e.AddrIPv4 = append(e.AddrIPv4, parsed)
+		} else {
+			e.AddrIPv6 = append(e.AddrIPv6, parsed)
+		}
+	}
+	return e
+}
+
+// Test the TXT records of a node describe it
+func TestMDNSText(t *testing.T) {
+	r := MDNSResolver{Payload: DiscoveryPayload{Name: "builder", Port: 9000}, NodeID: "node-1"}
+	txt := parseText(r.text())
+	assert.Equal(t, "builder", txt["name"])
+	assert.Equal(t, "node-1", txt["id"])
+	assert.Equal(t, "9000", txt["port"])
+	assert.Equal(t, "2", txt["protocol"])
+	assert.Equal(t, DefaultTransport, txt["transport"])
+	assert.Empty(t, txt["proof"])
+	r.SwarmKey = "key"
+	assert.NotEmpty(t, parseText(r.text())["proof"])
+	assert.Equal(t, map[string]string{"a": "1=2", "b": ""}, parseText([]string{"A=1=2", "b=", "flag", "=x"}))
+}
+
+// Test only nodes this node can talk to are discovered
+func TestMDNSPeer(t *testing.T) {
+	local := MDNSResolver{Payload: DiscoveryPayload{Name: "local", Port: 9000}}
+	remote := MDNSResolver{Payload: DiscoveryPayload{Name: "remote", Port: 9001}}
+	payload, err := local.peer(entry(remote, "fe80::1", "192.168.1.7"))
+	assert.Nil(t, err)
+	assert.Equal(t, DiscoveryPayload{Name: "remote", Addr: "192.168.1.7", Port: 9001, Transport: DefaultTransport}, payload)
+	payload, err = local.peer(entry(remote, "fe80::1", "2001:db8::7"))
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8::7", payload.Addr)
+	// Link-local addresses have no zone to dial them with
+	_, err = local.peer(entry(remote, "fe80::1"))
+	assert.NotNil(t, err)
+	_, err = local.peer(entry(remote))
+	assert.NotNil(t, err)
+	// This node is skipped
+	_, err = local.peer(entry(local, "192.168.1.8"))
+	assert.NotNil(t, err)
+	// Nodes of other transports
+	remote.Payload.Transport = "http"
+	_, err = local.peer(entry(remote, "192.168.1.7"))
+	assert.NotNil(t, err)
+	// Nodes outside of the swarm
+	remote.Payload.Transport = ""
+	local.SwarmKey = "key"
+	_, err = local.peer(entry(remote, "192.168.1.7"))
+	assert.NotNil(t, err)
+	remote.SwarmKey = "other"
+	_, err = local.peer(entry(remote, "192.168.1.7"))
+	assert.NotNil(t, err)
+	remote.SwarmKey = "key"
+	_, err = local.peer(entry(remote, "192.168.1.7"))
+	assert.Nil(t, err)
+}
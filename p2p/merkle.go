@@ -0,0 +1,76 @@
+package p2p
+
+import (
+	"bytes"
+)
+
+// Files are identified by the root of a merkle tree built over their fragments (RFC 6962), every fragment can be
+// verified on its own with an inclusion proof and the file hash, without trusting a list of fragment hashes.
+var (
+	leafPrefix = []byte{0}
+	nodePrefix = []byte{1}
+)
+
+// leafHash hashes the data of a fragment as a leaf of the merkle tree
+func leafHash(a HashAlgorithm, data []byte) []byte {
+	return a.sum(leafPrefix, data)
+}
+
+// merkleRoot calculates the root of a merkle tree from its leaf hashes
+func merkleRoot(a HashAlgorithm, leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		return a.sum()
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return a.sum(nodePrefix, merkleRoot(a, leaves[:k]), merkleRoot(a, leaves[k:]))
+}
+
+// merkleProof returns the sibling hashes needed to rebuild the root from a leaf, from the leaf level up
+func merkleProof(a HashAlgorithm, leaves [][]byte, index int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if index < k {
+		return append(merkleProof(a, leaves[:k], index), merkleRoot(a, leaves[k:]))
+	}
+	return append(merkleProof(a, leaves[k:], index-k), merkleRoot(a, leaves[:k]))
+}
+
+// verifyMerkleProof checks that leaf is at index of a merkle tree with size leaves and the given root
+func verifyMerkleProof(a HashAlgorithm, root, leaf []byte, index, size int, proof [][]byte) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = a.sum(nodePrefix, p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = a.sum(nodePrefix, r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && r != nil && bytes.Equal(r, root)
+}
+
+// splitPoint returns the largest power of two smaller than n
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
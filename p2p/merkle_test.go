@@ -0,0 +1,40 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test every leaf can be proven for trees of different sizes, including unbalanced trees
+func TestMerkleProof(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		var leaves [][]byte
+		for i := 0; i < size; i++ {
+			leaves = append(leaves, leafHash(SHA256, []byte(fmt.Sprintf("fragment %d", i))))
+		}
+		root := merkleRoot(SHA256, leaves)
+		for i := 0; i < size; i++ {
+			proof := merkleProof(SHA256, leaves, i)
+			assert.True(t, verifyMerkleProof(SHA256, root, leaves[i], i, size, proof), "size=%d leaf=%d", size, i)
+			// A proof is only valid for its own leaf and position
+			assert.False(t, verifyMerkleProof(SHA256, root, leaves[i], size, size, proof))
+			if size > 1 {
+				assert.False(t, verifyMerkleProof(SHA256, root, leaves[(i+1)%size], i, size, proof))
+				assert.False(t, verifyMerkleProof(SHA256, root, leaves[i], (i+1)%size, size, proof))
+			}
+		}
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	a, b, c := leafHash(SHA256, []byte("a")), leafHash(SHA256, []byte("b")), leafHash(SHA256, []byte("c"))
+	assert.Equal(t, SHA256.sum(), merkleRoot(SHA256, nil))
+	assert.Equal(t, a, merkleRoot(SHA256, [][]byte{a}))
+	// Three leaves are split into a balanced left subtree of two leaves and a single right leaf
+	ab := SHA256.sum(nodePrefix, a, b)
+	assert.Equal(t, SHA256.sum(nodePrefix, ab, c), merkleRoot(SHA256, [][]byte{a, b, c}))
+	assert.Equal(t, [][]byte{b, c}, merkleProof(SHA256, [][]byte{a, b, c}, 0))
+	assert.Equal(t, [][]byte{ab}, merkleProof(SHA256, [][]byte{a, b, c}, 2))
+}
@@ -3,6 +3,7 @@
 package mocks
 
 import context "context"
+import io "io"
 import mock "github.com/stretchr/testify/mock"
 import p2p "fileshare/p2p"
 
@@ -25,13 +26,27 @@ func (_m *Client) Alive() bool {
 	return r0
 }
 
-// Download provides a mock function with given fields: ctx, fileHash, fragmentID, out
-func (_m *Client) Download(ctx context.Context, fileHash string, fragmentID int, out chan p2p.DownloadResult) {
-	_m.Called(ctx, fileHash, fragmentID, out)
+// Close provides a mock function with given fields:
+func (_m *Client) Close() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Download provides a mock function with given fields: ctx, fileHash, fragmentID, w, out
+func (_m *Client) Download(ctx context.Context, fileHash string, fragmentID int, w io.Writer, out chan p2p.DownloadResult) {
+	_m.Called(ctx, fileHash, fragmentID, w, out)
 }
 
 // FragmentsAvailable provides a mock function with given fields: ctx, fileHash
-func (_m *Client) FragmentsAvailable(ctx context.Context, fileHash string) []int {
+func (_m *Client) FragmentsAvailable(ctx context.Context, fileHash string) ([]int, error) {
 	ret := _m.Called(ctx, fileHash)
 
 	var r0 []int
@@ -43,7 +58,14 @@ func (_m *Client) FragmentsAvailable(ctx context.Context, fileHash string) []int
 		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, fileHash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
 // List provides a mock function with given fields: ctx
@@ -82,3 +104,26 @@ func (_m *Client) Name() string {
 
 	return r0
 }
+
+// PeerExchange provides a mock function with given fields: ctx
+func (_m *Client) PeerExchange(ctx context.Context) ([]p2p.DiscoveryPayload, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []p2p.DiscoveryPayload
+	if rf, ok := ret.Get(0).(func(context.Context) []p2p.DiscoveryPayload); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]p2p.DiscoveryPayload)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
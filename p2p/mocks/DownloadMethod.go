@@ -11,6 +11,16 @@ type DownloadMethod struct {
 	mock.Mock
 }
 
+// Cancel provides a mock function with given fields: peerName, fragmentID
+func (_m *DownloadMethod) Cancel(peerName string, fragmentID int) {
+	_m.Called(peerName, fragmentID)
+}
+
+// Done provides a mock function with given fields: peerName, fragmentID, successful
+func (_m *DownloadMethod) Done(peerName string, fragmentID int, successful bool) {
+	_m.Called(peerName, fragmentID, successful)
+}
+
 // NextFragment provides a mock function with given fields: ctx, peers, fm
 func (_m *DownloadMethod) NextFragment(ctx context.Context, peers map[string]p2p.Client, fm p2p.FileMetaData) (p2p.Client, int, error) {
 	ret := _m.Called(ctx, peers, fm)
@@ -40,3 +50,8 @@ func (_m *DownloadMethod) NextFragment(ctx context.Context, peers map[string]p2p
 
 	return r0, r1, r2
 }
+
+// Reject provides a mock function with given fields: peerName, fragmentID
+func (_m *DownloadMethod) Reject(peerName string, fragmentID int) {
+	_m.Called(peerName, fragmentID)
+}
@@ -2,27 +2,44 @@ package p2p
 
 import (
 	"context"
+	"io"
 )
 
-// FileChunkSize defines file split size 1mb chunks
-const FileChunkSize = 1 * (1 << 20) // 1 MB, change this to your requirement
+// FileChunkSize is the chunk size of files published by older versions, which didn't specify their chunk size
+const FileChunkSize = 1 * (1 << 20) // 1 MB
+
+const (
+	// MinChunkSize is the smallest chunk size chosen for a file when publishing it
+	MinChunkSize = 256 * (1 << 10) // 256 KB
+	// MaxChunkSize is the largest chunk size allowed
+	MaxChunkSize = 16 * (1 << 20) // 16 MB
+)
 
 // CreateClient defines a factory method that allows to create different types of clients rpc/http/torrent etc
 type CreateClient func(name, addr string, port int) (Client, error)
 
 // DownloadMethod is an algorithim for downloading a file from multiple peers
 // the method recevies all available peers and all fragments that were already downloaded
-// and returns the best fragment to download.
+// and returns the best fragment to download. Fragments are downloaded concurrently, a fragment returned by
+// NextFragment may be returned again for another peer, the slower download is canceled once the fragment is downloaded.
 type DownloadMethod interface {
 	// NextFragments returns the next fragment to download and from what client
 	NextFragment(ctx context.Context, peers map[string]Client, fm FileMetaData) (Client, int, error)
+	// Done is called when a fragment returned by NextFragment finished downloading, successful or not
+	Done(peerName string, fragmentID int, successful bool)
+	// Cancel is called when a fragment returned by NextFragment was canceled, since it was downloaded from another peer
+	Cancel(peerName string, fragmentID int)
+	// Reject is called when a peer sent a fragment that failed verification, so it will be downloaded from another peer
+	Reject(peerName string, fragmentID int)
 }
 
-// DownloadResult is returned by client when it finishes to download a file part.
+// DownloadResult is returned by client when it finishes to download a file part, the data itself is written by the
+// client as it is received.
 type DownloadResult struct {
 	FragmentID int
 	PeerName   string
-	Data       []byte
+	// Proof is the inclusion proof of the fragment in the file's merkle tree
+	Proof      [][]byte
 	Successful bool
 }
 
@@ -32,12 +49,17 @@ type Client interface {
 	Name() string
 	// List Files available files in client
 	List(ctx context.Context) ([]FileMetaData, error)
-	// Download a fragment of a file from remote client
-	Download(ctx context.Context, fileHash string, fragmentID int, out chan DownloadResult)
-	// FragmentAvailable checks if fragment is available on remote client
-	FragmentsAvailable(ctx context.Context, fileHash string) []int
+	// Download a fragment of a file from remote client, writing its data to w
+	Download(ctx context.Context, fileHash string, fragmentID int, w io.Writer, out chan DownloadResult)
+	// FragmentsAvailable returns the fragments of a file available on remote client, an error means the peer couldn't
+	// tell, not that it has none
+	FragmentsAvailable(ctx context.Context, fileHash string) ([]int, error)
+	// PeerExchange returns the peers the remote client knows about, peers that don't exchange peers know none
+	PeerExchange(ctx context.Context) ([]DiscoveryPayload, error)
 	// Alive checks if connection is alive
 	Alive() bool
+	// Close closes the connection to the remote client, clients of a ClientPool are released to the pool instead
+	Close() error
 }
 
 // Server interface allows for remote file seeding with other Client's
@@ -53,3 +75,9 @@ type PeerResolver interface {
 	// Listen allows other peers to discover this node
 	Listen(ctx context.Context, addr string)
 }
+
+// ProviderFinder finds the peers providing a file, so only they are asked for it
+type ProviderFinder interface {
+	// FindProviders returns the addresses of the peers providing a file
+	FindProviders(ctx context.Context, fileHash string) ([]DiscoveryPayload, error)
+}
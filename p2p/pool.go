@@ -0,0 +1,117 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ClientPool reuses the clients of peers, keyed by peer identity, so peers found again by every discovery share a
+// single connection. Clients returned by the pool are released to it when they are closed, connections that are no
+// longer used by anyone are closed.
+type ClientPool struct {
+	factory CreateClient
+	mu      sync.Mutex
+	clients map[string]*pooledConn
+}
+
+// pooledConn is a connection of the pool and the number of clients using it
+type pooledConn struct {
+	client Client
+	refs   int
+	closed bool
+}
+
+// close closes the connection once
+func (c *pooledConn) close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.client.Close()
+}
+
+// NewClientPool creates a pool creating clients with factory
+func NewClientPool(factory CreateClient) *ClientPool {
+	return &ClientPool{factory: factory, clients: make(map[string]*pooledConn)}
+}
+
+// Get returns a client of a peer, reusing the connection to the peer when it is alive. Get is a CreateClient so the pool
+// can replace the factory of a PeerResolver.
+func (p *ClientPool) Get(name, addr string, port int) (Client, error) {
+	key := fmt.Sprintf("%s@%s:%d", name, addr, port)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, ok := p.clients[key]
+	if ok && !conn.client.Alive() {
+		log.Debugf("Connection to %s isn't alive, reconnecting", key)
+		conn.close()
+		delete(p.clients, key)
+		ok = false
+	}
+	if !ok {
+		client, err := p.factory(name, addr, port)
+		if err != nil {
+			return nil, err
+		}
+		conn = &pooledConn{client: client}
+		p.clients[key] = conn
+	}
+	conn.refs++
+	return &pooledClient{Client: conn.client, pool: p, key: key, conn: conn}, nil
+}
+
+// Len returns the number of open connections
+func (p *ClientPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.clients)
+}
+
+// Close closes all connections, even the ones still in use
+func (p *ClientPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, conn := range p.clients {
+		conn.close()
+		delete(p.clients, key)
+	}
+}
+
+// release releases a client of a connection, the connection is closed once it is idle
+func (p *ClientPool) release(key string, conn *pooledConn) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn.refs--
+	if conn.refs > 0 {
+		return nil
+	}
+	// The connection may have been replaced by a live one
+	if p.clients[key] == conn {
+		delete(p.clients, key)
+	}
+	if conn.closed {
+		return nil
+	}
+	log.Debugf("Closing idle connection to %s", key)
+	return conn.close()
+}
+
+// pooledClient is a client of the pool, closing it releases it to the pool
+type pooledClient struct {
+	Client
+	pool *ClientPool
+	key  string
+	conn *pooledConn
+	once sync.Once
+}
+
+// Close releases the client to the pool, closing it again has no effect
+func (c *pooledClient) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.pool.release(c.key, c.conn)
+	})
+	return err
+}
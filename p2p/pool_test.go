@@ -0,0 +1,83 @@
+package p2p_test
+
+import (
+	"errors"
+	"fileshare/p2p"
+	"fileshare/p2p/mocks"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test clients of a peer share a connection, which is closed once all of them are released
+func TestClientPool(t *testing.T) {
+	var created []*mocks.Client
+	pool := p2p.NewClientPool(func(name, addr string, port int) (p2p.Client, error) {
+		if name == "unreachable" {
+			return nil, errors.New("Failed to create client")
+		}
+		client := &mocks.Client{}
+		client.On("Name").Return(name)
+		client.On("Alive").Return(true)
+		client.On("Close").Return(nil)
+		created = append(created, client)
+		return client, nil
+	})
+	c1, err := pool.Get("peer", "127.0.0.1", 9000)
+	assert.Nil(t, err)
+	c2, err := pool.Get("peer", "127.0.0.1", 9000)
+	assert.Nil(t, err)
+	assert.Equal(t, "peer", c2.Name())
+	other, err := pool.Get("peer", "127.0.0.1", 9001)
+	assert.Nil(t, err)
+	_, err = pool.Get("unreachable", "127.0.0.1", 9000)
+	assert.NotNil(t, err)
+	assert.Len(t, created, 2)
+	assert.Equal(t, 2, pool.Len())
+	// Closing a client twice releases it once
+	assert.Nil(t, c1.Close())
+	assert.Nil(t, c1.Close())
+	created[0].AssertNotCalled(t, "Close")
+	assert.Nil(t, c2.Close())
+	created[0].AssertNumberOfCalls(t, "Close", 1)
+	assert.Equal(t, 1, pool.Len())
+	// Released peers are connected again
+	c3, err := pool.Get("peer", "127.0.0.1", 9000)
+	assert.Nil(t, err)
+	assert.Len(t, created, 3)
+	pool.Close()
+	assert.Equal(t, 0, pool.Len())
+	created[1].AssertNumberOfCalls(t, "Close", 1)
+	created[2].AssertNumberOfCalls(t, "Close", 1)
+	// Connections closed by the pool aren't closed again once released
+	assert.Nil(t, other.Close())
+	assert.Nil(t, c3.Close())
+	created[1].AssertNumberOfCalls(t, "Close", 1)
+	created[2].AssertNumberOfCalls(t, "Close", 1)
+}
+
+// Test connections that aren't alive are replaced
+func TestClientPoolReconnect(t *testing.T) {
+	dead := &mocks.Client{}
+	dead.On("Alive").Return(false)
+	dead.On("Close").Return(nil)
+	live := &mocks.Client{}
+	live.On("Alive").Return(true)
+	live.On("Close").Return(nil)
+	clients := []p2p.Client{dead, live}
+	pool := p2p.NewClientPool(func(name, addr string, port int) (p2p.Client, error) {
+		client := clients[0]
+		clients = clients[1:]
+		return client, nil
+	})
+	c1, _ := pool.Get("peer", "127.0.0.1", 9000)
+	c2, _ := pool.Get("peer", "127.0.0.1", 9000)
+	dead.AssertNumberOfCalls(t, "Close", 1)
+	assert.Equal(t, 1, pool.Len())
+	// Releasing the dead connection leaves the live one open
+	assert.Nil(t, c1.Close())
+	dead.AssertNumberOfCalls(t, "Close", 1)
+	live.AssertNotCalled(t, "Close")
+	assert.Nil(t, c2.Close())
+	live.AssertNumberOfCalls(t, "Close", 1)
+}
@@ -0,0 +1,103 @@
+package p2p
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProtocolVersion is the version of the protocol spoken between peers, it changes whenever peers of different versions
+// would misread each other
+//
+//  1. peers without a handshake, statuses New to Seeding
+//  2. handshake with capabilities, Corrupt status
+const ProtocolVersion = 2
+
+// MinProtocolVersion is the oldest protocol version of peers this node talks to
+const MinProtocolVersion = 1
+
+// SoftwareVersion is the version of fileshare, set when building with -ldflags "-X fileshare/p2p.SoftwareVersion=1.2.3"
+var SoftwareVersion = "dev"
+
+// Capabilities are optional features of a node, peers only use features both of them support
+const (
+	// StreamCapability nodes stream fragments in frames
+	StreamCapability = "stream"
+	// ProofCapability nodes send inclusion proofs of fragments
+	ProofCapability = "proof"
+	// CompressionCapability nodes compress fragments, see Compressions
+	CompressionCapability = "compression"
+	// EncryptionCapability nodes list the encryption of encrypted files
+	EncryptionCapability = "encryption"
+	// PeerExchangeCapability nodes send the peers they know, see PeerBook
+	PeerExchangeCapability = "pex"
+)
+
+// Capabilities lists the features of this node
+var Capabilities = []string{StreamCapability, ProofCapability, CompressionCapability, EncryptionCapability,
+	PeerExchangeCapability}
+
+// protocolStatuses is the last status known to every protocol version
+var protocolStatuses = map[int]Status{1: Seeding, 2: Corrupt}
+
+// PeerInfo describes a peer and the protocol it speaks, peers exchange it once they connect
+type PeerInfo struct {
+	NodeID             string
+	ProtocolVersion    int
+	MinProtocolVersion int
+	SoftwareVersion    string
+	Capabilities       []string
+}
+
+// LocalPeerInfo describes this node
+func LocalPeerInfo(nodeID string) PeerInfo {
+	return PeerInfo{NodeID: nodeID, ProtocolVersion: ProtocolVersion, MinProtocolVersion: MinProtocolVersion,
+		SoftwareVersion: SoftwareVersion, Capabilities: Capabilities}
+}
+
+// LegacyPeerInfo describes a peer that doesn't support the handshake, it has none of the capabilities
+func LegacyPeerInfo(nodeID string) PeerInfo {
+	return PeerInfo{NodeID: nodeID, ProtocolVersion: 1, MinProtocolVersion: 1, SoftwareVersion: "unknown"}
+}
+
+// Compatible checks this node and the peer speak a common protocol version
+func (p PeerInfo) Compatible() error {
+	if p.ProtocolVersion < MinProtocolVersion {
+		return fmt.Errorf("Peer %s speaks protocol version %d, versions %d to %d are supported", p.NodeID,
+			p.ProtocolVersion, MinProtocolVersion, ProtocolVersion)
+	}
+	if p.MinProtocolVersion > ProtocolVersion {
+		return fmt.Errorf("Peer %s requires protocol version %d, this node speaks version %d", p.NodeID,
+			p.MinProtocolVersion, ProtocolVersion)
+	}
+	return nil
+}
+
+// Version returns the protocol version spoken with the peer, the newest version both of them speak
+func (p PeerInfo) Version() int {
+	if p.ProtocolVersion < ProtocolVersion {
+		return p.ProtocolVersion
+	}
+	return ProtocolVersion
+}
+
+// Supports checks if the peer has a capability
+func (p PeerInfo) Supports(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// KnownStatus checks if a status is part of the protocol spoken with the peer, peers of newer versions may send
+// statuses this node would misread
+func (p PeerInfo) KnownStatus(s Status) bool {
+	last, ok := protocolStatuses[p.Version()]
+	return ok && s <= last
+}
+
+func (p PeerInfo) String() string {
+	return fmt.Sprintf("%s (protocol=%d, version=%s, capabilities=%s)", p.NodeID, p.ProtocolVersion, p.SoftwareVersion,
+		strings.Join(p.Capabilities, ","))
+}
@@ -0,0 +1,46 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test peers are compatible when they speak a common protocol version
+func TestPeerInfoCompatible(t *testing.T) {
+	local := LocalPeerInfo("local")
+	assert.Nil(t, local.Compatible())
+	assert.Equal(t, ProtocolVersion, local.Version())
+	legacy := LegacyPeerInfo("legacy")
+	assert.Nil(t, legacy.Compatible())
+	assert.Equal(t, 1, legacy.Version())
+	newer := PeerInfo{NodeID: "newer", ProtocolVersion: ProtocolVersion + 2, MinProtocolVersion: ProtocolVersion}
+	assert.Nil(t, newer.Compatible())
+	assert.Equal(t, ProtocolVersion, newer.Version())
+	newer.MinProtocolVersion = ProtocolVersion + 1
+	assert.NotNil(t, newer.Compatible())
+	assert.NotNil(t, PeerInfo{NodeID: "older", ProtocolVersion: MinProtocolVersion - 1}.Compatible())
+}
+
+// Test capabilities of peers, legacy peers have none
+func TestPeerInfoSupports(t *testing.T) {
+	local := LocalPeerInfo("local")
+	for _, c := range Capabilities {
+		assert.True(t, local.Supports(c))
+		assert.False(t, LegacyPeerInfo("legacy").Supports(c))
+	}
+	assert.False(t, local.Supports("teleport"))
+}
+
+// Test statuses of newer protocol versions aren't known to older peers
+func TestPeerInfoKnownStatus(t *testing.T) {
+	local := LocalPeerInfo("local")
+	legacy := LegacyPeerInfo("legacy")
+	for _, s := range []Status{New, Paused, Downloading, Finished, Seeding} {
+		assert.True(t, local.KnownStatus(s))
+		assert.True(t, legacy.KnownStatus(s))
+	}
+	assert.True(t, local.KnownStatus(Corrupt))
+	assert.False(t, legacy.KnownStatus(Corrupt))
+	assert.False(t, local.KnownStatus(Corrupt+1))
+}
@@ -17,6 +17,9 @@ import (
 	"github.com/spf13/afero"
 )
 
+// backoffRetry is how often fragments are requested again while all peers that have them are backing off
+const backoffRetry = 100 * time.Millisecond
+
 // A Request represents a file transfer request to be sent by a Client.
 type Request struct {
 	// dlDirectory is where the file will be saved
@@ -37,21 +40,87 @@ type Request struct {
 
 	// downloadMethod is the algorithim called every time a new fragment needs to be downloaded
 	dlMethod DownloadMethod
+
+	// window is the maximum amount of fragments downloaded at once
+	window int
+
+	// limiter limits the download rate of all fragments, nil when there is no limit
+	limiter *RateLimiter
+
+	// key decrypts encrypted files as they are downloaded, a passphrase or a private key
+	key string
+
+	// exchange creates clients of peers learned from other peers, nil when peers aren't exchanged
+	exchange CreateClient
+
+	// maxPeers bounds the peers of the request, peers learned from other peers aren't added beyond it
+	maxPeers int
+
+	// exchanged holds the names of the peers that were asked for the peers they know
+	exchanged map[string]bool
+
+	// providers finds the peers providing a file, nil when the file is looked for on every peer
+	providers ProviderFinder
+
+	// providerFactory creates clients of the peers providing a file
+	providerFactory CreateClient
+}
+
+// NewRequest creates a new request for download / listing files from remote peers, downloading up to window fragments at once
+func NewRequest(dlPath string, db *gorm.DB, resolver PeerResolver, dlMethod DownloadMethod, window int) *Request {
+	if window < 1 {
+		window = 1
+	}
+	return &Request{dlPath, make(map[string]Client), resolver, db, sync.RWMutex{}, dlMethod, window, nil, "", nil, 0,
+		make(map[string]bool), nil, nil}
+}
+
+// SetDownloadLimit limits the bytes per second downloaded from all peers, 0 means no limit
+func (r *Request) SetDownloadLimit(bytesPerSecond int64) {
+	r.limiter = NewRateLimiter(bytesPerSecond)
+}
+
+// SetDecryptionKey sets the passphrase or private key decrypting encrypted files, the encrypted file is downloaded and
+// seeded as is and fragments are decrypted to another file as they are downloaded
+func (r *Request) SetDecryptionKey(key string) {
+	r.key = key
+}
+
+// SetPeerExchange asks discovered peers for the peers they know, so peers discovery can't reach are found through
+// their neighbors. Clients of these peers are created with factory, they are added while the request has less than
+// maxPeers peers
+func (r *Request) SetPeerExchange(factory CreateClient, maxPeers int) {
+	r.exchange = factory
+	r.maxPeers = maxPeers
 }
 
-// NewRequest creates a new request for download / listing files from remote peers
-func NewRequest(dlPath string, db *gorm.DB, resolver PeerResolver, dlMethod DownloadMethod) *Request {
-	return &Request{dlPath, make(map[string]Client), resolver, db, sync.RWMutex{}, dlMethod}
+// SetProviderFinder looks up the peers providing a file before downloading it, so only they are asked for the file.
+// Clients of the providers are created with factory. Files without providers are looked for on every peer
+func (r *Request) SetProviderFinder(finder ProviderFinder, factory CreateClient) {
+	r.providers = finder
+	r.providerFactory = factory
 }
 
 // List shows all available files in the network, in a specific point, if a peer is offline, his files won't show.
 func (r *Request) List(ctx context.Context) []FileMetaData {
+	ctx, cancel := context.WithCancel(ctx)
+	defer r.closePeers()
+	defer cancel()
+	return r.list(ctx)
+}
+
+// list lists the files of all peers, discovering peers first
+func (r *Request) list(ctx context.Context) []FileMetaData {
 	// start discover
 	r.startDiscover(ctx, true)
 	r.rwLock.RLock()
 	var allFiles []FileMetaData
 	for _, client := range r.peers {
-		files, _ := client.List(ctx)
+		files, err := client.List(ctx)
+		if err != nil {
+			log.Warnf("Failed to list files of %s. Reason: %s", client.Name(), err)
+			continue
+		}
 		allFiles = append(allFiles, files...)
 	}
 	r.rwLock.RUnlock()
@@ -60,6 +129,16 @@ func (r *Request) List(ctx context.Context) []FileMetaData {
 
 // Download a remote file based on hash to local system from remote peers
 func (r *Request) Download(ctx context.Context, fs afero.Fs, fileHash string) {
+	// Legacy hashes are accepted as well, they are converted to their self describing form
+	fileHash, err := NormalizeHash(fileHash)
+	if err != nil {
+		log.Errorf("Invalid file hash. Reason: %s", err)
+		return
+	}
+	// Connections to peers are closed once the download is done, discoveries that are still running are canceled
+	ctx, cancel := context.WithCancel(ctx)
+	defer r.closePeers()
+	defer cancel()
 	fm, err := r.getFileMeta(ctx, fileHash)
 	if err != nil {
 		return
@@ -73,7 +152,7 @@ func (r *Request) Download(ctx context.Context, fs afero.Fs, fileHash string) {
 	// Look for new peers whilst downloading
 	go r.startDiscover(ctx, false)
 	// Open file to save downloaded fragments
-	f, err := fs.OpenFile(path.Join(r.dlDirectory, fm.Name), os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := fs.OpenFile(path.Join(r.dlDirectory, fm.Name), os.O_CREATE|os.O_RDWR, 0644)
 	defer f.Close()
 	if err != nil {
 		log.Errorf("Open file failed. Reason: %s", err)
@@ -83,28 +162,157 @@ func (r *Request) Download(ctx context.Context, fs afero.Fs, fileHash string) {
 		log.Errorf("Truncate file failed. Reason: %s", err)
 		return
 	}
+	// Corrupt fragments would be found again once the file is verified
+	if fm.Status == Corrupt {
+		r.repair(f, &fm)
+	}
+	var dec *Decrypter
+	if fm.Encrypted() && r.key == "" {
+		log.Warnf("File %s is encrypted, it will be downloaded without decrypting", fm.Name)
+	} else if fm.Encrypted() {
+		plain, err := fs.OpenFile(path.Join(r.dlDirectory, fm.PlainName()), os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			log.Errorf("Open decrypted file failed. Reason: %s", err)
+			return
+		}
+		defer plain.Close()
+		if err := plain.Truncate(fm.PlainSize()); err != nil {
+			log.Errorf("Truncate decrypted file failed. Reason: %s", err)
+			return
+		}
+		if dec, err = fm.NewDecrypter(r.key, plain); err != nil {
+			log.Errorf("Can't decrypt file %s. Reason: %s", fm.Name, err)
+			return
+		}
+		log.Infof("Decrypting file to %s", fm.PlainName())
+	} else if r.key != "" {
+		log.Warnf("File %s isn't encrypted, ignoring key", fm.Name)
+	}
 	fm.Status = Downloading // Mark file as downloading
 	defer r.db.Save(&fm)    // Make sure status will be saved, in any case of transfer / failure etc
-	tracker, pw := createProgressBar(int64(FileChunkSize * int(fm.FragmentsCount)))
-	fm.Status = r.transfer(ctx, f, fm, tracker, pw)
+	tracker, pw := createProgressBar(fm.Size)
+	fm.Status = r.transfer(ctx, f, fm, dec, tracker, pw)
 	time.Sleep(50 * time.Millisecond) // Sleep 50 ms to allow bar to fully update rendering
-	if fm.Status == Seeding {
-		log.Infof("Finished Downloading %s", fm.Name)
+	if fm.Status != Seeding {
+		return
+	}
+	// Fragments were verified one by one, make sure the file as a whole matches its hash before seeding it
+	log.Info("Verifying downloaded file")
+	if ok, err := fm.VerifyFile(f); !ok {
+		log.Errorf("File %s doesn't match its hash %s, marked as corrupt. Reason: %v", fm.Name, fm.Hash, err)
+		fm.Status = Corrupt
+		return
+	}
+	log.Infof("Finished Downloading %s", fm.Name)
+}
+
+// repair drops the fragments of a corrupt file that don't match their hash, so they are downloaded again. All fragments
+// are dropped when the fragment hashes can't be trusted
+func (r *Request) repair(f afero.File, fm *FileMetaData) {
+	trusted := fm.Algorithm() != MD5 && fm.VerifyFragmentHashes()
+	log.Infof("Checking fragments of corrupt file %s", fm.Name)
+	buffer := make([]byte, fm.Chunk())
+	var fragments []Fragment
+	for _, fragment := range fm.AvailableFragments {
+		data := buffer[:fm.FragmentSize(fragment.FragmentID)]
+		if trusted {
+			if n, _ := f.ReadAt(data, fm.FragmentOffset(fragment.FragmentID)); n == len(data) &&
+				fm.VerifyFragment(fragment.FragmentID, data, nil) {
+				fragments = append(fragments, fragment)
+				continue
+			}
+		}
+		log.Debugf("Fragment(%d) doesn't match its hash, it will be downloaded again", fragment.FragmentID)
+		r.db.Where("fragment_id = ? AND hash_id = ?", fragment.FragmentID, fm.Hash).Delete(&Fragment{})
+	}
+	log.Infof("%d of %d fragments will be downloaded again", len(fm.AvailableFragments)-len(fragments), fm.FragmentsCount)
+	fm.AvailableFragments = fragments
+}
+
+// download is a fragment requested from a peer
+type download struct {
+	fw     *FragmentWriter
+	cancel context.CancelFunc
+	// buffer holds the fragment when it is downloaded from several peers at once, so only verified data is written to file
+	buffer *fragmentBuffer
+}
+
+// fragmentBuffer holds a fragment in memory at its offset in the file
+type fragmentBuffer struct {
+	offset int64
+	data   []byte
+}
+
+// WriteAt writes to the buffer as if it was the file
+func (b *fragmentBuffer) WriteAt(p []byte, off int64) (int, error) {
+	if off < b.offset || off-b.offset+int64(len(p)) > int64(len(b.data)) {
+		return 0, errors.New("Write is out of fragment bounds")
 	}
+	return copy(b.data[off-b.offset:], p), nil
 }
 
-func (r *Request) transfer(ctx context.Context, f afero.File, fm FileMetaData, tracker *progress.Tracker, pw progress.Writer) Status {
-	out := make(chan DownloadResult, 5)
+// transfer downloads the missing fragments of a file to f, fragments are decrypted once verified when dec isn't nil
+func (r *Request) transfer(ctx context.Context, f afero.File, fm FileMetaData, dec *Decrypter, tracker *progress.Tracker,
+	pw progress.Writer) Status {
+	// Results are buffered for the whole window and canceled downloads, so downloads never block after transfer returns
+	out := make(chan DownloadResult, 2*r.window)
+	// Downloads of every fragment by peer, fragments may be downloaded from several peers in endgame
+	downloads := make(map[int]map[string]*download)
+	inFlight := 0
 	// Update tracker based on fragments we already downloaded
-	tracker.Increment(int64(len(fm.AvailableFragments) * FileChunkSize))
+	for _, fragment := range fm.AvailableFragments {
+		tracker.Increment(fm.FragmentSize(fragment.FragmentID))
+		// Fragments downloaded before may not have been decrypted
+		if dec != nil {
+			if err := dec.Decrypt(f, fragment.FragmentID); err != nil {
+				log.Errorf("Failed to decrypt fragment(%d), check the decryption key. Reason: %s", fragment.FragmentID, err)
+				pw.Stop()
+				return Paused
+			}
+		}
+	}
 	time.Sleep(150 * time.Millisecond)
 	// Download all missing fragment, every time a fragment is downloaded update meta file
 	for {
-		// Lock while algorithim is choosing, this preventing any peer changing whilst executing
-		r.rwLock.RLock()
-		peer, fragmentID, err := r.dlMethod.NextFragment(ctx, r.peers, fm)
-		r.rwLock.RUnlock()
-		if err != nil {
+		var err error
+		// Keep the window full, algorithim returns an error when there is nothing to request right now
+		for inFlight < r.window {
+			var peer Client
+			var fragmentID int
+			// Lock while algorithim is choosing, this preventing any peer changing whilst executing
+			r.rwLock.RLock()
+			peer, fragmentID, err = r.dlMethod.NextFragment(ctx, r.peers, fm)
+			r.rwLock.RUnlock()
+			if err != nil {
+				break
+			}
+			inFlight++
+			d := &download{}
+			var dctx context.Context
+			dctx, d.cancel = context.WithCancel(ctx)
+			// Fragments are written to the file by the clients as they are received, unless another peer is writing it
+			if len(downloads[fragmentID]) == 0 {
+				downloads[fragmentID] = make(map[string]*download)
+				d.fw = fm.NewFragmentWriter(f, fragmentID)
+			} else {
+				d.buffer = &fragmentBuffer{fm.FragmentOffset(fragmentID), make([]byte, fm.FragmentSize(fragmentID))}
+				d.fw = fm.NewFragmentWriter(d.buffer, fragmentID)
+			}
+			downloads[fragmentID][peer.Name()] = d
+			// Clients write as they receive, so waiting for the limiter slows down the peer as well
+			go peer.Download(dctx, fm.Hash, fragmentID, LimitWriter(dctx, d.fw, r.limiter), out)
+		}
+		// Peers that failed recently will be requested again once their backoff is over
+		if inFlight == 0 && err == ErrBackoff {
+			select {
+			case <-time.After(backoffRetry):
+				continue
+			case <-ctx.Done():
+				pw.Stop()
+				return Paused
+			}
+		}
+		if inFlight == 0 {
 			pw.Stop()
 			if len(fm.AvailableFragments) == fm.FragmentsCount {
 				log.Info("No more fragments, seeding..")
@@ -119,20 +327,64 @@ func (r *Request) transfer(ctx context.Context, f afero.File, fm FileMetaData, t
 			}
 			return Paused
 		}
-		go peer.Download(ctx, fm.Hash, fragmentID, out)
 		// Wait for downloaded chunk / interrupt
 		select {
 		case dl := <-out:
+			d, ok := downloads[dl.FragmentID][dl.PeerName]
+			// Canceled downloads may still finish, the fragment was already downloaded from another peer
+			if !ok {
+				continue
+			}
+			delete(downloads[dl.FragmentID], dl.PeerName)
+			d.cancel()
+			inFlight--
 			if !dl.Successful {
+				r.dlMethod.Done(dl.PeerName, dl.FragmentID, false)
 				log.Debugf("Download was unssuccesful for fragment(%d)@%s", dl.FragmentID, dl.PeerName)
 				continue
 			}
+			// Data on disk that failed verification is overwritten once the fragment is downloaded again. Corrupted data
+			// isn't a successful download, so the peer's failures aren't forgiven
+			if !d.fw.Verify(dl.Proof) {
+				log.Warnf("Fragment(%d)@%s failed hash verification, discarding", dl.FragmentID, dl.PeerName)
+				r.dlMethod.Cancel(dl.PeerName, dl.FragmentID)
+				r.dlMethod.Reject(dl.PeerName, dl.FragmentID)
+				continue
+			}
+			r.dlMethod.Done(dl.PeerName, dl.FragmentID, true)
+			// Cancel slower downloads of the fragment, their writers are closed so they won't write to file anymore
+			for peerName, other := range downloads[dl.FragmentID] {
+				log.Debugf("Canceling fragment(%d)@%s, it was downloaded from %s", dl.FragmentID, peerName, dl.PeerName)
+				other.cancel()
+				other.fw.Close()
+				inFlight--
+				r.dlMethod.Cancel(peerName, dl.FragmentID)
+			}
+			delete(downloads, dl.FragmentID)
+			if d.buffer != nil {
+				if _, err := f.WriteAt(d.buffer.data, d.buffer.offset); err != nil {
+					log.Errorf("Failed to write fragment(%d). Reason: %s", dl.FragmentID, err)
+					continue
+				}
+			}
 			fm.AvailableFragments = append(fm.AvailableFragments, Fragment{FragmentID: dl.FragmentID, HashID: fm.Hash})
-			// Save fragment to db and write to file
+			// Save fragment to db, it was already written to file
 			r.db.Save(&Fragment{FragmentID: dl.FragmentID, HashID: fm.Hash})
-			f.WriteAt(dl.Data, int64(FileChunkSize*int(dl.FragmentID)))
-			tracker.Increment(FileChunkSize)
-			time.Sleep(50 * time.Millisecond)
+			// Fragments were verified, so they fail to decrypt with the wrong key. The decrypted file would be incomplete
+			if dec != nil {
+				if err := dec.Decrypt(f, dl.FragmentID); err != nil {
+					log.Errorf("Failed to decrypt fragment(%d), check the decryption key. Reason: %s", dl.FragmentID, err)
+					for _, others := range downloads {
+						for _, other := range others {
+							other.cancel()
+							other.fw.Close()
+						}
+					}
+					pw.Stop()
+					return Paused
+				}
+			}
+			tracker.Increment(fm.FragmentSize(dl.FragmentID))
 		case <-ctx.Done():
 			return Paused
 		}
@@ -144,27 +396,112 @@ func (r *Request) getFileMeta(ctx context.Context, fileHash string) (FileMetaDat
 	if !r.db.Where("hash = ?", fileHash).First(&fm).RecordNotFound() {
 		log.Info("Will resume pervious download request")
 		r.db.Model(&fm).Related(&fm.AvailableFragments, "hash_id")
+		// Fragment hashes are needed to seed partial files, try to take them from the network if they are missing
+		if !fm.VerifyFragmentHashes() {
+			if m, err := r.findFileMeta(ctx, fileHash); err == nil && m.VerifyFragmentHashes() {
+				fm.FragmentHashes = m.FragmentHashes
+				r.db.Save(&fm)
+			}
+		}
 		return fm, nil
 	}
 	log.Infof("Meta file(hash=%s) missing, will create a new download", fileHash)
-	// List all files from peers, and look for our file, any meta of that file will suffice
-	ffm := r.List(ctx)
+	m, err := r.findFileMeta(ctx, fileHash)
+	if err != nil {
+		return fm, err
+	}
+	// This is probably a fresh download, so we will assume all fragments are missing
+	m.AvailableFragments = make([]Fragment, 0)
+	log.Infof("Current Fragments: %v", m.AvailableFragments)
+	// FilePath will be our dlDirectory + the file name, that were the meta file will be save aswell
+	m.FilePath = path.Join(r.dlDirectory, m.Name)
+	m.Status = Downloading
+	r.db.Save(&m)
+	return m, nil
+}
+
+// findFileMeta looks for the meta data of a file, on the providers of the file when they can be found and otherwise on
+// all peers
+func (r *Request) findFileMeta(ctx context.Context, fileHash string) (FileMetaData, error) {
+	if r.providers != nil {
+		if m, err := selectFileMeta(r.listProviders(ctx, fileHash), fileHash); err == nil {
+			return m, nil
+		}
+		log.Warnf("Providers of %s weren't found, looking for it on all peers", fileHash)
+	}
+	m, err := selectFileMeta(r.list(ctx), fileHash)
+	if err != nil {
+		log.Errorf("File with hash %s wasn't found in network", fileHash)
+	}
+	return m, err
+}
+
+// listProviders adds the peers providing a file to the request, and lists their files. Peers are discovered first,
+// the providers are looked up through them
+func (r *Request) listProviders(ctx context.Context, fileHash string) []FileMetaData {
+	r.startDiscover(ctx, true)
+	providers, err := r.providers.FindProviders(ctx, fileHash)
+	if err != nil {
+		log.Debugf("Failed to find providers of %s. Reason: %s", fileHash, err)
+		return nil
+	}
+	log.Infof("Found %d providers of %s", len(providers), fileHash)
+	var clients []Client
+	var names []string
+	for _, provider := range providers {
+		client, err := r.providerFactory(provider.Name, provider.Addr, provider.Port)
+		if err != nil {
+			log.Errorf("Failed to create client %s. Reason: %s", peerKey(provider), err)
+			continue
+		}
+		clients = append(clients, client)
+		names = append(names, client.Name())
+	}
+	r.addPeers(ctx, clients, 0)
+	r.rwLock.RLock()
+	defer r.rwLock.RUnlock()
+	var files []FileMetaData
+	for _, name := range names {
+		client, ok := r.peers[name]
+		if !ok {
+			continue
+		}
+		found, err := client.List(ctx)
+		if err != nil {
+			log.Warnf("Failed to list files of %s. Reason: %s", name, err)
+			continue
+		}
+		files = append(files, found...)
+	}
+	return files
+}
+
+// selectFileMeta looks for the meta data of a file in the files listed by peers. Peers may send any meta data, so meta
+// data with fragment hashes matching the file hash is preferred, otherwise fragments will be verified only with their
+// proofs.
+func selectFileMeta(ffm []FileMetaData, fileHash string) (FileMetaData, error) {
+	var unverified []FileMetaData
 	for _, m := range ffm {
 		if m.Hash != fileHash {
 			continue
 		}
 		log.Debugf("Found file meta name=%s hash=%s fragments=%d ", m.Name, m.Hash, m.FragmentsCount)
-		// This is probably a fresh download, so we will assume all fragments are missing
-		m.AvailableFragments = make([]Fragment, 0)
-		log.Infof("Current Fragments: %v", m.AvailableFragments)
-		// FilePath will be our dlDirectory + the file name, that were the meta file will be save aswell
-		m.FilePath = path.Join(r.dlDirectory, m.Name)
-		m.Status = Downloading
-		r.db.Save(&m)
-		return m, nil
+		// Fragments are allocated by the sizes peers send
+		if err := m.checkLayout(); err != nil {
+			log.Warnf("Ignored file meta from %s. Reason: %s", m.Publisher, err)
+			continue
+		}
+		if m.VerifyFragmentHashes() {
+			return m, nil
+		}
+		log.Warnf("File meta from %s has invalid fragment hashes", m.Publisher)
+		m.FragmentHashes = nil
+		unverified = append(unverified, m)
 	}
-	log.Errorf("File with hash %s wasn't found in network", fileHash)
-	return fm, errors.New("Failed to find file in network")
+	if len(unverified) > 0 {
+		return unverified[0], nil
+	}
+	return FileMetaData{}, errors.New("Failed to find file in network")
 }
 
 func (r *Request) startDiscover(ctx context.Context, once bool) {
@@ -173,28 +510,122 @@ func (r *Request) startDiscover(ctx context.Context, once bool) {
 		return
 	}
 	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
 	log.Info("Started Discovery")
-	select {
-	case <-ticker.C:
-		log.Debug("Looking for peers..")
-		p2pClients, _ := r.resolver.Discover(ctx)
-		for _, client := range p2pClients {
-			log.Debugf("Found Client(%s)", client.Name())
-			if _, ok := r.peers[client.Name()]; !ok {
-				r.rwLock.Lock()
-				log.Infof("Added Client(%s)", client.Name())
-				r.peers[client.Name()] = client
-				r.rwLock.Unlock()
-			}
-		}
-		if once {
-			log.Info("Discovery executed once")
+	// Peers are looked for until the request is done, unless discovery runs once
+	for {
+		select {
+		case <-ticker.C:
+			log.Debug("Looking for peers..")
+			p2pClients, _ := r.resolver.Discover(ctx)
+			r.addPeers(ctx, p2pClients, 0)
+			r.exchangePeers(ctx)
+			if once {
+				log.Info("Discovery executed once")
+				return
+			}
+		case <-ctx.Done():
+			log.Info("Canceled discovery will stop")
 			return
 		}
-	case <-ctx.Done():
-		log.Info("Canceled discovery will stop")
 	}
-	log.Debug(r.peers)
+}
+
+// addPeers adds the clients of peers that aren't known yet while the request has less than limit peers, 0 means no
+// limit. Clients that aren't added are closed
+func (r *Request) addPeers(ctx context.Context, clients []Client, limit int) {
+	r.rwLock.Lock()
+	defer r.rwLock.Unlock()
+	for _, client := range clients {
+		log.Debugf("Found Client(%s)", client.Name())
+		// Clients of known peers and clients found after the request is done aren't needed
+		if _, ok := r.peers[client.Name()]; ok || ctx.Err() != nil || (limit > 0 && len(r.peers) >= limit) {
+			client.Close()
+			continue
+		}
+		log.Infof("Added Client(%s)", client.Name())
+		r.peers[client.Name()] = client
+	}
+}
+
+// exchangePeers asks the peers that weren't asked yet for the peers they know and adds them. Peers added are asked on
+// the next discovery, so peers further away are reached a hop at a time
+func (r *Request) exchangePeers(ctx context.Context) {
+	if r.exchange == nil {
+		return
+	}
+	r.rwLock.Lock()
+	var asked []Client
+	for name, client := range r.peers {
+		if !r.exchanged[name] {
+			r.exchanged[name] = true
+			asked = append(asked, client)
+		}
+	}
+	r.rwLock.Unlock()
+	// All peers are asked at once, so a slow peer doesn't hold back discovery
+	found := make([][]DiscoveryPayload, len(asked))
+	var wg sync.WaitGroup
+	for i, client := range asked {
+		wg.Add(1)
+		go func(i int, client Client) {
+			defer wg.Done()
+			peers, err := client.PeerExchange(ctx)
+			if err != nil {
+				log.Debugf("Failed to exchange peers with %s. Reason: %s", client.Name(), err)
+				return
+			}
+			// Peers may send any amount of peers
+			if len(peers) > MaxExchangedPeers {
+				peers = peers[:MaxExchangedPeers]
+			}
+			log.Debugf("%s knows %d peers", client.Name(), len(peers))
+			found[i] = peers
+		}(i, client)
+	}
+	wg.Wait()
+	// Clients are only created for peers that aren't known yet and while there is room for them
+	r.rwLock.RLock()
+	known := len(r.peers)
+	seen := make(map[string]bool)
+	for name := range r.peers {
+		seen[name] = true
+	}
+	r.rwLock.RUnlock()
+	var clients []Client
+collect:
+	for _, peers := range found {
+		for _, peer := range peers {
+			if r.maxPeers > 0 && known+len(clients) >= r.maxPeers {
+				break collect
+			}
+			// Clients are named name@port
+			name := fmt.Sprintf("%s@%d", peer.Name, peer.Port)
+			if err := validExchangedPeer(peer); err != nil || seen[peerKey(peer)] || seen[name] {
+				continue
+			}
+			seen[peerKey(peer)] = true
+			client, err := r.exchange(peer.Name, peer.Addr, peer.Port)
+			if err != nil {
+				log.Errorf("Failed to create client %s. Reason: %s", peerKey(peer), err)
+				continue
+			}
+			clients = append(clients, client)
+		}
+	}
+	r.addPeers(ctx, clients, r.maxPeers)
+}
+
+// closePeers closes the clients of all peers once the request is done
+func (r *Request) closePeers() {
+	r.rwLock.Lock()
+	defer r.rwLock.Unlock()
+	for name, client := range r.peers {
+		if err := client.Close(); err != nil {
+			log.Debugf("Failed to close Client(%s). Reason: %s", name, err)
+		}
+		delete(r.peers, name)
+	}
 }
 
 func createProgressBar(total int64) (*progress.Tracker, progress.Writer) {
@@ -0,0 +1,174 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test only the fragments of a corrupt file that don't match their hash are downloaded again
+func TestRepairCorrupt(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewMemMapFs()
+	assert.Nil(t, afero.WriteFile(fs, "data", bytes.Repeat([]byte("fileshare"), 1000), 0644))
+	fm, err := createMetaFile(fs, "data", SHA256, 4096)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, fm.FragmentsCount)
+	fm.Status = Corrupt
+	assert.Nil(t, db.Save(&fm).Error)
+	for _, fragment := range fm.AvailableFragments {
+		assert.Nil(t, db.Save(&fragment).Error)
+	}
+	f, err := fs.OpenFile("data", os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	defer f.Close()
+	_, err = f.WriteAt([]byte("corrupt"), fm.FragmentOffset(1)+10)
+	assert.Nil(t, err)
+	r := &Request{db: db}
+	r.repair(f, &fm)
+	assert.Equal(t, []Fragment{{0, fm.Hash}, {2, fm.Hash}}, fm.AvailableFragments)
+	var saved []Fragment
+	db.Where("hash_id = ?", fm.Hash).Order("fragment_id").Find(&saved)
+	assert.Equal(t, fm.AvailableFragments, saved)
+	// Fragments can't be checked without trusted fragment hashes
+	fm.FragmentHashes = nil
+	r.repair(f, &fm)
+	assert.Empty(t, fm.AvailableFragments)
+	db.Where("hash_id = ?", fm.Hash).Find(&saved)
+	assert.Empty(t, saved)
+}
+
+// Test meta data with fragment hashes matching the file hash is preferred, and meta data that doesn't split the file to
+// its fragment count is ignored
+func TestSelectFileMeta(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.Nil(t, afero.WriteFile(fs, "data", bytes.Repeat([]byte("fileshare"), 1000), 0644))
+	fm, err := createMetaFile(fs, "data", SHA256, 4096)
+	assert.Nil(t, err)
+	unverified := fm
+	unverified.Publisher = "unverified"
+	unverified.FragmentHashes = Checksums{"00", "00", "00"}
+	huge := fm
+	huge.ChunkSize = 1 << 40
+	huge.FragmentsCount = 1
+	negative := fm
+	negative.FragmentsCount = -1
+	padded := fm
+	padded.Size += 4096
+	_, err = selectFileMeta([]FileMetaData{huge, negative, padded}, fm.Hash)
+	assert.NotNil(t, err)
+	m, err := selectFileMeta([]FileMetaData{huge, unverified, negative, padded}, fm.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, "unverified", m.Publisher)
+	assert.Nil(t, m.FragmentHashes)
+	m, err = selectFileMeta([]FileMetaData{unverified, huge, fm}, fm.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, fm, m)
+	_, err = selectFileMeta([]FileMetaData{fm}, "sha256:"+string(bytes.Repeat([]byte("0"), 64)))
+	assert.NotNil(t, err)
+}
+
+// Test transfers fail when fragments don't decrypt, the decrypted file would be incomplete
+func TestTransferDecryptFailure(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewMemMapFs()
+	assert.Nil(t, afero.WriteFile(fs, "secret.pdf", bytes.Repeat([]byte("fileshare"), 100), 0644))
+	key, encryption, err := NewPassphraseKey("correct horse")
+	assert.Nil(t, err)
+	assert.Nil(t, PublishEncrypted(fs, db, "secret.pdf", SHA256, 256, nil, key, encryption))
+	var fm FileMetaData
+	assert.False(t, db.Where("file_path = ?", "secret.pdf"+EncryptedExtension).First(&fm).RecordNotFound())
+	db.Model(&fm).Related(&fm.AvailableFragments, "hash_id")
+	f, err := fs.OpenFile(fm.FilePath, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	defer f.Close()
+	r := NewRequest("", db, nil, NewHighAvailabilityDownloader(time.Second, 0), 1)
+	for _, tampered := range []bool{false, true} {
+		if tampered {
+			_, err = f.WriteAt([]byte("tampered"), fm.FragmentOffset(1))
+			assert.Nil(t, err)
+		}
+		out, err := fs.Create("decrypted.pdf")
+		assert.Nil(t, err)
+		dec, err := fm.NewDecrypter("correct horse", out)
+		assert.Nil(t, err)
+		tracker, pw := createProgressBar(fm.Size)
+		status := r.transfer(context.Background(), f, fm, dec, tracker, pw)
+		out.Close()
+		if tampered {
+			assert.Equal(t, Status(Paused), status)
+		} else {
+			assert.Equal(t, Status(Seeding), status)
+		}
+	}
+}
+
+// corruptPeer is a peer that has every fragment but sends corrupted data
+type corruptPeer struct {
+	fm FileMetaData
+}
+
+func (p corruptPeer) Name() string { return "corrupt" }
+
+func (p corruptPeer) List(ctx context.Context) ([]FileMetaData, error) {
+	return []FileMetaData{p.fm}, nil
+}
+
+func (p corruptPeer) Download(ctx context.Context, fileHash string, fragmentID int, w io.Writer, out chan DownloadResult) {
+	w.Write(bytes.Repeat([]byte{'x'}, int(p.fm.FragmentSize(fragmentID))))
+	out <- DownloadResult{FragmentID: fragmentID, PeerName: p.Name(), Successful: true}
+}
+
+func (p corruptPeer) FragmentsAvailable(ctx context.Context, fileHash string) ([]int, error) {
+	var ids []int
+	for i := 0; i < p.fm.FragmentsCount; i++ {
+		ids = append(ids, i)
+	}
+	return ids, nil
+}
+
+func (p corruptPeer) PeerExchange(ctx context.Context) ([]DiscoveryPayload, error) { return nil, nil }
+
+func (p corruptPeer) Alive() bool { return true }
+
+func (p corruptPeer) Close() error { return nil }
+
+// Test a peer sending corrupted data is rejected without forgiving its earlier failures
+func TestTransferCorruptPeer(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewMemMapFs()
+	assert.Nil(t, afero.WriteFile(fs, "data", bytes.Repeat([]byte("fileshare"), 1000), 0644))
+	fm, err := createMetaFile(fs, "data", SHA256, 4096)
+	assert.Nil(t, err)
+	// The second fragment is missing
+	fm.AvailableFragments = []Fragment{{0, fm.Hash}, {2, fm.Hash}}
+	f, err := fs.OpenFile("data", os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	defer f.Close()
+	dl := NewHighAvailabilityDownloader(time.Second, 0).(*AvailabilityDownloader)
+	r := NewRequest("", db, nil, dl, 1)
+	peer := corruptPeer{fm}
+	r.peers[peer.Name()] = peer
+	dl.Done(peer.Name(), 1, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tracker, pw := createProgressBar(fm.Size)
+	assert.Equal(t, Status(Paused), r.transfer(ctx, f, fm, nil, tracker, pw))
+	assert.Nil(t, ctx.Err())
+	assert.Equal(t, 1, dl.score(peer.Name()).failures)
+	assert.Equal(t, 1, dl.score(peer.Name()).rejects)
+	assert.Equal(t, time.Duration(0), dl.score(peer.Name()).latency)
+	assert.True(t, dl.rejected[1][peer.Name()])
+}
@@ -0,0 +1,118 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CallPolicy bounds every call to a peer with a timeout, and retries calls that fail with transient errors so a single
+// hung or flaky peer doesn't block requests
+type CallPolicy struct {
+	// ListTimeout and FragmentsTimeout bound every attempt of a call, 0 means no timeout
+	ListTimeout      time.Duration
+	FragmentsTimeout time.Duration
+	// DownloadTimeout bounds how long a download may go without receiving data, so downloads slowed down by rate limits
+	// aren't canceled while they make progress. 0 means no timeout
+	DownloadTimeout time.Duration
+	// DHTTimeout bounds every DHT request, DHT requests aren't retried since lookups ask other nodes instead
+	DHTTimeout time.Duration
+	// Retries is how many times a call that failed with a transient error is attempted again
+	Retries int
+	// Backoff is the wait before the first retry, it doubles with every retry and is jittered so peers aren't retried
+	// all at once
+	Backoff time.Duration
+}
+
+// DefaultCallPolicy is used by clients unless another policy is configured
+var DefaultCallPolicy = CallPolicy{ListTimeout: 10 * time.Second, FragmentsTimeout: 5 * time.Second,
+	DownloadTimeout: 2 * time.Minute, DHTTimeout: 3 * time.Second, Retries: 2, Backoff: 200 * time.Millisecond}
+
+// ErrStalled is returned by downloads that didn't receive data for the download timeout
+var ErrStalled = errors.New("Download stalled")
+
+// Call calls f with a context bounded by timeout, calling it again while it fails with errors that transient reports
+// and retries are left. The error of the last attempt is returned.
+func (p CallPolicy) Call(ctx context.Context, timeout time.Duration, transient func(error) bool, f func(context.Context) error) error {
+	backoff := p.Backoff
+	for attempt := 0; ; attempt++ {
+		err := callWithTimeout(ctx, timeout, f)
+		if err == nil || attempt >= p.Retries || ctx.Err() != nil || !transient(err) {
+			return err
+		}
+		// Wait between half and one and a half times the backoff
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)+1))
+		log.Debugf("Attempt %d failed, retrying in %s. Reason: %s", attempt+1, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// callWithTimeout calls f with a context bounded by timeout, 0 means no timeout
+func callWithTimeout(ctx context.Context, timeout time.Duration, f func(context.Context) error) error {
+	if timeout <= 0 {
+		return f(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return f(ctx)
+}
+
+// Download calls f like Call, but an attempt only fails once nothing was written to w for DownloadTimeout. Attempts
+// that stalled are retried like transient errors, unless they wrote data since the data written can't be taken back.
+func (p CallPolicy) Download(ctx context.Context, w *CountingWriter, transient func(error) bool, f func(context.Context) error) error {
+	return p.Call(ctx, 0, func(err error) bool {
+		return w.Written() == 0 && (err == ErrStalled || transient(err))
+	}, func(ctx context.Context) error {
+		return callWithIdleTimeout(ctx, p.DownloadTimeout, w, f)
+	})
+}
+
+// callWithIdleTimeout calls f with a context canceled once nothing was written to w for timeout, 0 means no timeout
+func callWithIdleTimeout(ctx context.Context, timeout time.Duration, w *CountingWriter, f func(context.Context) error) error {
+	if timeout <= 0 {
+		return f(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+	stalled := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+			case <-done:
+				return
+			}
+			last := w.LastWrite()
+			if last.Before(start) {
+				last = start
+			}
+			if idle := time.Since(last); idle < timeout {
+				timer.Reset(timeout - idle)
+				continue
+			}
+			close(stalled)
+			cancel()
+			return
+		}
+	}()
+	err := f(ctx)
+	select {
+	case <-stalled:
+		return ErrStalled
+	default:
+		return err
+	}
+}
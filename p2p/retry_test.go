@@ -0,0 +1,115 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTransient = errors.New("transient")
+
+func isTransient(err error) bool {
+	return err == errTransient
+}
+
+// Test calls failing with transient errors are retried until they succeed or no retries are left
+func TestCallPolicyRetries(t *testing.T) {
+	policy := CallPolicy{Retries: 2, Backoff: time.Millisecond}
+	attempts := 0
+	err := policy.Call(context.Background(), 0, isTransient, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+	attempts = 0
+	err = policy.Call(context.Background(), 0, isTransient, func(ctx context.Context) error {
+		attempts++
+		return errTransient
+	})
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 3, attempts)
+	// Other errors aren't retried
+	attempts = 0
+	err = policy.Call(context.Background(), 0, isTransient, func(ctx context.Context) error {
+		attempts++
+		return errors.New("File not found")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+// Test every attempt is bounded by the timeout, and calls stop once the context is done
+func TestCallPolicyTimeout(t *testing.T) {
+	policy := CallPolicy{Retries: 1, Backoff: time.Millisecond}
+	attempts := 0
+	start := time.Now()
+	err := policy.Call(context.Background(), 20*time.Millisecond, func(err error) bool {
+		return err == context.DeadlineExceeded
+	}, func(ctx context.Context) error {
+		attempts++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 2, attempts)
+	assert.True(t, time.Since(start) < time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts = 0
+	err = CallPolicy{Retries: 5, Backoff: time.Hour}.Call(ctx, 0, isTransient, func(ctx context.Context) error {
+		attempts++
+		cancel()
+		return errTransient
+	})
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, attempts)
+}
+
+// Test downloads time out once they stop receiving data rather than after a fixed time, and stalled attempts are only
+// retried when they didn't write data
+func TestCallPolicyDownload(t *testing.T) {
+	policy := CallPolicy{DownloadTimeout: 50 * time.Millisecond, Retries: 1, Backoff: time.Millisecond}
+	var buf bytes.Buffer
+	w := NewCountingWriter(&buf)
+	// Slow downloads that keep receiving data take longer than the timeout
+	err := policy.Download(context.Background(), w, isTransient, func(ctx context.Context) error {
+		for i := 0; i < 8; i++ {
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			w.Write([]byte("data"))
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(32), w.Written())
+	stall := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	attempts := 0
+	err = policy.Download(context.Background(), NewCountingWriter(&buf), isTransient, func(ctx context.Context) error {
+		attempts++
+		return stall(ctx)
+	})
+	assert.Equal(t, ErrStalled, err)
+	assert.Equal(t, 2, attempts)
+	attempts = 0
+	w = NewCountingWriter(&buf)
+	err = policy.Download(context.Background(), w, isTransient, func(ctx context.Context) error {
+		attempts++
+		w.Write([]byte("data"))
+		return stall(ctx)
+	})
+	assert.Equal(t, ErrStalled, err)
+	assert.Equal(t, 1, attempts)
+}
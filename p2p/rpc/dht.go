@@ -0,0 +1,244 @@
+package rpc
+
+import (
+	"fileshare/p2p"
+	"net"
+	"strconv"
+	"sync"
+
+	context "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// DHTServer serves the DHT requests of a node, next to its files
+type DHTServer struct {
+	dht *p2p.DHT
+}
+
+// NewDHTServer creates a server answering DHT requests with dht
+func NewDHTServer(dht *p2p.DHT) *DHTServer {
+	return &DHTServer{dht}
+}
+
+// Ping replies with the contact of the node
+func (s *DHTServer) Ping(ctx context.Context, request *DHTPingRequest) (*DHTPingReply, error) {
+	from, err := sender(ctx, request.GetSender())
+	if err != nil {
+		return nil, err
+	}
+	return &DHTPingReply{Node: contactMessage(s.dht.HandlePing(from))}, nil
+}
+
+// FindNode replies with the nodes closest to the target the node knows
+func (s *DHTServer) FindNode(ctx context.Context, request *FindNodeRequest) (*FindNodeReply, error) {
+	from, err := sender(ctx, request.GetSender())
+	if err != nil {
+		return nil, err
+	}
+	target, err := p2p.ParseDHTKey(request.Target)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &FindNodeReply{Closer: contactMessages(s.dht.HandleFindNode(from, target))}, nil
+}
+
+// FindProviders replies with the providers of a key the node knows, and the nodes closest to it
+func (s *DHTServer) FindProviders(ctx context.Context, request *FindProvidersRequest) (*FindProvidersReply, error) {
+	from, err := sender(ctx, request.GetSender())
+	if err != nil {
+		return nil, err
+	}
+	key, err := p2p.ParseDHTKey(request.Key)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	providers, closer := s.dht.HandleFindProviders(from, key)
+	return &FindProvidersReply{Providers: contactMessages(providers), Closer: contactMessages(closer)}, nil
+}
+
+// AddProvider stores a record of the sender providing a key
+func (s *DHTServer) AddProvider(ctx context.Context, request *AddProviderRequest) (*AddProviderReply, error) {
+	from, err := sender(ctx, request.GetSender())
+	if err != nil {
+		return nil, err
+	}
+	key, err := p2p.ParseDHTKey(request.Key)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.dht.HandleAddProvider(from, key); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &AddProviderReply{}, nil
+}
+
+// sender returns the contact of the node sending a request. The address the request came from is used rather than the
+// address the node claims, so nodes can't have records stored for addresses they don't send from
+func sender(ctx context.Context, message *DHTContact) (p2p.DHTContact, error) {
+	contact, err := dhtContact(message)
+	if err != nil {
+		return contact, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			contact.Addr = host
+		}
+	}
+	return contact, nil
+}
+
+// DHTNetwork sends DHT requests over gRPC, connecting to nodes like clients of ClientFactory. Connections are kept
+// until the network is closed
+type DHTNetwork struct {
+	config   TLSConfig
+	swarmKey string
+	policy   p2p.CallPolicy
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// NewDHTNetwork creates a network sending DHT requests, bounded by the DHT timeout of policy
+func NewDHTNetwork(config TLSConfig, swarmKey string, policy p2p.CallPolicy) *DHTNetwork {
+	return &DHTNetwork{config: config, swarmKey: swarmKey, policy: policy, conns: make(map[string]*grpc.ClientConn)}
+}
+
+// Ping returns the contact of a node, nodes listening on all interfaces are reached on the address pinged
+func (n *DHTNetwork) Ping(ctx context.Context, from, to p2p.DHTContact) (p2p.DHTContact, error) {
+	var reply *DHTPingReply
+	err := n.call(ctx, to, func(ctx context.Context, client DHTServiceClient) (err error) {
+		reply, err = client.Ping(ctx, &DHTPingRequest{Sender: contactMessage(from)})
+		return err
+	})
+	if err != nil {
+		return p2p.DHTContact{}, err
+	}
+	contact, err := dhtContact(reply.GetNode())
+	if unspecified(contact.Addr) {
+		contact.Addr = to.Addr
+	}
+	return contact, err
+}
+
+// FindNode returns the nodes closest to target a node knows
+func (n *DHTNetwork) FindNode(ctx context.Context, from, to p2p.DHTContact, target p2p.DHTKey) ([]p2p.DHTContact, error) {
+	var reply *FindNodeReply
+	err := n.call(ctx, to, func(ctx context.Context, client DHTServiceClient) (err error) {
+		reply, err = client.FindNode(ctx, &FindNodeRequest{Sender: contactMessage(from), Target: target[:]})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dhtContacts(reply.GetCloser()), nil
+}
+
+// FindProviders returns the providers of a key a node knows, and the nodes closest to the key it knows
+func (n *DHTNetwork) FindProviders(ctx context.Context, from, to p2p.DHTContact, key p2p.DHTKey) ([]p2p.DHTContact, []p2p.DHTContact, error) {
+	var reply *FindProvidersReply
+	err := n.call(ctx, to, func(ctx context.Context, client DHTServiceClient) (err error) {
+		reply, err = client.FindProviders(ctx, &FindProvidersRequest{Sender: contactMessage(from), Key: key[:]})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return dhtContacts(reply.GetProviders()), dhtContacts(reply.GetCloser()), nil
+}
+
+// AddProvider stores a record of the sender providing a key on a node
+func (n *DHTNetwork) AddProvider(ctx context.Context, from, to p2p.DHTContact, key p2p.DHTKey) error {
+	return n.call(ctx, to, func(ctx context.Context, client DHTServiceClient) error {
+		_, err := client.AddProvider(ctx, &AddProviderRequest{Sender: contactMessage(from), Key: key[:]})
+		return err
+	})
+}
+
+// Close closes the connections to all nodes
+func (n *DHTNetwork) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for addr, conn := range n.conns {
+		conn.Close()
+		delete(n.conns, addr)
+	}
+	return nil
+}
+
+// call sends a request to a node bounded by the DHT timeout, requests aren't retried since lookups ask other nodes
+// instead
+func (n *DHTNetwork) call(ctx context.Context, to p2p.DHTContact, f func(context.Context, DHTServiceClient) error) error {
+	conn, err := n.conn(to)
+	if err != nil {
+		return err
+	}
+	policy := n.policy
+	policy.Retries = 0
+	return policy.Call(ctx, policy.DHTTimeout, transient, func(ctx context.Context) error {
+		return f(ctx, NewDHTServiceClient(conn))
+	})
+}
+
+// conn returns the connection to a node, connecting to it when there is none
+func (n *DHTNetwork) conn(to p2p.DHTContact) (*grpc.ClientConn, error) {
+	addr := net.JoinHostPort(to.Addr, strconv.Itoa(to.Port))
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if conn, ok := n.conns[addr]; ok && conn.GetState() != connectivity.Shutdown {
+		return conn, nil
+	}
+	opts, err := dialOptions(n.config, n.swarmKey, to.Name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	n.conns[addr] = conn
+	return conn, nil
+}
+
+// contactMessage converts a DHTContact to its message
+func contactMessage(c p2p.DHTContact) *DHTContact {
+	return &DHTContact{ID: append([]byte{}, c.ID[:]...), Name: c.Name, Addr: c.Addr, Port: uint32(c.Port)}
+}
+
+// contactMessages converts DHTContacts to their messages
+func contactMessages(contacts []p2p.DHTContact) []*DHTContact {
+	var messages []*DHTContact
+	for _, c := range contacts {
+		messages = append(messages, contactMessage(c))
+	}
+	return messages
+}
+
+// dhtContact converts a DHTContact message to a DHTContact
+func dhtContact(message *DHTContact) (p2p.DHTContact, error) {
+	id, err := p2p.ParseDHTKey(message.GetID())
+	if err != nil {
+		return p2p.DHTContact{}, err
+	}
+	return p2p.DHTContact{ID: id, Name: message.GetName(), Addr: message.GetAddr(), Port: int(message.GetPort())}, nil
+}
+
+// dhtContacts converts DHTContact messages to DHTContacts, invalid contacts are skipped
+func dhtContacts(messages []*DHTContact) []p2p.DHTContact {
+	var contacts []p2p.DHTContact
+	for _, m := range messages {
+		if c, err := dhtContact(m); err == nil {
+			contacts = append(contacts, c)
+		}
+	}
+	return contacts
+}
+
+// unspecified checks if an address doesn't tell where a node is reached, i.e 0.0.0.0
+func unspecified(addr string) bool {
+	ip := net.ParseIP(addr)
+	return addr == "" || ip != nil && ip.IsUnspecified()
+}
@@ -4,8 +4,14 @@ import (
 	"errors"
 	"fileshare/p2p"
 	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
 
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
 
 	log "github.com/sirupsen/logrus"
 
@@ -18,34 +24,124 @@ type P2PClient struct {
 	name, addr string
 	conn       *grpc.ClientConn
 	client     FileServiceClient
+	policy     p2p.CallPolicy
+
+	// peer describes the node once the handshake is done
+	mu   sync.Mutex
+	peer *p2p.PeerInfo
 }
 
 // NewClient creates a new rpc client to connect to a remote peer
 func NewClient(name string, addr string, port int) (p2p.Client, error) {
-	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", addr, port), grpc.WithInsecure())
-	if err != nil {
-		log.Fatalln(err)
-		return nil, errors.New("Failed to create client")
+	return ClientFactory(TLSConfig{}, "", p2p.DefaultCallPolicy)(name, addr, port)
+}
+
+// ClientFactory creates rpc clients connecting with TLS when the config is enabled, the certificate of every remote
+// peer must be issued for its name. In a private swarm every request proves holding the swarm key. Calls are bounded
+// and retried by policy.
+func ClientFactory(config TLSConfig, swarmKey string, policy p2p.CallPolicy) p2p.CreateClient {
+	return func(name string, addr string, port int) (p2p.Client, error) {
+		opts, err := dialOptions(config, swarmKey, name)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := grpc.Dial(fmt.Sprintf("%s:%d", addr, port), opts...)
+		if err != nil {
+			log.Errorf("Failed to dial %s:%d. Reason: %s", addr, port, err)
+			return nil, errors.New("Failed to create client")
+		}
+		client := NewFileServiceClient(conn)
+		return &P2PClient{name: fmt.Sprintf("%s@%d", name, port), addr: addr, conn: conn, client: client,
+			policy: policy}, nil
+	}
+}
+
+// dialOptions returns the options of connections to the node name, connecting with TLS when the config is enabled and
+// proving holding the swarm key in a private swarm
+func dialOptions(config TLSConfig, swarmKey, name string) ([]grpc.DialOption, error) {
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if config.Enabled() {
+		creds, err := config.ClientCredentials(name)
+		if err != nil {
+			log.Errorf("Failed to load TLS credentials. Reason: %s", err)
+			return nil, err
+		}
+		opts[0] = grpc.WithTransportCredentials(creds)
+	}
+	if swarmKey != "" {
+		opts = append(opts, grpc.WithUnaryInterceptor(swarmUnaryInterceptor(swarmKey)),
+			grpc.WithStreamInterceptor(swarmStreamInterceptor(swarmKey)))
+	}
+	return opts, nil
+}
+
+// handshake introduces the client to the node before its first request, nodes that don't support the handshake are
+// legacy peers. Nodes speaking an incompatible protocol version are refused.
+func (p *P2PClient) handshake(ctx context.Context) (p2p.PeerInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peer != nil {
+		return *p.peer, p.peer.Compatible()
+	}
+	hostname, _ := os.Hostname()
+	info := p2p.LegacyPeerInfo(p.Name())
+	var reply *HelloReply
+	err := p.policy.Call(ctx, p.policy.ListTimeout, transient, func(ctx context.Context) (err error) {
+		reply, err = p.client.Hello(ctx, &HelloRequest{Node: nodeInfo(p2p.LocalPeerInfo(hostname))})
+		return err
+	})
+	switch {
+	case status.Code(err) == codes.Unimplemented:
+		log.Debugf("%s doesn't support the handshake, assuming protocol version %d", p.Name(), info.ProtocolVersion)
+	case err != nil:
+		return info, err
+	default:
+		info = peerInfo(reply.Node)
+	}
+	p.peer = &info
+	if err := info.Compatible(); err != nil {
+		log.Warnf("Refused %s. Reason: %s", p.Name(), err)
+		return info, err
 	}
-	client := NewFileServiceClient(conn)
-	return &P2PClient{fmt.Sprintf("%s@%d", name, port), addr, conn, client}, nil
+	log.Debugf("Connected to %s", info)
+	return info, nil
 }
 
 // List remote files on remote node
 func (p *P2PClient) List(ctx context.Context) ([]p2p.FileMetaData, error) {
-	response, err := p.client.RemoteList(ctx, &ListRequest{FullDetails: false})
+	remote, err := p.handshake(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var response *ListReply
+	err = p.policy.Call(ctx, p.policy.ListTimeout, transient, func(ctx context.Context) (err error) {
+		response, err = p.client.RemoteList(ctx, &ListRequest{FullDetails: false})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	var files []p2p.FileMetaData
 	for _, f := range response.GetFiles() {
+		// Older nodes list legacy hashes without their algorithm
+		hash, err := p2p.NormalizeHash(f.Hash)
+		if err != nil {
+			log.Debugf("Skipped file %s. Reason: %s", f.Name, err)
+			continue
+		}
+		// Statuses of newer protocol versions would be misread
+		if !remote.KnownStatus(p2p.Status(f.Status)) {
+			log.Debugf("Skipped file %s, status %d is unknown to protocol version %d", f.Name, f.Status, remote.Version())
+			continue
+		}
 		var fragments []p2p.Fragment
 		for _, id := range f.AvailableFragments {
-			fragments = append(fragments, p2p.Fragment{FragmentID: int(id), HashID: f.Hash})
+			fragments = append(fragments, p2p.Fragment{FragmentID: int(id), HashID: hash})
 		}
 		files = append(files, p2p.FileMetaData{Name: f.Name, FilePath: "", Publisher: p.Name(),
-			Hash: f.Hash, Size: f.Size, FragmentsCount: int(f.FragmentCount),
-			AvailableFragments: fragments, Status: p2p.Status(f.Status)})
+			Hash: hash, Size: f.Size, ChunkSize: f.ChunkSize, FragmentsCount: int(f.FragmentCount),
+			FragmentHashes: f.FragmentHashes, AvailableFragments: fragments, Status: p2p.Status(f.Status),
+			Encryption: f.Encryption})
 	}
 	return files, nil
 }
@@ -55,35 +151,150 @@ func (p *P2PClient) Name() string {
 	return p.name
 }
 
-// Download a fragment of a file from remote client
-func (p *P2PClient) Download(ctx context.Context, fileHash string, fragmentID int, out chan p2p.DownloadResult) {
-	reply, err := p.client.RemoteDownload(ctx, &DownloadRequest{FileHash: fileHash, RequestedFragment: uint32(fragmentID)})
+// Download a fragment of a file from remote client, the fragment is streamed in frames that are written to w
+func (p *P2PClient) Download(ctx context.Context, fileHash string, fragmentID int, w io.Writer, out chan p2p.DownloadResult) {
+	remote, err := p.handshake(ctx)
+	var proof [][]byte
+	if err == nil {
+		written := p2p.NewCountingWriter(w)
+		err = p.policy.Download(ctx, written, transient, func(ctx context.Context) (err error) {
+			proof, err = p.fragment(ctx, remote, fileHash, fragmentID, written)
+			return err
+		})
+	}
 	if err == nil {
-		out <- p2p.DownloadResult{FragmentID: fragmentID, PeerName: p.Name(), Data: reply.Data, Successful: true}
+		out <- p2p.DownloadResult{FragmentID: fragmentID, PeerName: p.Name(), Proof: proof, Successful: true}
 		return
 	}
 	if ctx.Err() == context.Canceled {
 		return
 	}
-	log.Debug("Failed to download fragment. Reason: %s", err)
-	out <- p2p.DownloadResult{FragmentID: fragmentID, PeerName: p.Name(), Data: nil, Successful: false}
+	log.Debugf("Failed to download fragment. Reason: %s", err)
+	out <- p2p.DownloadResult{FragmentID: fragmentID, PeerName: p.Name(), Successful: false}
+}
+
+// fragment writes a fragment to w, returning the proof of the fragment
+func (p *P2PClient) fragment(ctx context.Context, remote p2p.PeerInfo, fileHash string, fragmentID int, w io.Writer) ([][]byte, error) {
+	// Legacy peers may stream fragments without telling
+	if remote.Supports(p2p.StreamCapability) || remote.Version() == 1 {
+		proof, err := p.stream(ctx, fileHash, fragmentID, w)
+		if status.Code(err) != codes.Unimplemented {
+			return proof, err
+		}
+	}
+	// Older nodes can only send whole fragments
+	reply, err := p.client.RemoteDownload(ctx, &DownloadRequest{FileHash: fileHash, RequestedFragment: uint32(fragmentID),
+		Compression: p2p.CompressionNames(p2p.Compressions)})
+	if err != nil {
+		return nil, err
+	}
+	data, err := p2p.Decompress(p2p.Compression(reply.Compression), reply.Data)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	return reply.Proof, err
+}
+
+// stream receives the frames of a fragment and writes them to w, returning the proof of the fragment
+func (p *P2PClient) stream(ctx context.Context, fileHash string, fragmentID int, w io.Writer) ([][]byte, error) {
+	stream, err := p.client.RemoteStream(ctx, &StreamRequest{FileHash: fileHash, FirstFragment: uint32(fragmentID), FragmentCount: 1,
+		Compression: p2p.CompressionNames(p2p.Compressions)})
+	if err != nil {
+		return nil, err
+	}
+	var proof [][]byte
+	var stats p2p.CompressionStats
+	for {
+		frame, err := stream.Recv()
+		if err == io.EOF {
+			log.Debugf("Received fragment(%d)@%s, %s", fragmentID, p.Name(), stats)
+			return proof, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if int(frame.FragmentID) != fragmentID {
+			return nil, fmt.Errorf("Received fragment %d instead of %d", frame.FragmentID, fragmentID)
+		}
+		if frame.Proof != nil {
+			proof = frame.Proof
+		}
+		start := time.Now()
+		data, err := p2p.Decompress(p2p.Compression(frame.Compression), frame.Data)
+		if err != nil {
+			return nil, err
+		}
+		if frame.Compression != "" {
+			stats.Compression = p2p.Compression(frame.Compression)
+		}
+		stats.Add(len(data), len(frame.Data), time.Since(start))
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
 }
 
-// FragmentsAvailable checks if fragment is available on remote client
-func (p *P2PClient) FragmentsAvailable(ctx context.Context, fileHash string) []int {
-	reply, err := p.client.RemoteFragmentsAvailable(ctx, &FragmentRequest{FileHash: fileHash})
+// FragmentsAvailable returns the fragments of a file available on remote client, an error means the fragments are
+// unknown, not that there are none
+func (p *P2PClient) FragmentsAvailable(ctx context.Context, fileHash string) ([]int, error) {
+	if _, err := p.handshake(ctx); err != nil {
+		return nil, err
+	}
+	var reply *FragmentReply
+	err := p.policy.Call(ctx, p.policy.FragmentsTimeout, transient, func(ctx context.Context) (err error) {
+		reply, err = p.client.RemoteFragmentsAvailable(ctx, &FragmentRequest{FileHash: fileHash})
+		return err
+	})
 	if err != nil {
-		log.Debugf("Fail to check available fragments. Reason: %s", err)
-		return make([]int, 0)
+		return nil, err
 	}
-	var fragmentIDs []int
+	fragmentIDs := make([]int, 0, len(reply.AvailableFragments))
 	for _, i := range reply.AvailableFragments {
 		fragmentIDs = append(fragmentIDs, int(i))
 	}
-	return fragmentIDs
+	return fragmentIDs, nil
+}
+
+// PeerExchange returns the peers the remote node knows about, nodes without the pex capability know none
+func (p *P2PClient) PeerExchange(ctx context.Context) ([]p2p.DiscoveryPayload, error) {
+	remote, err := p.handshake(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !remote.Supports(p2p.PeerExchangeCapability) {
+		return nil, nil
+	}
+	var reply *PeerExchangeReply
+	err = p.policy.Call(ctx, p.policy.ListTimeout, transient, func(ctx context.Context) (err error) {
+		reply, err = p.client.PeerExchange(ctx, &PeerExchangeRequest{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var peers []p2p.DiscoveryPayload
+	for _, peer := range reply.GetPeers() {
+		peers = append(peers, p2p.DiscoveryPayload{Name: peer.Name, Addr: peer.Addr, Port: int(peer.Port)})
+	}
+	return peers, nil
 }
 
 // Alive checks if rpc client is alive and ready for commands
 func (p *P2PClient) Alive() bool {
 	return p.conn.GetState() != connectivity.Shutdown
 }
+
+// Close closes the connection to the remote node
+func (p *P2PClient) Close() error {
+	return p.conn.Close()
+}
+
+// transient checks if a call failed with an error that may not happen again, such as an unavailable peer or a timeout
+func transient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
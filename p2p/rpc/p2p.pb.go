@@ -32,6 +32,9 @@ const (
 	Status_PAUSED      Status = 1
 	Status_DOWNLOADING Status = 2
 	Status_FINISHED    Status = 3
+	Status_SEEDING     Status = 4
+	// CORRUPT is only sent to peers of protocol version 2 and up
+	Status_CORRUPT Status = 5
 )
 
 var Status_name = map[int32]string{
@@ -39,6 +42,8 @@ var Status_name = map[int32]string{
 	1: "PAUSED",
 	2: "DOWNLOADING",
 	3: "FINISHED",
+	4: "SEEDING",
+	5: "CORRUPT",
 }
 
 var Status_value = map[string]int32{
@@ -46,6 +51,8 @@ var Status_value = map[string]int32{
 	"PAUSED":      1,
 	"DOWNLOADING": 2,
 	"FINISHED":    3,
+	"SEEDING":     4,
+	"CORRUPT":     5,
 }
 
 func (x Status) String() string {
@@ -56,8 +63,161 @@ func (Status) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_e7fdddb109e6467a, []int{0}
 }
 
+// HelloRequest introduces a client to a node, it is sent once before any other request
+type HelloRequest struct {
+	Node                 *NodeInfo `protobuf:"bytes,1,opt,name=Node,proto3" json:"Node,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *HelloRequest) Reset()         { *m = HelloRequest{} }
+func (m *HelloRequest) String() string { return proto.CompactTextString(m) }
+func (*HelloRequest) ProtoMessage()    {}
+func (*HelloRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{0}
+}
+
+func (m *HelloRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HelloRequest.Unmarshal(m, b)
+}
+func (m *HelloRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HelloRequest.Marshal(b, m, deterministic)
+}
+func (m *HelloRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HelloRequest.Merge(m, src)
+}
+func (m *HelloRequest) XXX_Size() int {
+	return xxx_messageInfo_HelloRequest.Size(m)
+}
+func (m *HelloRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_HelloRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HelloRequest proto.InternalMessageInfo
+
+func (m *HelloRequest) GetNode() *NodeInfo {
+	if m != nil {
+		return m.Node
+	}
+	return nil
+}
+
+// HelloReply introduces the node, nodes reply with FailedPrecondition to clients of incompatible protocol versions
+type HelloReply struct {
+	Node                 *NodeInfo `protobuf:"bytes,1,opt,name=Node,proto3" json:"Node,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *HelloReply) Reset()         { *m = HelloReply{} }
+func (m *HelloReply) String() string { return proto.CompactTextString(m) }
+func (*HelloReply) ProtoMessage()    {}
+func (*HelloReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{1}
+}
+
+func (m *HelloReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_HelloReply.Unmarshal(m, b)
+}
+func (m *HelloReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_HelloReply.Marshal(b, m, deterministic)
+}
+func (m *HelloReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_HelloReply.Merge(m, src)
+}
+func (m *HelloReply) XXX_Size() int {
+	return xxx_messageInfo_HelloReply.Size(m)
+}
+func (m *HelloReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_HelloReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_HelloReply proto.InternalMessageInfo
+
+func (m *HelloReply) GetNode() *NodeInfo {
+	if m != nil {
+		return m.Node
+	}
+	return nil
+}
+
+// NodeInfo describes a peer, the protocol versions it speaks and its capabilities, i.e stream, proof, compression
+type NodeInfo struct {
+	NodeID               string   `protobuf:"bytes,1,opt,name=NodeID,proto3" json:"NodeID,omitempty"`
+	ProtocolVersion      uint32   `protobuf:"varint,2,opt,name=ProtocolVersion,proto3" json:"ProtocolVersion,omitempty"`
+	MinProtocolVersion   uint32   `protobuf:"varint,3,opt,name=MinProtocolVersion,proto3" json:"MinProtocolVersion,omitempty"`
+	SoftwareVersion      string   `protobuf:"bytes,4,opt,name=SoftwareVersion,proto3" json:"SoftwareVersion,omitempty"`
+	Capabilities         []string `protobuf:"bytes,5,rep,name=Capabilities,proto3" json:"Capabilities,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NodeInfo) Reset()         { *m = NodeInfo{} }
+func (m *NodeInfo) String() string { return proto.CompactTextString(m) }
+func (*NodeInfo) ProtoMessage()    {}
+func (*NodeInfo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{2}
+}
+
+func (m *NodeInfo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NodeInfo.Unmarshal(m, b)
+}
+func (m *NodeInfo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NodeInfo.Marshal(b, m, deterministic)
+}
+func (m *NodeInfo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NodeInfo.Merge(m, src)
+}
+func (m *NodeInfo) XXX_Size() int {
+	return xxx_messageInfo_NodeInfo.Size(m)
+}
+func (m *NodeInfo) XXX_DiscardUnknown() {
+	xxx_messageInfo_NodeInfo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NodeInfo proto.InternalMessageInfo
+
+func (m *NodeInfo) GetNodeID() string {
+	if m != nil {
+		return m.NodeID
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetProtocolVersion() uint32 {
+	if m != nil {
+		return m.ProtocolVersion
+	}
+	return 0
+}
+
+func (m *NodeInfo) GetMinProtocolVersion() uint32 {
+	if m != nil {
+		return m.MinProtocolVersion
+	}
+	return 0
+}
+
+func (m *NodeInfo) GetSoftwareVersion() string {
+	if m != nil {
+		return m.SoftwareVersion
+	}
+	return ""
+}
+
+func (m *NodeInfo) GetCapabilities() []string {
+	if m != nil {
+		return m.Capabilities
+	}
+	return nil
+}
+
 // The request message containing the user's name.
 type ListRequest struct {
+	// fullDetails isn't used, every file is listed with its details
 	FullDetails          bool     `protobuf:"varint,1,opt,name=fullDetails,proto3" json:"fullDetails,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -68,7 +228,7 @@ func (m *ListRequest) Reset()         { *m = ListRequest{} }
 func (m *ListRequest) String() string { return proto.CompactTextString(m) }
 func (*ListRequest) ProtoMessage()    {}
 func (*ListRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e7fdddb109e6467a, []int{0}
+	return fileDescriptor_e7fdddb109e6467a, []int{3}
 }
 
 func (m *ListRequest) XXX_Unmarshal(b []byte) error {
@@ -107,7 +267,7 @@ func (m *ListReply) Reset()         { *m = ListReply{} }
 func (m *ListReply) String() string { return proto.CompactTextString(m) }
 func (*ListReply) ProtoMessage()    {}
 func (*ListReply) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e7fdddb109e6467a, []int{1}
+	return fileDescriptor_e7fdddb109e6467a, []int{4}
 }
 
 func (m *ListReply) XXX_Unmarshal(b []byte) error {
@@ -136,14 +296,21 @@ func (m *ListReply) GetFiles() []*MetaData {
 }
 
 type MetaData struct {
-	Name                 string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
-	Publisher            string   `protobuf:"bytes,2,opt,name=Publisher,proto3" json:"Publisher,omitempty"`
-	Hash                 string   `protobuf:"bytes,3,opt,name=Hash,proto3" json:"Hash,omitempty"`
-	Size                 int64    `protobuf:"varint,4,opt,name=Size,proto3" json:"Size,omitempty"`
-	Downloadable         bool     `protobuf:"varint,5,opt,name=Downloadable,proto3" json:"Downloadable,omitempty"`
-	FragmentCount        int32    `protobuf:"varint,6,opt,name=FragmentCount,proto3" json:"FragmentCount,omitempty"`
-	AvailableFragments   []int32  `protobuf:"varint,7,rep,packed,name=AvailableFragments,proto3" json:"AvailableFragments,omitempty"`
-	Status               Status   `protobuf:"varint,8,opt,name=Status,proto3,enum=rpc.Status" json:"Status,omitempty"`
+	Name      string `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	Publisher string `protobuf:"bytes,2,opt,name=Publisher,proto3" json:"Publisher,omitempty"`
+	Hash      string `protobuf:"bytes,3,opt,name=Hash,proto3" json:"Hash,omitempty"`
+	Size      int64  `protobuf:"varint,4,opt,name=Size,proto3" json:"Size,omitempty"`
+	// Downloadable is set when the publisher holds every fragment of the file
+	Downloadable       bool    `protobuf:"varint,5,opt,name=Downloadable,proto3" json:"Downloadable,omitempty"`
+	FragmentCount      int32   `protobuf:"varint,6,opt,name=FragmentCount,proto3" json:"FragmentCount,omitempty"`
+	AvailableFragments []int32 `protobuf:"varint,7,rep,packed,name=AvailableFragments,proto3" json:"AvailableFragments,omitempty"`
+	Status             Status  `protobuf:"varint,8,opt,name=Status,proto3,enum=rpc.Status" json:"Status,omitempty"`
+	// FragmentHashes holds the hash of every fragment ordered by fragment id
+	FragmentHashes []string `protobuf:"bytes,9,rep,name=FragmentHashes,proto3" json:"FragmentHashes,omitempty"`
+	// ChunkSize is the size of every fragment but the last, older nodes don't send it and use 1 MB chunks
+	ChunkSize int64 `protobuf:"varint,10,opt,name=ChunkSize,proto3" json:"ChunkSize,omitempty"`
+	// Encryption describes how fragments were encrypted, i.e scrypt:<salt>:<key check>, empty for plain files
+	Encryption           string   `protobuf:"bytes,11,opt,name=Encryption,proto3" json:"Encryption,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -153,7 +320,7 @@ func (m *MetaData) Reset()         { *m = MetaData{} }
 func (m *MetaData) String() string { return proto.CompactTextString(m) }
 func (*MetaData) ProtoMessage()    {}
 func (*MetaData) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e7fdddb109e6467a, []int{2}
+	return fileDescriptor_e7fdddb109e6467a, []int{5}
 }
 
 func (m *MetaData) XXX_Unmarshal(b []byte) error {
@@ -230,9 +397,32 @@ func (m *MetaData) GetStatus() Status {
 	return Status_NEW
 }
 
+func (m *MetaData) GetFragmentHashes() []string {
+	if m != nil {
+		return m.FragmentHashes
+	}
+	return nil
+}
+
+func (m *MetaData) GetChunkSize() int64 {
+	if m != nil {
+		return m.ChunkSize
+	}
+	return 0
+}
+
+func (m *MetaData) GetEncryption() string {
+	if m != nil {
+		return m.Encryption
+	}
+	return ""
+}
+
 type DownloadRequest struct {
-	FileHash             string   `protobuf:"bytes,1,opt,name=FileHash,proto3" json:"FileHash,omitempty"`
-	RequestedFragment    uint32   `protobuf:"varint,2,opt,name=RequestedFragment,proto3" json:"RequestedFragment,omitempty"`
+	FileHash          string `protobuf:"bytes,1,opt,name=FileHash,proto3" json:"FileHash,omitempty"`
+	RequestedFragment uint32 `protobuf:"varint,2,opt,name=RequestedFragment,proto3" json:"RequestedFragment,omitempty"`
+	// Compression lists the compressions the client accepts by preference, i.e zstd, gzip
+	Compression          []string `protobuf:"bytes,3,rep,name=Compression,proto3" json:"Compression,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -242,7 +432,7 @@ func (m *DownloadRequest) Reset()         { *m = DownloadRequest{} }
 func (m *DownloadRequest) String() string { return proto.CompactTextString(m) }
 func (*DownloadRequest) ProtoMessage()    {}
 func (*DownloadRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e7fdddb109e6467a, []int{3}
+	return fileDescriptor_e7fdddb109e6467a, []int{6}
 }
 
 func (m *DownloadRequest) XXX_Unmarshal(b []byte) error {
@@ -277,9 +467,20 @@ func (m *DownloadRequest) GetRequestedFragment() uint32 {
 	return 0
 }
 
+func (m *DownloadRequest) GetCompression() []string {
+	if m != nil {
+		return m.Compression
+	}
+	return nil
+}
+
 type DownloadReply struct {
-	FragmentID           uint32   `protobuf:"varint,1,opt,name=FragmentID,proto3" json:"FragmentID,omitempty"`
-	Data                 []byte   `protobuf:"bytes,2,opt,name=Data,proto3" json:"Data,omitempty"`
+	FragmentID uint32 `protobuf:"varint,1,opt,name=FragmentID,proto3" json:"FragmentID,omitempty"`
+	Data       []byte `protobuf:"bytes,2,opt,name=Data,proto3" json:"Data,omitempty"`
+	// Proof is the inclusion proof of the fragment in the file's merkle tree, ordered from the leaf level up
+	Proof [][]byte `protobuf:"bytes,3,rep,name=Proof,proto3" json:"Proof,omitempty"`
+	// Compression of Data, empty when it isn't compressed
+	Compression          string   `protobuf:"bytes,4,opt,name=Compression,proto3" json:"Compression,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -289,7 +490,7 @@ func (m *DownloadReply) Reset()         { *m = DownloadReply{} }
 func (m *DownloadReply) String() string { return proto.CompactTextString(m) }
 func (*DownloadReply) ProtoMessage()    {}
 func (*DownloadReply) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e7fdddb109e6467a, []int{4}
+	return fileDescriptor_e7fdddb109e6467a, []int{7}
 }
 
 func (m *DownloadReply) XXX_Unmarshal(b []byte) error {
@@ -324,6 +525,151 @@ func (m *DownloadReply) GetData() []byte {
 	return nil
 }
 
+func (m *DownloadReply) GetProof() [][]byte {
+	if m != nil {
+		return m.Proof
+	}
+	return nil
+}
+
+func (m *DownloadReply) GetCompression() string {
+	if m != nil {
+		return m.Compression
+	}
+	return ""
+}
+
+// StreamRequest requests a run of fragments starting at FirstFragment
+type StreamRequest struct {
+	FileHash      string `protobuf:"bytes,1,opt,name=FileHash,proto3" json:"FileHash,omitempty"`
+	FirstFragment uint32 `protobuf:"varint,2,opt,name=FirstFragment,proto3" json:"FirstFragment,omitempty"`
+	FragmentCount uint32 `protobuf:"varint,3,opt,name=FragmentCount,proto3" json:"FragmentCount,omitempty"`
+	// Compression lists the compressions the client accepts by preference, i.e zstd, gzip
+	Compression          []string `protobuf:"bytes,4,rep,name=Compression,proto3" json:"Compression,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StreamRequest) Reset()         { *m = StreamRequest{} }
+func (m *StreamRequest) String() string { return proto.CompactTextString(m) }
+func (*StreamRequest) ProtoMessage()    {}
+func (*StreamRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{8}
+}
+
+func (m *StreamRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StreamRequest.Unmarshal(m, b)
+}
+func (m *StreamRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StreamRequest.Marshal(b, m, deterministic)
+}
+func (m *StreamRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StreamRequest.Merge(m, src)
+}
+func (m *StreamRequest) XXX_Size() int {
+	return xxx_messageInfo_StreamRequest.Size(m)
+}
+func (m *StreamRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StreamRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StreamRequest proto.InternalMessageInfo
+
+func (m *StreamRequest) GetFileHash() string {
+	if m != nil {
+		return m.FileHash
+	}
+	return ""
+}
+
+func (m *StreamRequest) GetFirstFragment() uint32 {
+	if m != nil {
+		return m.FirstFragment
+	}
+	return 0
+}
+
+func (m *StreamRequest) GetFragmentCount() uint32 {
+	if m != nil {
+		return m.FragmentCount
+	}
+	return 0
+}
+
+func (m *StreamRequest) GetCompression() []string {
+	if m != nil {
+		return m.Compression
+	}
+	return nil
+}
+
+// StreamReply is a single frame of a fragment, frames of every fragment are sent in order
+type StreamReply struct {
+	FragmentID uint32 `protobuf:"varint,1,opt,name=FragmentID,proto3" json:"FragmentID,omitempty"`
+	Data       []byte `protobuf:"bytes,2,opt,name=Data,proto3" json:"Data,omitempty"`
+	// Proof is sent with the first frame of every fragment
+	Proof [][]byte `protobuf:"bytes,3,rep,name=Proof,proto3" json:"Proof,omitempty"`
+	// Compression of Data, frames that don't shrink aren't compressed
+	Compression          string   `protobuf:"bytes,4,opt,name=Compression,proto3" json:"Compression,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StreamReply) Reset()         { *m = StreamReply{} }
+func (m *StreamReply) String() string { return proto.CompactTextString(m) }
+func (*StreamReply) ProtoMessage()    {}
+func (*StreamReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{9}
+}
+
+func (m *StreamReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StreamReply.Unmarshal(m, b)
+}
+func (m *StreamReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StreamReply.Marshal(b, m, deterministic)
+}
+func (m *StreamReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StreamReply.Merge(m, src)
+}
+func (m *StreamReply) XXX_Size() int {
+	return xxx_messageInfo_StreamReply.Size(m)
+}
+func (m *StreamReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_StreamReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StreamReply proto.InternalMessageInfo
+
+func (m *StreamReply) GetFragmentID() uint32 {
+	if m != nil {
+		return m.FragmentID
+	}
+	return 0
+}
+
+func (m *StreamReply) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *StreamReply) GetProof() [][]byte {
+	if m != nil {
+		return m.Proof
+	}
+	return nil
+}
+
+func (m *StreamReply) GetCompression() string {
+	if m != nil {
+		return m.Compression
+	}
+	return ""
+}
+
 type FragmentRequest struct {
 	FileHash             string   `protobuf:"bytes,1,opt,name=FileHash,proto3" json:"FileHash,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -335,7 +681,7 @@ func (m *FragmentRequest) Reset()         { *m = FragmentRequest{} }
 func (m *FragmentRequest) String() string { return proto.CompactTextString(m) }
 func (*FragmentRequest) ProtoMessage()    {}
 func (*FragmentRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e7fdddb109e6467a, []int{5}
+	return fileDescriptor_e7fdddb109e6467a, []int{10}
 }
 
 func (m *FragmentRequest) XXX_Unmarshal(b []byte) error {
@@ -364,8 +710,10 @@ func (m *FragmentRequest) GetFileHash() string {
 }
 
 type FragmentReply struct {
-	Exists               bool     `protobuf:"varint,1,opt,name=Exists,proto3" json:"Exists,omitempty"`
-	AvailableFragments   []int32  `protobuf:"varint,2,rep,packed,name=AvailableFragments,proto3" json:"AvailableFragments,omitempty"`
+	Exists             bool    `protobuf:"varint,1,opt,name=Exists,proto3" json:"Exists,omitempty"`
+	AvailableFragments []int32 `protobuf:"varint,2,rep,packed,name=AvailableFragments,proto3" json:"AvailableFragments,omitempty"`
+	// FragmentHashes holds the hash of every fragment ordered by fragment id, empty when no fragments are available
+	FragmentHashes       []string `protobuf:"bytes,3,rep,name=FragmentHashes,proto3" json:"FragmentHashes,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -375,7 +723,7 @@ func (m *FragmentReply) Reset()         { *m = FragmentReply{} }
 func (m *FragmentReply) String() string { return proto.CompactTextString(m) }
 func (*FragmentReply) ProtoMessage()    {}
 func (*FragmentReply) Descriptor() ([]byte, []int) {
-	return fileDescriptor_e7fdddb109e6467a, []int{6}
+	return fileDescriptor_e7fdddb109e6467a, []int{11}
 }
 
 func (m *FragmentReply) XXX_Unmarshal(b []byte) error {
@@ -410,80 +758,710 @@ func (m *FragmentReply) GetAvailableFragments() []int32 {
 	return nil
 }
 
-func init() {
-	proto.RegisterType((*ListRequest)(nil), "rpc.ListRequest")
-	proto.RegisterType((*ListReply)(nil), "rpc.ListReply")
-	proto.RegisterType((*MetaData)(nil), "rpc.MetaData")
-	proto.RegisterType((*DownloadRequest)(nil), "rpc.DownloadRequest")
-	proto.RegisterType((*DownloadReply)(nil), "rpc.DownloadReply")
-	proto.RegisterType((*FragmentRequest)(nil), "rpc.FragmentRequest")
-	proto.RegisterType((*FragmentReply)(nil), "rpc.FragmentReply")
-	proto.RegisterEnum("rpc.Status", Status_name, Status_value)
+func (m *FragmentReply) GetFragmentHashes() []string {
+	if m != nil {
+		return m.FragmentHashes
+	}
+	return nil
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
-
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
+// PeerExchangeRequest asks a node for the peers it knows, only nodes with the pex capability support it
+type PeerExchangeRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
 
-// FileServiceClient is the client API for FileService service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
-type FileServiceClient interface {
-	RemoteList(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListReply, error)
-	RemoteDownload(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (*DownloadReply, error)
-	RemoteFragmentsAvailable(ctx context.Context, in *FragmentRequest, opts ...grpc.CallOption) (*FragmentReply, error)
+func (m *PeerExchangeRequest) Reset()         { *m = PeerExchangeRequest{} }
+func (m *PeerExchangeRequest) String() string { return proto.CompactTextString(m) }
+func (*PeerExchangeRequest) ProtoMessage()    {}
+func (*PeerExchangeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{12}
 }
 
-type fileServiceClient struct {
-	cc *grpc.ClientConn
+func (m *PeerExchangeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PeerExchangeRequest.Unmarshal(m, b)
+}
+func (m *PeerExchangeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PeerExchangeRequest.Marshal(b, m, deterministic)
+}
+func (m *PeerExchangeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PeerExchangeRequest.Merge(m, src)
+}
+func (m *PeerExchangeRequest) XXX_Size() int {
+	return xxx_messageInfo_PeerExchangeRequest.Size(m)
+}
+func (m *PeerExchangeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PeerExchangeRequest.DiscardUnknown(m)
 }
 
-func NewFileServiceClient(cc *grpc.ClientConn) FileServiceClient {
-	return &fileServiceClient{cc}
+var xxx_messageInfo_PeerExchangeRequest proto.InternalMessageInfo
+
+// PeerExchangeReply holds the peers a node discovered recently, most recently first
+type PeerExchangeReply struct {
+	Peers                []*PeerAddress `protobuf:"bytes,1,rep,name=Peers,proto3" json:"Peers,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
 }
 
-func (c *fileServiceClient) RemoteList(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListReply, error) {
-	out := new(ListReply)
-	err := c.cc.Invoke(ctx, "/rpc.FileService/RemoteList", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
+func (m *PeerExchangeReply) Reset()         { *m = PeerExchangeReply{} }
+func (m *PeerExchangeReply) String() string { return proto.CompactTextString(m) }
+func (*PeerExchangeReply) ProtoMessage()    {}
+func (*PeerExchangeReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{13}
 }
 
-func (c *fileServiceClient) RemoteDownload(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (*DownloadReply, error) {
-	out := new(DownloadReply)
-	err := c.cc.Invoke(ctx, "/rpc.FileService/RemoteDownload", in, out, opts...)
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
+func (m *PeerExchangeReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PeerExchangeReply.Unmarshal(m, b)
+}
+func (m *PeerExchangeReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PeerExchangeReply.Marshal(b, m, deterministic)
+}
+func (m *PeerExchangeReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PeerExchangeReply.Merge(m, src)
+}
+func (m *PeerExchangeReply) XXX_Size() int {
+	return xxx_messageInfo_PeerExchangeReply.Size(m)
+}
+func (m *PeerExchangeReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_PeerExchangeReply.DiscardUnknown(m)
 }
 
-func (c *fileServiceClient) RemoteFragmentsAvailable(ctx context.Context, in *FragmentRequest, opts ...grpc.CallOption) (*FragmentReply, error) {
-	out := new(FragmentReply)
-	err := c.cc.Invoke(ctx, "/rpc.FileService/RemoteFragmentsAvailable", in, out, opts...)
-	if err != nil {
-		return nil, err
+var xxx_messageInfo_PeerExchangeReply proto.InternalMessageInfo
+
+func (m *PeerExchangeReply) GetPeers() []*PeerAddress {
+	if m != nil {
+		return m.Peers
 	}
-	return out, nil
+	return nil
 }
 
-// FileServiceServer is the server API for FileService service.
+// PeerAddress is the address a peer serves files on
+type PeerAddress struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=Name,proto3" json:"Name,omitempty"`
+	Addr                 string   `protobuf:"bytes,2,opt,name=Addr,proto3" json:"Addr,omitempty"`
+	Port                 uint32   `protobuf:"varint,3,opt,name=Port,proto3" json:"Port,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PeerAddress) Reset()         { *m = PeerAddress{} }
+func (m *PeerAddress) String() string { return proto.CompactTextString(m) }
+func (*PeerAddress) ProtoMessage()    {}
+func (*PeerAddress) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{14}
+}
+
+func (m *PeerAddress) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PeerAddress.Unmarshal(m, b)
+}
+func (m *PeerAddress) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PeerAddress.Marshal(b, m, deterministic)
+}
+func (m *PeerAddress) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PeerAddress.Merge(m, src)
+}
+func (m *PeerAddress) XXX_Size() int {
+	return xxx_messageInfo_PeerAddress.Size(m)
+}
+func (m *PeerAddress) XXX_DiscardUnknown() {
+	xxx_messageInfo_PeerAddress.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PeerAddress proto.InternalMessageInfo
+
+func (m *PeerAddress) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PeerAddress) GetAddr() string {
+	if m != nil {
+		return m.Addr
+	}
+	return ""
+}
+
+func (m *PeerAddress) GetPort() uint32 {
+	if m != nil {
+		return m.Port
+	}
+	return 0
+}
+
+// DHTContact is a node of the DHT, its ID is a random 32 bytes key and Addr:Port is where it serves requests
+type DHTContact struct {
+	ID                   []byte   `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	Addr                 string   `protobuf:"bytes,3,opt,name=Addr,proto3" json:"Addr,omitempty"`
+	Port                 uint32   `protobuf:"varint,4,opt,name=Port,proto3" json:"Port,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DHTContact) Reset()         { *m = DHTContact{} }
+func (m *DHTContact) String() string { return proto.CompactTextString(m) }
+func (*DHTContact) ProtoMessage()    {}
+func (*DHTContact) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{15}
+}
+
+func (m *DHTContact) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DHTContact.Unmarshal(m, b)
+}
+func (m *DHTContact) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DHTContact.Marshal(b, m, deterministic)
+}
+func (m *DHTContact) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DHTContact.Merge(m, src)
+}
+func (m *DHTContact) XXX_Size() int {
+	return xxx_messageInfo_DHTContact.Size(m)
+}
+func (m *DHTContact) XXX_DiscardUnknown() {
+	xxx_messageInfo_DHTContact.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DHTContact proto.InternalMessageInfo
+
+func (m *DHTContact) GetID() []byte {
+	if m != nil {
+		return m.ID
+	}
+	return nil
+}
+
+func (m *DHTContact) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *DHTContact) GetAddr() string {
+	if m != nil {
+		return m.Addr
+	}
+	return ""
+}
+
+func (m *DHTContact) GetPort() uint32 {
+	if m != nil {
+		return m.Port
+	}
+	return 0
+}
+
+type DHTPingRequest struct {
+	Sender               *DHTContact `protobuf:"bytes,1,opt,name=Sender,proto3" json:"Sender,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *DHTPingRequest) Reset()         { *m = DHTPingRequest{} }
+func (m *DHTPingRequest) String() string { return proto.CompactTextString(m) }
+func (*DHTPingRequest) ProtoMessage()    {}
+func (*DHTPingRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{16}
+}
+
+func (m *DHTPingRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DHTPingRequest.Unmarshal(m, b)
+}
+func (m *DHTPingRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DHTPingRequest.Marshal(b, m, deterministic)
+}
+func (m *DHTPingRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DHTPingRequest.Merge(m, src)
+}
+func (m *DHTPingRequest) XXX_Size() int {
+	return xxx_messageInfo_DHTPingRequest.Size(m)
+}
+func (m *DHTPingRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DHTPingRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DHTPingRequest proto.InternalMessageInfo
+
+func (m *DHTPingRequest) GetSender() *DHTContact {
+	if m != nil {
+		return m.Sender
+	}
+	return nil
+}
+
+// DHTPingReply introduces the node, so nodes known only by their address learn its ID
+type DHTPingReply struct {
+	Node                 *DHTContact `protobuf:"bytes,1,opt,name=Node,proto3" json:"Node,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *DHTPingReply) Reset()         { *m = DHTPingReply{} }
+func (m *DHTPingReply) String() string { return proto.CompactTextString(m) }
+func (*DHTPingReply) ProtoMessage()    {}
+func (*DHTPingReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{17}
+}
+
+func (m *DHTPingReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DHTPingReply.Unmarshal(m, b)
+}
+func (m *DHTPingReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DHTPingReply.Marshal(b, m, deterministic)
+}
+func (m *DHTPingReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DHTPingReply.Merge(m, src)
+}
+func (m *DHTPingReply) XXX_Size() int {
+	return xxx_messageInfo_DHTPingReply.Size(m)
+}
+func (m *DHTPingReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_DHTPingReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DHTPingReply proto.InternalMessageInfo
+
+func (m *DHTPingReply) GetNode() *DHTContact {
+	if m != nil {
+		return m.Node
+	}
+	return nil
+}
+
+// FindNodeRequest asks for the nodes closest to Target that the node knows
+type FindNodeRequest struct {
+	Sender               *DHTContact `protobuf:"bytes,1,opt,name=Sender,proto3" json:"Sender,omitempty"`
+	Target               []byte      `protobuf:"bytes,2,opt,name=Target,proto3" json:"Target,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *FindNodeRequest) Reset()         { *m = FindNodeRequest{} }
+func (m *FindNodeRequest) String() string { return proto.CompactTextString(m) }
+func (*FindNodeRequest) ProtoMessage()    {}
+func (*FindNodeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{18}
+}
+
+func (m *FindNodeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FindNodeRequest.Unmarshal(m, b)
+}
+func (m *FindNodeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FindNodeRequest.Marshal(b, m, deterministic)
+}
+func (m *FindNodeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FindNodeRequest.Merge(m, src)
+}
+func (m *FindNodeRequest) XXX_Size() int {
+	return xxx_messageInfo_FindNodeRequest.Size(m)
+}
+func (m *FindNodeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_FindNodeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FindNodeRequest proto.InternalMessageInfo
+
+func (m *FindNodeRequest) GetSender() *DHTContact {
+	if m != nil {
+		return m.Sender
+	}
+	return nil
+}
+
+func (m *FindNodeRequest) GetTarget() []byte {
+	if m != nil {
+		return m.Target
+	}
+	return nil
+}
+
+type FindNodeReply struct {
+	Closer               []*DHTContact `protobuf:"bytes,1,rep,name=Closer,proto3" json:"Closer,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *FindNodeReply) Reset()         { *m = FindNodeReply{} }
+func (m *FindNodeReply) String() string { return proto.CompactTextString(m) }
+func (*FindNodeReply) ProtoMessage()    {}
+func (*FindNodeReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{19}
+}
+
+func (m *FindNodeReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FindNodeReply.Unmarshal(m, b)
+}
+func (m *FindNodeReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FindNodeReply.Marshal(b, m, deterministic)
+}
+func (m *FindNodeReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FindNodeReply.Merge(m, src)
+}
+func (m *FindNodeReply) XXX_Size() int {
+	return xxx_messageInfo_FindNodeReply.Size(m)
+}
+func (m *FindNodeReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_FindNodeReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FindNodeReply proto.InternalMessageInfo
+
+func (m *FindNodeReply) GetCloser() []*DHTContact {
+	if m != nil {
+		return m.Closer
+	}
+	return nil
+}
+
+// FindProvidersRequest asks for the providers of a key, and the nodes closest to it the node knows
+type FindProvidersRequest struct {
+	Sender               *DHTContact `protobuf:"bytes,1,opt,name=Sender,proto3" json:"Sender,omitempty"`
+	Key                  []byte      `protobuf:"bytes,2,opt,name=Key,proto3" json:"Key,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *FindProvidersRequest) Reset()         { *m = FindProvidersRequest{} }
+func (m *FindProvidersRequest) String() string { return proto.CompactTextString(m) }
+func (*FindProvidersRequest) ProtoMessage()    {}
+func (*FindProvidersRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{20}
+}
+
+func (m *FindProvidersRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FindProvidersRequest.Unmarshal(m, b)
+}
+func (m *FindProvidersRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FindProvidersRequest.Marshal(b, m, deterministic)
+}
+func (m *FindProvidersRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FindProvidersRequest.Merge(m, src)
+}
+func (m *FindProvidersRequest) XXX_Size() int {
+	return xxx_messageInfo_FindProvidersRequest.Size(m)
+}
+func (m *FindProvidersRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_FindProvidersRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FindProvidersRequest proto.InternalMessageInfo
+
+func (m *FindProvidersRequest) GetSender() *DHTContact {
+	if m != nil {
+		return m.Sender
+	}
+	return nil
+}
+
+func (m *FindProvidersRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+type FindProvidersReply struct {
+	Providers            []*DHTContact `protobuf:"bytes,1,rep,name=Providers,proto3" json:"Providers,omitempty"`
+	Closer               []*DHTContact `protobuf:"bytes,2,rep,name=Closer,proto3" json:"Closer,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *FindProvidersReply) Reset()         { *m = FindProvidersReply{} }
+func (m *FindProvidersReply) String() string { return proto.CompactTextString(m) }
+func (*FindProvidersReply) ProtoMessage()    {}
+func (*FindProvidersReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{21}
+}
+
+func (m *FindProvidersReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FindProvidersReply.Unmarshal(m, b)
+}
+func (m *FindProvidersReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FindProvidersReply.Marshal(b, m, deterministic)
+}
+func (m *FindProvidersReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FindProvidersReply.Merge(m, src)
+}
+func (m *FindProvidersReply) XXX_Size() int {
+	return xxx_messageInfo_FindProvidersReply.Size(m)
+}
+func (m *FindProvidersReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_FindProvidersReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FindProvidersReply proto.InternalMessageInfo
+
+func (m *FindProvidersReply) GetProviders() []*DHTContact {
+	if m != nil {
+		return m.Providers
+	}
+	return nil
+}
+
+func (m *FindProvidersReply) GetCloser() []*DHTContact {
+	if m != nil {
+		return m.Closer
+	}
+	return nil
+}
+
+// AddProviderRequest stores a provider record of a key, the sender provides it
+type AddProviderRequest struct {
+	Sender               *DHTContact `protobuf:"bytes,1,opt,name=Sender,proto3" json:"Sender,omitempty"`
+	Key                  []byte      `protobuf:"bytes,2,opt,name=Key,proto3" json:"Key,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *AddProviderRequest) Reset()         { *m = AddProviderRequest{} }
+func (m *AddProviderRequest) String() string { return proto.CompactTextString(m) }
+func (*AddProviderRequest) ProtoMessage()    {}
+func (*AddProviderRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{22}
+}
+
+func (m *AddProviderRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddProviderRequest.Unmarshal(m, b)
+}
+func (m *AddProviderRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddProviderRequest.Marshal(b, m, deterministic)
+}
+func (m *AddProviderRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddProviderRequest.Merge(m, src)
+}
+func (m *AddProviderRequest) XXX_Size() int {
+	return xxx_messageInfo_AddProviderRequest.Size(m)
+}
+func (m *AddProviderRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddProviderRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddProviderRequest proto.InternalMessageInfo
+
+func (m *AddProviderRequest) GetSender() *DHTContact {
+	if m != nil {
+		return m.Sender
+	}
+	return nil
+}
+
+func (m *AddProviderRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+type AddProviderReply struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddProviderReply) Reset()         { *m = AddProviderReply{} }
+func (m *AddProviderReply) String() string { return proto.CompactTextString(m) }
+func (*AddProviderReply) ProtoMessage()    {}
+func (*AddProviderReply) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e7fdddb109e6467a, []int{23}
+}
+
+func (m *AddProviderReply) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddProviderReply.Unmarshal(m, b)
+}
+func (m *AddProviderReply) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddProviderReply.Marshal(b, m, deterministic)
+}
+func (m *AddProviderReply) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddProviderReply.Merge(m, src)
+}
+func (m *AddProviderReply) XXX_Size() int {
+	return xxx_messageInfo_AddProviderReply.Size(m)
+}
+func (m *AddProviderReply) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddProviderReply.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddProviderReply proto.InternalMessageInfo
+
+func init() {
+	proto.RegisterType((*HelloRequest)(nil), "rpc.HelloRequest")
+	proto.RegisterType((*HelloReply)(nil), "rpc.HelloReply")
+	proto.RegisterType((*NodeInfo)(nil), "rpc.NodeInfo")
+	proto.RegisterType((*ListRequest)(nil), "rpc.ListRequest")
+	proto.RegisterType((*ListReply)(nil), "rpc.ListReply")
+	proto.RegisterType((*MetaData)(nil), "rpc.MetaData")
+	proto.RegisterType((*DownloadRequest)(nil), "rpc.DownloadRequest")
+	proto.RegisterType((*DownloadReply)(nil), "rpc.DownloadReply")
+	proto.RegisterType((*StreamRequest)(nil), "rpc.StreamRequest")
+	proto.RegisterType((*StreamReply)(nil), "rpc.StreamReply")
+	proto.RegisterType((*FragmentRequest)(nil), "rpc.FragmentRequest")
+	proto.RegisterType((*FragmentReply)(nil), "rpc.FragmentReply")
+	proto.RegisterType((*PeerExchangeRequest)(nil), "rpc.PeerExchangeRequest")
+	proto.RegisterType((*PeerExchangeReply)(nil), "rpc.PeerExchangeReply")
+	proto.RegisterType((*PeerAddress)(nil), "rpc.PeerAddress")
+	proto.RegisterType((*DHTContact)(nil), "rpc.DHTContact")
+	proto.RegisterType((*DHTPingRequest)(nil), "rpc.DHTPingRequest")
+	proto.RegisterType((*DHTPingReply)(nil), "rpc.DHTPingReply")
+	proto.RegisterType((*FindNodeRequest)(nil), "rpc.FindNodeRequest")
+	proto.RegisterType((*FindNodeReply)(nil), "rpc.FindNodeReply")
+	proto.RegisterType((*FindProvidersRequest)(nil), "rpc.FindProvidersRequest")
+	proto.RegisterType((*FindProvidersReply)(nil), "rpc.FindProvidersReply")
+	proto.RegisterType((*AddProviderRequest)(nil), "rpc.AddProviderRequest")
+	proto.RegisterType((*AddProviderReply)(nil), "rpc.AddProviderReply")
+	proto.RegisterEnum("rpc.Status", Status_name, Status_value)
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// FileServiceClient is the client API for FileService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type FileServiceClient interface {
+	Hello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloReply, error)
+	RemoteList(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListReply, error)
+	RemoteDownload(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (*DownloadReply, error)
+	RemoteFragmentsAvailable(ctx context.Context, in *FragmentRequest, opts ...grpc.CallOption) (*FragmentReply, error)
+	RemoteStream(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (FileService_RemoteStreamClient, error)
+	PeerExchange(ctx context.Context, in *PeerExchangeRequest, opts ...grpc.CallOption) (*PeerExchangeReply, error)
+}
+
+type fileServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewFileServiceClient(cc *grpc.ClientConn) FileServiceClient {
+	return &fileServiceClient{cc}
+}
+
+func (c *fileServiceClient) Hello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloReply, error) {
+	out := new(HelloReply)
+	err := c.cc.Invoke(ctx, "/rpc.FileService/Hello", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) RemoteList(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListReply, error) {
+	out := new(ListReply)
+	err := c.cc.Invoke(ctx, "/rpc.FileService/RemoteList", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) RemoteDownload(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (*DownloadReply, error) {
+	out := new(DownloadReply)
+	err := c.cc.Invoke(ctx, "/rpc.FileService/RemoteDownload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) RemoteFragmentsAvailable(ctx context.Context, in *FragmentRequest, opts ...grpc.CallOption) (*FragmentReply, error) {
+	out := new(FragmentReply)
+	err := c.cc.Invoke(ctx, "/rpc.FileService/RemoteFragmentsAvailable", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) RemoteStream(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (FileService_RemoteStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_FileService_serviceDesc.Streams[0], "/rpc.FileService/RemoteStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileServiceRemoteStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileService_RemoteStreamClient interface {
+	Recv() (*StreamReply, error)
+	grpc.ClientStream
+}
+
+type fileServiceRemoteStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceRemoteStreamClient) Recv() (*StreamReply, error) {
+	m := new(StreamReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) PeerExchange(ctx context.Context, in *PeerExchangeRequest, opts ...grpc.CallOption) (*PeerExchangeReply, error) {
+	out := new(PeerExchangeReply)
+	err := c.cc.Invoke(ctx, "/rpc.FileService/PeerExchange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FileServiceServer is the server API for FileService service.
 type FileServiceServer interface {
+	Hello(context.Context, *HelloRequest) (*HelloReply, error)
 	RemoteList(context.Context, *ListRequest) (*ListReply, error)
 	RemoteDownload(context.Context, *DownloadRequest) (*DownloadReply, error)
 	RemoteFragmentsAvailable(context.Context, *FragmentRequest) (*FragmentReply, error)
+	RemoteStream(*StreamRequest, FileService_RemoteStreamServer) error
+	PeerExchange(context.Context, *PeerExchangeRequest) (*PeerExchangeReply, error)
 }
 
 func RegisterFileServiceServer(s *grpc.Server, srv FileServiceServer) {
 	s.RegisterService(&_FileService_serviceDesc, srv)
 }
 
+func _FileService_Hello_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HelloRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).Hello(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.FileService/Hello",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).Hello(ctx, req.(*HelloRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _FileService_RemoteList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ListRequest)
 	if err := dec(in); err != nil {
@@ -538,10 +1516,53 @@ func _FileService_RemoteFragmentsAvailable_Handler(srv interface{}, ctx context.
 	return interceptor(ctx, in, info, handler)
 }
 
+func _FileService_RemoteStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileServiceServer).RemoteStream(m, &fileServiceRemoteStreamServer{stream})
+}
+
+type FileService_RemoteStreamServer interface {
+	Send(*StreamReply) error
+	grpc.ServerStream
+}
+
+type fileServiceRemoteStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileServiceRemoteStreamServer) Send(m *StreamReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileService_PeerExchange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PeerExchangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).PeerExchange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.FileService/PeerExchange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).PeerExchange(ctx, req.(*PeerExchangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _FileService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "rpc.FileService",
 	HandlerType: (*FileServiceServer)(nil),
 	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Hello",
+			Handler:    _FileService_Hello_Handler,
+		},
 		{
 			MethodName: "RemoteList",
 			Handler:    _FileService_RemoteList_Handler,
@@ -554,6 +1575,187 @@ var _FileService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "RemoteFragmentsAvailable",
 			Handler:    _FileService_RemoteFragmentsAvailable_Handler,
 		},
+		{
+			MethodName: "PeerExchange",
+			Handler:    _FileService_PeerExchange_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RemoteStream",
+			Handler:       _FileService_RemoteStream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "p2p.proto",
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// DHTServiceClient is the client API for DHTService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type DHTServiceClient interface {
+	Ping(ctx context.Context, in *DHTPingRequest, opts ...grpc.CallOption) (*DHTPingReply, error)
+	FindNode(ctx context.Context, in *FindNodeRequest, opts ...grpc.CallOption) (*FindNodeReply, error)
+	FindProviders(ctx context.Context, in *FindProvidersRequest, opts ...grpc.CallOption) (*FindProvidersReply, error)
+	AddProvider(ctx context.Context, in *AddProviderRequest, opts ...grpc.CallOption) (*AddProviderReply, error)
+}
+
+type dHTServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewDHTServiceClient(cc *grpc.ClientConn) DHTServiceClient {
+	return &dHTServiceClient{cc}
+}
+
+func (c *dHTServiceClient) Ping(ctx context.Context, in *DHTPingRequest, opts ...grpc.CallOption) (*DHTPingReply, error) {
+	out := new(DHTPingReply)
+	err := c.cc.Invoke(ctx, "/rpc.DHTService/Ping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dHTServiceClient) FindNode(ctx context.Context, in *FindNodeRequest, opts ...grpc.CallOption) (*FindNodeReply, error) {
+	out := new(FindNodeReply)
+	err := c.cc.Invoke(ctx, "/rpc.DHTService/FindNode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dHTServiceClient) FindProviders(ctx context.Context, in *FindProvidersRequest, opts ...grpc.CallOption) (*FindProvidersReply, error) {
+	out := new(FindProvidersReply)
+	err := c.cc.Invoke(ctx, "/rpc.DHTService/FindProviders", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *dHTServiceClient) AddProvider(ctx context.Context, in *AddProviderRequest, opts ...grpc.CallOption) (*AddProviderReply, error) {
+	out := new(AddProviderReply)
+	err := c.cc.Invoke(ctx, "/rpc.DHTService/AddProvider", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DHTServiceServer is the server API for DHTService service.
+type DHTServiceServer interface {
+	Ping(context.Context, *DHTPingRequest) (*DHTPingReply, error)
+	FindNode(context.Context, *FindNodeRequest) (*FindNodeReply, error)
+	FindProviders(context.Context, *FindProvidersRequest) (*FindProvidersReply, error)
+	AddProvider(context.Context, *AddProviderRequest) (*AddProviderReply, error)
+}
+
+func RegisterDHTServiceServer(s *grpc.Server, srv DHTServiceServer) {
+	s.RegisterService(&_DHTService_serviceDesc, srv)
+}
+
+func _DHTService_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DHTPingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DHTServiceServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.DHTService/Ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DHTServiceServer).Ping(ctx, req.(*DHTPingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DHTService_FindNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DHTServiceServer).FindNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.DHTService/FindNode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DHTServiceServer).FindNode(ctx, req.(*FindNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DHTService_FindProviders_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindProvidersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DHTServiceServer).FindProviders(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.DHTService/FindProviders",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DHTServiceServer).FindProviders(ctx, req.(*FindProvidersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DHTService_AddProvider_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddProviderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DHTServiceServer).AddProvider(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.DHTService/AddProvider",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DHTServiceServer).AddProvider(ctx, req.(*AddProviderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _DHTService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "rpc.DHTService",
+	HandlerType: (*DHTServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Ping",
+			Handler:    _DHTService_Ping_Handler,
+		},
+		{
+			MethodName: "FindNode",
+			Handler:    _DHTService_FindNode_Handler,
+		},
+		{
+			MethodName: "FindProviders",
+			Handler:    _DHTService_FindProviders_Handler,
+		},
+		{
+			MethodName: "AddProvider",
+			Handler:    _DHTService_AddProvider_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "p2p.proto",
@@ -562,36 +1764,75 @@ var _FileService_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("p2p.proto", fileDescriptor_e7fdddb109e6467a) }
 
 var fileDescriptor_e7fdddb109e6467a = []byte{
-	// 491 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x53, 0x4d, 0x6f, 0xd3, 0x40,
-	0x10, 0xad, 0xe3, 0x26, 0x4d, 0xc6, 0xf9, 0x62, 0x84, 0x90, 0x15, 0x21, 0x64, 0x6d, 0x39, 0x58,
-	0x08, 0x42, 0x15, 0xae, 0xbd, 0x44, 0x75, 0x42, 0x23, 0x15, 0xb7, 0x5a, 0x0b, 0xe5, 0xc0, 0x69,
-	0x93, 0x6e, 0xa9, 0xa5, 0x4d, 0x6c, 0xec, 0x75, 0xa1, 0x5c, 0xf9, 0x6d, 0xfc, 0x2f, 0xb4, 0x6b,
-	0x6f, 0xbe, 0xa0, 0x12, 0xb7, 0xf1, 0x7b, 0xf3, 0xde, 0xce, 0xbe, 0x59, 0x43, 0x2b, 0x1d, 0xa5,
-	0xc3, 0x34, 0x4b, 0x64, 0x82, 0x76, 0x96, 0x2e, 0xc9, 0x7b, 0x70, 0xae, 0xe2, 0x5c, 0x52, 0xfe,
-	0xad, 0xe0, 0xb9, 0x44, 0x0f, 0x9c, 0xbb, 0x42, 0x88, 0x80, 0x4b, 0x16, 0x8b, 0xdc, 0xb5, 0x3c,
-	0xcb, 0x6f, 0xd2, 0x5d, 0x88, 0x9c, 0x41, 0xab, 0x14, 0xa4, 0xe2, 0x11, 0x4f, 0xa1, 0x7e, 0x17,
-	0x0b, 0xae, 0x1a, 0x6d, 0xdf, 0x19, 0x75, 0x86, 0x59, 0xba, 0x1c, 0x7e, 0xe2, 0x92, 0x05, 0x4c,
-	0x32, 0x5a, 0x72, 0xe4, 0x57, 0x0d, 0x9a, 0x06, 0x43, 0x84, 0xe3, 0x90, 0xad, 0xb8, 0x76, 0x6e,
-	0x51, 0x5d, 0xe3, 0x4b, 0x68, 0xdd, 0x14, 0x0b, 0x11, 0xe7, 0xf7, 0x3c, 0x73, 0x6b, 0x9a, 0xd8,
-	0x02, 0x4a, 0x71, 0xc9, 0xf2, 0x7b, 0xd7, 0x2e, 0x15, 0xaa, 0x56, 0x58, 0x14, 0xff, 0xe4, 0xee,
-	0xb1, 0x67, 0xf9, 0x36, 0xd5, 0x35, 0x12, 0x68, 0x07, 0xc9, 0xf7, 0xb5, 0x48, 0xd8, 0x2d, 0x5b,
-	0x08, 0xee, 0xd6, 0xf5, 0xec, 0x7b, 0x18, 0xbe, 0x86, 0xce, 0x34, 0x63, 0x5f, 0x57, 0x7c, 0x2d,
-	0x2f, 0x92, 0x62, 0x2d, 0xdd, 0x86, 0x67, 0xf9, 0x75, 0xba, 0x0f, 0xe2, 0x10, 0x70, 0xfc, 0xc0,
-	0x62, 0xa1, 0x24, 0x86, 0xc9, 0xdd, 0x13, 0xcf, 0xf6, 0xeb, 0xf4, 0x1f, 0x0c, 0x9e, 0x42, 0x23,
-	0x92, 0x4c, 0x16, 0xb9, 0xdb, 0xf4, 0x2c, 0xbf, 0x3b, 0x72, 0x74, 0x0c, 0x25, 0x44, 0x2b, 0x8a,
-	0x7c, 0x81, 0x9e, 0x19, 0xc5, 0x84, 0x3d, 0x80, 0xe6, 0x34, 0x16, 0x5c, 0xdf, 0xae, 0xcc, 0x63,
-	0xf3, 0x8d, 0x6f, 0xe1, 0x59, 0xd5, 0xc6, 0x6f, 0xcd, 0x49, 0x3a, 0x9b, 0x0e, 0xfd, 0x9b, 0x20,
-	0x17, 0xd0, 0xd9, 0x9a, 0xab, 0xc5, 0xbc, 0x02, 0x30, 0xe4, 0x2c, 0xd0, 0xe6, 0x1d, 0xba, 0x83,
-	0xa8, 0x00, 0xd5, 0x3a, 0xb4, 0x63, 0x9b, 0xea, 0x9a, 0xbc, 0x83, 0x9e, 0xe9, 0xf8, 0x8f, 0x09,
-	0xc9, 0x7c, 0x9b, 0x65, 0x79, 0xe6, 0x0b, 0x68, 0x4c, 0x7e, 0xc4, 0xb9, 0x34, 0xcf, 0xa6, 0xfa,
-	0x7a, 0x22, 0xce, 0xda, 0x53, 0x71, 0xbe, 0x39, 0x37, 0x71, 0xe2, 0x09, 0xd8, 0xe1, 0x64, 0xde,
-	0x3f, 0x42, 0x80, 0xc6, 0xcd, 0xf8, 0x73, 0x34, 0x09, 0xfa, 0x16, 0xf6, 0xc0, 0x09, 0xae, 0xe7,
-	0xe1, 0xd5, 0xf5, 0x38, 0x98, 0x85, 0x1f, 0xfb, 0x35, 0x6c, 0x43, 0x73, 0x3a, 0x0b, 0x67, 0xd1,
-	0xe5, 0x24, 0xe8, 0xdb, 0xa3, 0xdf, 0x16, 0x38, 0x6a, 0xc6, 0x88, 0x67, 0x0f, 0xf1, 0x92, 0xe3,
-	0x19, 0x00, 0xe5, 0xab, 0x44, 0x72, 0xf5, 0x6a, 0xb1, 0xaf, 0x57, 0xb3, 0xf3, 0xe2, 0x07, 0xdd,
-	0x1d, 0x24, 0x15, 0x8f, 0xe4, 0x08, 0xcf, 0xa1, 0x5b, 0x2a, 0x4c, 0xa4, 0xf8, 0x5c, 0xf7, 0x1c,
-	0xac, 0x6f, 0x80, 0x07, 0x68, 0xa9, 0x9e, 0x82, 0x5b, 0xaa, 0x37, 0x17, 0xda, 0x5c, 0xb1, 0xf2,
-	0x39, 0x08, 0xb9, 0xf2, 0xd9, 0xcb, 0x92, 0x1c, 0x2d, 0x1a, 0xfa, 0x27, 0xfd, 0xf0, 0x27, 0x00,
-	0x00, 0xff, 0xff, 0x86, 0x4f, 0x71, 0x04, 0xb1, 0x03, 0x00, 0x00,
+	// 1106 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x56, 0xed, 0x6e, 0xdb, 0xd4,
+	0x1b, 0x5f, 0xe2, 0x24, 0x4d, 0x1e, 0xe7, 0xad, 0xcf, 0xba, 0xfe, 0xf3, 0xb7, 0xd0, 0x14, 0x3c,
+	0xb4, 0x45, 0x88, 0x76, 0xa5, 0x93, 0xd0, 0x10, 0x48, 0xa8, 0xc4, 0x29, 0xad, 0xd8, 0xd2, 0x70,
+	0xd2, 0x6d, 0x7c, 0x75, 0x93, 0xd3, 0xd6, 0xc2, 0xb1, 0x8d, 0x7d, 0xd2, 0x2d, 0x20, 0xc4, 0x75,
+	0x70, 0x4b, 0xdc, 0x09, 0x5f, 0xb9, 0x00, 0x84, 0xce, 0x5b, 0x62, 0x3b, 0x29, 0xea, 0x24, 0xc4,
+	0xb7, 0x73, 0x7e, 0xcf, 0xfb, 0xeb, 0x39, 0x50, 0x8b, 0x0e, 0xa3, 0xfd, 0x28, 0x0e, 0x59, 0x88,
+	0x46, 0x1c, 0x4d, 0xec, 0x4f, 0xa1, 0x7e, 0x42, 0x7d, 0x3f, 0x24, 0xf4, 0xc7, 0x39, 0x4d, 0x18,
+	0x7e, 0x08, 0xa5, 0x61, 0x38, 0xa5, 0x9d, 0x42, 0xb7, 0xd0, 0x33, 0x0f, 0x1b, 0xfb, 0x71, 0x34,
+	0xd9, 0xe7, 0xc0, 0x69, 0x70, 0x19, 0x12, 0x41, 0xb2, 0x9f, 0x02, 0x28, 0x91, 0xc8, 0x5f, 0xdc,
+	0x45, 0xe0, 0xf7, 0x02, 0x54, 0x35, 0x84, 0xbb, 0x50, 0x11, 0x67, 0x47, 0x48, 0xd4, 0x88, 0xba,
+	0x61, 0x0f, 0x5a, 0x23, 0xee, 0xd6, 0x24, 0xf4, 0x5f, 0xd3, 0x38, 0xf1, 0xc2, 0xa0, 0x53, 0xec,
+	0x16, 0x7a, 0x0d, 0x92, 0x87, 0x71, 0x1f, 0xf0, 0xa5, 0x17, 0xe4, 0x99, 0x0d, 0xc1, 0xbc, 0x81,
+	0xc2, 0x35, 0x8f, 0xc3, 0x4b, 0xf6, 0xd6, 0x8d, 0xa9, 0x66, 0x2e, 0x09, 0xd3, 0x79, 0x18, 0x6d,
+	0xa8, 0xf7, 0xdd, 0xc8, 0xbd, 0xf0, 0x7c, 0x8f, 0x79, 0x34, 0xe9, 0x94, 0xbb, 0x46, 0xaf, 0x46,
+	0x32, 0x98, 0xfd, 0x14, 0xcc, 0x17, 0x5e, 0xc2, 0x74, 0xbe, 0xba, 0x60, 0x5e, 0xce, 0x7d, 0xdf,
+	0xa1, 0xcc, 0xf5, 0xfc, 0x44, 0xc4, 0x54, 0x25, 0x69, 0xc8, 0x3e, 0x80, 0x9a, 0x14, 0xe0, 0xd9,
+	0x7a, 0x04, 0xe5, 0x4b, 0xcf, 0xa7, 0x9c, 0xd1, 0x58, 0xa6, 0xeb, 0x25, 0x65, 0xae, 0xe3, 0x32,
+	0x97, 0x48, 0x9a, 0xfd, 0x47, 0x11, 0xaa, 0x1a, 0x43, 0x84, 0xd2, 0xd0, 0x9d, 0x51, 0x95, 0x2d,
+	0x71, 0xc6, 0x0f, 0xa0, 0x36, 0x9a, 0x5f, 0xf8, 0x5e, 0x72, 0x4d, 0x63, 0x91, 0xa5, 0x1a, 0x59,
+	0x01, 0x5c, 0xe2, 0xc4, 0x4d, 0xae, 0x45, 0x46, 0x6a, 0x44, 0x9c, 0x39, 0x36, 0xf6, 0x7e, 0xa2,
+	0x22, 0x70, 0x83, 0x88, 0x33, 0x8f, 0xd6, 0x09, 0xdf, 0x06, 0x7e, 0xe8, 0x4e, 0xdd, 0x0b, 0x9f,
+	0x76, 0xca, 0xc2, 0xf7, 0x0c, 0x86, 0x1f, 0x41, 0xe3, 0x38, 0x76, 0xaf, 0x66, 0x34, 0x60, 0xfd,
+	0x70, 0x1e, 0xb0, 0x4e, 0xa5, 0x5b, 0xe8, 0x95, 0x49, 0x16, 0xe4, 0x15, 0x39, 0xba, 0x71, 0x3d,
+	0x9f, 0x8b, 0x68, 0x4a, 0xd2, 0xd9, 0xea, 0x1a, 0xbd, 0x32, 0xd9, 0x40, 0xc1, 0x47, 0x50, 0x19,
+	0x33, 0x97, 0xcd, 0x93, 0x4e, 0xb5, 0x5b, 0xe8, 0x35, 0x0f, 0x4d, 0x91, 0x06, 0x09, 0x11, 0x45,
+	0xc2, 0xc7, 0xd0, 0xd4, 0x12, 0x3c, 0x04, 0x9a, 0x74, 0x6a, 0xa2, 0x1c, 0x39, 0x94, 0x27, 0xa3,
+	0x7f, 0x3d, 0x0f, 0x7e, 0x10, 0xf1, 0x81, 0x88, 0x6f, 0x05, 0xe0, 0x43, 0x80, 0x41, 0x30, 0x89,
+	0x17, 0x11, 0xe3, 0x75, 0x37, 0x45, 0x4a, 0x52, 0x88, 0xfd, 0x0b, 0xb4, 0x74, 0xc0, 0xba, 0xa4,
+	0x16, 0x54, 0x8f, 0x3d, 0x9f, 0x8a, 0x1c, 0xca, 0xac, 0x2f, 0xef, 0xf8, 0x09, 0x6c, 0x2b, 0x36,
+	0x3a, 0xd5, 0x7e, 0xa8, 0x3e, 0x5d, 0x27, 0xf0, 0xe6, 0xe8, 0x87, 0xb3, 0x28, 0xa6, 0x89, 0x6a,
+	0x51, 0xee, 0x7f, 0x1a, 0xb2, 0x7f, 0x86, 0xc6, 0xca, 0x3c, 0x6f, 0x90, 0x87, 0x00, 0x5a, 0x5c,
+	0x8d, 0x48, 0x83, 0xa4, 0x10, 0x5e, 0x48, 0xde, 0x16, 0xc2, 0x66, 0x9d, 0x88, 0x33, 0xee, 0x40,
+	0x79, 0x14, 0x87, 0xe1, 0xa5, 0x30, 0x50, 0x27, 0xf2, 0x92, 0x37, 0x2e, 0x5b, 0x3e, 0x63, 0xfc,
+	0xb7, 0x02, 0x34, 0xc6, 0x2c, 0xa6, 0xee, 0xec, 0x2e, 0xa1, 0xf3, 0x56, 0xf0, 0xe2, 0x84, 0xe5,
+	0xc2, 0xce, 0x82, 0xeb, 0x0d, 0x63, 0x28, 0xae, 0x34, 0xb8, 0xee, 0xdb, 0x5a, 0x62, 0x16, 0x60,
+	0x6a, 0xd7, 0xfe, 0xeb, 0xb4, 0xec, 0x41, 0x4b, 0x6b, 0xbe, 0x43, 0x5e, 0xec, 0x5f, 0x57, 0x11,
+	0x4b, 0x5f, 0x77, 0xa1, 0x32, 0x78, 0xe7, 0x25, 0x4c, 0x6f, 0x03, 0x75, 0xbb, 0x65, 0x4a, 0x8a,
+	0xb7, 0x4e, 0xc9, 0xfa, 0x00, 0x18, 0x9b, 0x06, 0xc0, 0x7e, 0x00, 0xf7, 0x47, 0x94, 0xc6, 0x83,
+	0x77, 0x93, 0x6b, 0x37, 0xb8, 0xa2, 0xca, 0x67, 0xfb, 0x0b, 0xd8, 0xce, 0xc2, 0xdc, 0xb7, 0xc7,
+	0x50, 0xe6, 0xa0, 0xde, 0x3f, 0x6d, 0x31, 0x78, 0x1c, 0x39, 0x9a, 0x4e, 0x79, 0xfc, 0x44, 0x92,
+	0xed, 0x53, 0x30, 0x53, 0xe8, 0xc6, 0x25, 0x84, 0x50, 0xe2, 0x64, 0xb5, 0x7f, 0xc4, 0x99, 0x63,
+	0xa3, 0x30, 0xd6, 0x45, 0x17, 0x67, 0xfb, 0x7b, 0x00, 0xe7, 0xe4, 0xbc, 0x1f, 0x06, 0xcc, 0x9d,
+	0x30, 0x6c, 0x42, 0x51, 0x15, 0xb0, 0x4e, 0x8a, 0xb2, 0x70, 0x42, 0x73, 0x71, 0x83, 0x66, 0x63,
+	0x83, 0xe6, 0x52, 0x4a, 0xf3, 0xe7, 0xd0, 0x74, 0x4e, 0xce, 0x47, 0x5e, 0x70, 0xa5, 0xeb, 0xf4,
+	0x04, 0x2a, 0x63, 0x1a, 0x4c, 0x69, 0xac, 0x9e, 0xa3, 0x96, 0x88, 0x6f, 0x65, 0x9e, 0x28, 0xb2,
+	0xfd, 0x0c, 0xea, 0x4b, 0x51, 0xb9, 0x97, 0xd3, 0xaf, 0xd8, 0x9a, 0x98, 0x20, 0xda, 0x04, 0x5a,
+	0xc7, 0x5e, 0x30, 0xe5, 0xe7, 0xf7, 0x35, 0xc8, 0x9b, 0xe2, 0xdc, 0x8d, 0xaf, 0x28, 0x53, 0x2d,
+	0xaa, 0x6e, 0xf6, 0x73, 0x68, 0xac, 0x74, 0x72, 0x4f, 0x9e, 0x40, 0xa5, 0xef, 0x87, 0x09, 0x8d,
+	0x55, 0x89, 0xd6, 0x35, 0x4a, 0xb2, 0xfd, 0x1d, 0xec, 0x70, 0xc9, 0x51, 0x1c, 0xde, 0x78, 0x53,
+	0x1a, 0x27, 0xef, 0xed, 0x52, 0x1b, 0x8c, 0x6f, 0xe9, 0x42, 0xf9, 0xc3, 0x8f, 0xb6, 0x0f, 0x98,
+	0x53, 0xc9, 0x3d, 0xda, 0x83, 0xda, 0x12, 0xb9, 0xcd, 0xa9, 0x15, 0x47, 0x2a, 0x80, 0xe2, 0x3f,
+	0x07, 0x70, 0x06, 0x78, 0x34, 0x5d, 0x1a, 0xfb, 0x17, 0xdc, 0x47, 0x68, 0x67, 0x14, 0x46, 0xfe,
+	0xe2, 0xe3, 0xd7, 0xfa, 0xa9, 0xc1, 0x2d, 0x30, 0x86, 0x83, 0x37, 0xed, 0x7b, 0x08, 0x50, 0x19,
+	0x1d, 0xbd, 0x1a, 0x0f, 0x9c, 0x76, 0x01, 0x5b, 0x60, 0x3a, 0x67, 0x6f, 0x86, 0x2f, 0xce, 0x8e,
+	0x9c, 0xd3, 0xe1, 0x37, 0xed, 0x22, 0xd6, 0xa1, 0x7a, 0x7c, 0x3a, 0x3c, 0x1d, 0x9f, 0x0c, 0x9c,
+	0xb6, 0x81, 0x26, 0x6c, 0x8d, 0x07, 0x03, 0x41, 0x2a, 0xf1, 0x4b, 0xff, 0x8c, 0x90, 0x57, 0xa3,
+	0xf3, 0x76, 0xf9, 0xf0, 0xcf, 0x22, 0x98, 0x7c, 0x05, 0x8c, 0x69, 0x7c, 0xe3, 0x4d, 0x28, 0xee,
+	0x41, 0x59, 0x7c, 0x8a, 0x70, 0x5b, 0xf8, 0x9b, 0xfe, 0x53, 0x59, 0xad, 0x34, 0x14, 0xf9, 0x0b,
+	0xfb, 0x1e, 0x1e, 0x00, 0x10, 0x3a, 0x0b, 0x19, 0xe5, 0x5f, 0x03, 0x94, 0x63, 0x98, 0xfa, 0x56,
+	0x58, 0xcd, 0x14, 0x22, 0x25, 0xbe, 0x84, 0xa6, 0x94, 0xd0, 0xef, 0x05, 0xee, 0xc8, 0xcc, 0x64,
+	0x5f, 0x2f, 0x0b, 0x73, 0xa8, 0x94, 0x3e, 0x86, 0x8e, 0x94, 0x5e, 0xae, 0x97, 0xe5, 0xc2, 0x51,
+	0x7a, 0x72, 0x2b, 0xcf, 0xc2, 0x1c, 0x2a, 0xf5, 0x3c, 0x87, 0xba, 0xd4, 0x23, 0x97, 0x33, 0xa2,
+	0x7a, 0xb9, 0x53, 0x8f, 0x88, 0xd5, 0xce, 0x60, 0x42, 0xee, 0xa0, 0x80, 0x5f, 0x43, 0x3d, 0xbd,
+	0x8e, 0xb0, 0xb3, 0x5c, 0x3d, 0xb9, 0xc5, 0x65, 0xed, 0x6e, 0xa0, 0x08, 0x2d, 0x87, 0x7f, 0x15,
+	0xc4, 0x2e, 0xd1, 0x39, 0x3f, 0x80, 0x12, 0x9f, 0x60, 0xbc, 0xaf, 0x5b, 0x24, 0xb5, 0x0a, 0xac,
+	0xed, 0x2c, 0x28, 0xdd, 0xff, 0x0c, 0xaa, 0x7a, 0xda, 0x74, 0xd8, 0xd9, 0x81, 0xb6, 0x30, 0x87,
+	0x4a, 0xb9, 0x81, 0x9c, 0xd2, 0x55, 0x93, 0xff, 0x7f, 0xc9, 0x96, 0x9f, 0x3f, 0xeb, 0x7f, 0x9b,
+	0x48, 0x52, 0xcd, 0x57, 0x60, 0xa6, 0x1a, 0x14, 0x25, 0xe7, 0xfa, 0x0c, 0x58, 0x0f, 0xd6, 0x09,
+	0x42, 0xc1, 0x45, 0x45, 0xfc, 0xdc, 0x9f, 0xfd, 0x3d, 0x00, 0xa7, 0x7a, 0x06, 0x6b, 0xc6, 0x0b,
+	0x00, 0x00,
 }
@@ -1,11 +1,11 @@
 package rpc
 
 import (
-	"errors"
 	"fileshare/p2p"
 	fmt "fmt"
 	"io"
 	"net"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -13,14 +13,32 @@ import (
 	"github.com/spf13/afero"
 	context "golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
+// FrameSize is the size of the frames fragments are streamed in, keeping messages far below gRPC's message limit
+const FrameSize = 64 * (1 << 10)
+
 // Node is a mini-rpc server that satisifies the p2p.Client interface
 type Node struct {
 	ServiceName string
 	fs          afero.Fs
 	db          *gorm.DB
 	seedPartial bool
+
+	// limits the upload rate to all peers and to every peer, nil when there is no limit
+	limits *p2p.PeerLimiters
+
+	// tls secures connections to the node, connections are insecure when it isn't enabled
+	tls TLSConfig
+	// swarm admits requests of peers holding the pre-shared key of a private swarm, nil when the swarm is public
+	swarm *p2p.SwarmGate
+	// peers are the peers known to the node, sent to peers asking for them. nil when the node knows no peers
+	peers *p2p.PeerBook
+	// dht locates providers of files, the node provides the files it seeds. nil when the DHT isn't served
+	dht *p2p.DHT
 }
 
 // NewNode creates a new Node to serve incoming requests on the network
@@ -30,7 +48,34 @@ func NewNode(name, dbPath string, verbose bool) *Node {
 		log.Fatalf("Failed to create Database. Reason: %s", err)
 		return nil
 	}
-	return &Node{name, afero.NewOsFs(), db, false}
+	return &Node{ServiceName: name, fs: afero.NewOsFs(), db: db}
+}
+
+// SetTLS secures connections to the node with TLS, or mutual TLS to only allow peers with a certificate signed by the CA
+func (r *Node) SetTLS(config TLSConfig) {
+	r.tls = config
+}
+
+// SetSwarmKey makes the node part of a private swarm, only peers holding the key can send requests
+func (r *Node) SetSwarmKey(key string) {
+	if key != "" {
+		r.swarm = p2p.NewSwarmGate(key)
+	}
+}
+
+// SetPeerBook sets the peers known to the node, they are sent to peers asking for them
+func (r *Node) SetPeerBook(book *p2p.PeerBook) {
+	r.peers = book
+}
+
+// SetDHT serves the DHT next to the files, the node provides the files it seeds while seeding
+func (r *Node) SetDHT(dht *p2p.DHT) {
+	r.dht = dht
+}
+
+// SetUploadLimits limits the bytes per second uploaded to all peers, and to every single peer. 0 means no limit
+func (r *Node) SetUploadLimits(upload, peerUpload int64) {
+	r.limits = p2p.NewPeerLimiters(upload, peerUpload)
 }
 
 // ================================================================================================================= //
@@ -48,8 +93,26 @@ func (r *Node) Seed(ctx context.Context, resolver p2p.PeerResolver, addr string,
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	server := grpc.NewServer()
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(r.unaryInterceptor), grpc.StreamInterceptor(r.streamInterceptor)}
+	if r.tls.Enabled() {
+		creds, err := r.tls.ServerCredentials()
+		if err != nil {
+			log.Fatalf("failed to load TLS credentials: %v", err)
+		}
+		log.Infof("TLS enabled, mutual=%t", r.tls.Mutual)
+		opts = append(opts, grpc.Creds(creds))
+	}
+	var restricted int
+	r.db.Model(&p2p.FileAccess{}).Count(&restricted)
+	if restricted > 0 && !r.tls.Mutual {
+		log.Warn("Peers are identified only with mutual TLS, files with access lists won't be available")
+	}
+	server := grpc.NewServer(opts...)
 	RegisterFileServiceServer(server, r)
+	if r.dht != nil {
+		RegisterDHTServiceServer(server, NewDHTServer(r.dht))
+		go r.dht.Run(ctx, r.provided)
+	}
 	// Only listen so other peers will be able to discover this node
 	go resolver.Listen(ctx, addr)
 	// start listening
@@ -62,18 +125,24 @@ func (r *Node) Seed(ctx context.Context, resolver p2p.PeerResolver, addr string,
 // *										gRPC interface implementation										   * //
 // ================================================================================================================= //
 
+// Hello introduces the node to a client, clients speaking an incompatible protocol version are refused
+func (r *Node) Hello(ctx context.Context, request *HelloRequest) (*HelloReply, error) {
+	client := peerInfo(request.GetNode())
+	log.Infof("Received hello from %s at %s", client, requester(ctx))
+	if err := client.Compatible(); err != nil {
+		log.Warnf("Refused %s. Reason: %s", requester(ctx), err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &HelloReply{Node: nodeInfo(p2p.LocalPeerInfo(r.ServiceName))}, nil
+}
+
 // RemoteList satisfies P2PClient's List request
 func (r *Node) RemoteList(ctx context.Context, l *ListRequest) (*ListReply, error) {
 
-	log.Infof("Received list request")
-	ff := p2p.List(r.fs, r.db)
+	log.Infof("Received list request from %s", requester(ctx))
+	ff := p2p.ServedFiles(r.fs, r.db, r.seedPartial, requestPeer(ctx))
 	reply := ListReply{}
 	for _, f := range ff {
-		// Skip finished files (unpublished) and files that aren't seeding or partial unless requested to be allowed
-		if f.Status == p2p.Finished || (f.Status != p2p.Seeding && !r.seedPartial) {
-			log.Warn("Skipped File(%s), status is %s", f.Name, f.Status)
-			continue
-		}
 		var fargments []int32
 		for _, fragment := range f.AvailableFragments {
 			fargments = append(fargments, int32(fragment.FragmentID))
@@ -82,9 +151,13 @@ func (r *Node) RemoteList(ctx context.Context, l *ListRequest) (*ListReply, erro
 			Publisher:          r.ServiceName,
 			Hash:               f.Hash,
 			Size:               f.Size,
+			ChunkSize:          f.ChunkSize,
+			Encryption:         f.Encryption,
 			FragmentCount:      int32(f.FragmentsCount),
 			AvailableFragments: fargments,
 			Status:             Status(f.Status),
+			Downloadable:       f.Status == p2p.Seeding,
+			FragmentHashes:     f.FragmentHashes,
 		}
 		log.Infof("Added file %s (hash=%s, fragments=%d/%d, size=%d, status=%s)",
 			m.Name, f.Hash, len(m.AvailableFragments), m.FragmentCount, m.Size, f.Status)
@@ -95,42 +168,202 @@ func (r *Node) RemoteList(ctx context.Context, l *ListRequest) (*ListReply, erro
 
 // RemoteDownload satisfies P2PClients download request
 func (r *Node) RemoteDownload(ctx context.Context, request *DownloadRequest) (*DownloadReply, error) {
-	log.Infof("Received download request for fragment file(hash=%s, fragment=%d)", request.FileHash, request.RequestedFragment)
-	var fm p2p.FileMetaData
-	if r.db.Where("hash = ?", request.FileHash).First(&fm).RecordNotFound() {
-		return nil, errors.New("File Not found")
-	}
-	// Skip finished files (unpublished) and files that aren't seeding or partial unless requested to be allowed
-	if fm.Status == p2p.Finished || (fm.Status != p2p.Seeding && !r.seedPartial) {
-		log.Warn("Skipped File(%s), status is %s", fm.Name, fm.Status)
-		return nil, errors.New("File not available")
-	}
-	f, err := r.fs.Open(fm.FilePath)
+	log.Infof("Received download request for fragment file(hash=%s, fragment=%d) from %s", request.FileHash,
+		request.RequestedFragment, requester(ctx))
+	fm, f, err := r.openFile(ctx, request.FileHash)
 	if err != nil {
-		log.Errorf("Failed to open file %s. Reason: %s", fm.FilePath, err)
 		return nil, err
 	}
 	defer f.Close()
-	log.Debugf("Opened file %s", fm.FilePath)
-	buffer := make([]byte, p2p.FileChunkSize)
-	n, err := f.ReadAt(buffer, int64(request.RequestedFragment*p2p.FileChunkSize))
-	log.Debugf("Read %d from %d", n, int64(request.RequestedFragment*p2p.FileChunkSize))
+	// Only send the fragment's data, the last fragment is usually smaller than the chunk size
+	buffer := make([]byte, fm.FragmentSize(int(request.RequestedFragment)))
+	n, err := f.ReadAt(buffer, fm.FragmentOffset(int(request.RequestedFragment)))
+	log.Debugf("Read %d from %d", n, fm.FragmentOffset(int(request.RequestedFragment)))
 	if err != nil && err != io.EOF {
 		log.Errorf("Failed to read. Reason: %s", err)
 		return nil, err
 	}
-	return &DownloadReply{FragmentID: request.RequestedFragment, Data: buffer}, nil
+	// Partial seeders may not know all fragment hashes, peers will verify these fragments without a proof
+	proof, err := fm.FragmentProof(int(request.RequestedFragment))
+	if err != nil {
+		log.Debugf("Failed to create fragment proof. Reason: %s", err)
+	}
+	stats := p2p.CompressionStats{Compression: p2p.NegotiateCompression(request.Compression)}
+	start := time.Now()
+	data, compression := p2p.Compress(stats.Compression, buffer[:n])
+	stats.Add(n, len(data), time.Since(start))
+	if err := p2p.WaitAll(ctx, len(data), r.limiters(ctx)...); err != nil {
+		return nil, err
+	}
+	log.Debugf("Sending fragment(%d) of file(hash=%s), %s", request.RequestedFragment, fm.Hash, stats)
+	return &DownloadReply{FragmentID: request.RequestedFragment, Data: data, Proof: proof, Compression: string(compression)}, nil
 }
 
 // RemoteFragmentsAvailable checks if fragment is available in the server
 func (r *Node) RemoteFragmentsAvailable(ctx context.Context, request *FragmentRequest) (*FragmentReply, error) {
-	log.Debug("Fragment requested for file(hash=%s)", request.FileHash)
-	var fragments []p2p.Fragment
-	r.db.Where("hash_id = ?", request.FileHash).Find(&fragments)
-	log.Debugf("Found %d fragments for file(hash=%s) found", len(fragments), request.FileHash)
+	fragments, hashes, err := p2p.ServedFragments(r.db, request.FileHash, r.seedPartial, requestPeer(ctx))
+	if err != nil {
+		return nil, err
+	}
 	var fragmentIDs []int32
-	for _, f := range fragments {
-		fragmentIDs = append(fragmentIDs, int32(f.FragmentID))
+	for _, id := range fragments {
+		fragmentIDs = append(fragmentIDs, int32(id))
+	}
+	return &FragmentReply{AvailableFragments: fragmentIDs, FragmentHashes: hashes}, nil
+}
+
+// RemoteStream sends a run of fragments, every fragment is split into frames so whole fragments are never held in memory
+func (r *Node) RemoteStream(request *StreamRequest, stream FileService_RemoteStreamServer) error {
+	log.Infof("Received stream request for file(hash=%s, fragments=%d-%d) from %s", request.FileHash,
+		request.FirstFragment, request.FirstFragment+request.FragmentCount, requester(stream.Context()))
+	fm, f, err := r.openFile(stream.Context(), request.FileHash)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	limiters := r.limiters(stream.Context())
+	// Every frame is compressed on its own, frames that don't shrink are sent as is
+	stats := p2p.CompressionStats{Compression: p2p.NegotiateCompression(request.Compression)}
+	defer func() {
+		log.Debugf("Sent fragments %d-%d of file(hash=%s), %s", request.FirstFragment,
+			request.FirstFragment+request.FragmentCount, fm.Hash, stats)
+	}()
+	buffer := make([]byte, FrameSize)
+	for id := int(request.FirstFragment); id < int(request.FirstFragment+request.FragmentCount); id++ {
+		if id >= fm.FragmentsCount {
+			return fmt.Errorf("Fragment %d doesn't exist", id)
+		}
+		// Partial seeders may not know all fragment hashes, peers will verify these fragments without a proof
+		proof, err := fm.FragmentProof(id)
+		if err != nil {
+			log.Debugf("Failed to create fragment proof. Reason: %s", err)
+		}
+		offset := fm.FragmentOffset(id)
+		for sent, size := int64(0), fm.FragmentSize(id); sent < size; {
+			frame := buffer
+			if size-sent < int64(len(frame)) {
+				frame = frame[:size-sent]
+			}
+			n, err := f.ReadAt(frame, offset+sent)
+			if err != nil && err != io.EOF {
+				log.Errorf("Failed to read. Reason: %s", err)
+				return err
+			}
+			if n == 0 {
+				return fmt.Errorf("Fragment %d is missing data", id)
+			}
+			start := time.Now()
+			data, compression := p2p.Compress(stats.Compression, buffer[:n])
+			stats.Add(n, len(data), time.Since(start))
+			if err := p2p.WaitAll(stream.Context(), len(data), limiters...); err != nil {
+				return err
+			}
+			reply := &StreamReply{FragmentID: uint32(id), Data: data, Proof: proof, Compression: string(compression)}
+			if err := stream.Send(reply); err != nil {
+				return err
+			}
+			proof = nil
+			sent += int64(n)
+		}
+	}
+	return nil
+}
+
+// PeerExchange sends the peers the node discovered recently, so peers can reach nodes they can't discover
+func (r *Node) PeerExchange(ctx context.Context, request *PeerExchangeRequest) (*PeerExchangeReply, error) {
+	peers := r.peers.Peers(p2p.MaxExchangedPeers)
+	log.Debugf("Sending %d peers to %s", len(peers), requester(ctx))
+	reply := PeerExchangeReply{}
+	for _, peer := range peers {
+		reply.Peers = append(reply.Peers, &PeerAddress{Name: peer.Name, Addr: peer.Addr, Port: uint32(peer.Port)})
 	}
-	return &FragmentReply{AvailableFragments: fragmentIDs}, nil
+	return &reply, nil
+}
+
+// provided returns the hashes of the files the node provides on the DHT, files with access lists aren't provided since
+// peers that aren't allowed can't tell they exist
+func (r *Node) provided() []string {
+	var files []p2p.FileMetaData
+	r.db.Where("status = ?", p2p.Seeding).Find(&files)
+	var hashes []string
+	for _, f := range files {
+		if len(p2p.Access(r.db, f.Hash)) == 0 {
+			hashes = append(hashes, f.Hash)
+		}
+	}
+	return hashes
+}
+
+// TrackerFiles returns the files the node announces to trackers while seeding
+func (r *Node) TrackerFiles() []p2p.TrackerFile {
+	return p2p.TrackerFiles(r.fs, r.db, r.seedPartial)
+}
+
+// nodeInfo converts a PeerInfo to its message
+func nodeInfo(info p2p.PeerInfo) *NodeInfo {
+	return &NodeInfo{NodeID: info.NodeID, ProtocolVersion: uint32(info.ProtocolVersion),
+		MinProtocolVersion: uint32(info.MinProtocolVersion), SoftwareVersion: info.SoftwareVersion, Capabilities: info.Capabilities}
+}
+
+// peerInfo converts a NodeInfo message to a PeerInfo
+func peerInfo(info *NodeInfo) p2p.PeerInfo {
+	return p2p.PeerInfo{NodeID: info.GetNodeID(), ProtocolVersion: int(info.GetProtocolVersion()),
+		MinProtocolVersion: int(info.GetMinProtocolVersion()), SoftwareVersion: info.GetSoftwareVersion(),
+		Capabilities: info.GetCapabilities()}
+}
+
+// limiters returns the upload limiters of the peer sending the request
+func (r *Node) limiters(ctx context.Context) []*p2p.RateLimiter {
+	return r.limits.Limiters(requester(ctx))
+}
+
+// unaryInterceptor rejects requests from peers outside of the swarm, and passes verified identities of peers to the
+// handlers
+func (r *Node) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if r.swarm != nil {
+		if err := checkSwarmKey(ctx, r.swarm, info.FullMethod); err != nil {
+			log.Warnf("Rejected %s from %s. Reason: %s", info.FullMethod, requester(ctx), err)
+			return nil, err
+		}
+	}
+	return handler(withIdentity(ctx), req)
+}
+
+// streamInterceptor is the unaryInterceptor of streams
+func (r *Node) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if r.swarm != nil {
+		if err := checkSwarmKey(ss.Context(), r.swarm, info.FullMethod); err != nil {
+			log.Warnf("Rejected %s from %s. Reason: %s", info.FullMethod, requester(ss.Context()), err)
+			return err
+		}
+	}
+	return handler(srv, identityStream{ss, withIdentity(ss.Context())})
+}
+
+// requester returns the verified identity of the peer sending a request, peers without one are identified by their host
+// since they connect from different ports
+func requester(ctx context.Context) string {
+	if identity, ok := PeerIdentity(ctx); ok {
+		return identity
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// requestPeer returns the peer sending a request, with the identity and groups of its verified certificate
+func requestPeer(ctx context.Context) p2p.Requester {
+	identity, _ := PeerIdentity(ctx)
+	return p2p.Requester{Name: requester(ctx), Identity: identity, Groups: PeerGroups(ctx)}
+}
+
+// openFile opens a file for reading if it is available for download by the peer sending the request
+func (r *Node) openFile(ctx context.Context, hash string) (p2p.FileMetaData, afero.File, error) {
+	return p2p.OpenServedFile(r.fs, r.db, hash, r.seedPartial, requestPeer(ctx))
 }
@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"fileshare/p2p"
+	"time"
+
+	context "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// swarmHeader holds the token proving the swarm key sent with every request, see p2p.SwarmToken
+const swarmHeader = "x-fileshare-swarm"
+
+// swarmUnaryInterceptor sends a proof of the swarm key with every request, tokens are bound to the method called
+func swarmUnaryInterceptor(key string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption) error {
+		return invoker(withSwarmToken(ctx, key, method), method, req, reply, cc, opts...)
+	}
+}
+
+// swarmStreamInterceptor is the swarmUnaryInterceptor of streams
+func swarmStreamInterceptor(key string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer,
+		opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withSwarmToken(ctx, key, method), desc, cc, method, opts...)
+	}
+}
+
+// withSwarmToken adds a token of the swarm key for a call of method to the outgoing metadata
+func withSwarmToken(ctx context.Context, key, method string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, swarmHeader, p2p.SwarmToken(key, method, time.Now()))
+}
+
+// checkSwarmKey checks the request holds a proof of the swarm key for method, which wasn't sent before
+func checkSwarmKey(ctx context.Context, gate *p2p.SwarmGate, method string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(swarmHeader)
+	if len(values) == 0 {
+		return status.Error(codes.PermissionDenied, "Missing swarm key")
+	}
+	if err := gate.Admit(values[0], method, time.Now()); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	context "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// TLSConfig holds the certificates securing connections between peers, when no files are given connections are insecure
+type TLSConfig struct {
+	// CertFile and KeyFile are the certificate of the node, used by seeding nodes and by clients with mutual TLS
+	CertFile, KeyFile string
+	// CAFile verifies certificates of remote peers, the system certificates are used when it is empty
+	CAFile string
+	// Mutual requires both sides to present a certificate
+	Mutual bool
+}
+
+// Enabled checks if connections should use TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.CAFile != "" || c.Mutual
+}
+
+// ServerCredentials creates credentials for a seeding node, with mutual TLS clients must present a certificate signed
+// by the CA
+func (c TLSConfig) ServerCredentials() (credentials.TransportCredentials, error) {
+	config, err := c.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// ClientCredentials creates credentials for connecting to a seeding node, the node's certificate must be issued for
+// its service name
+func (c TLSConfig) ClientCredentials(serverName string) (credentials.TransportCredentials, error) {
+	config, err := c.ClientConfig(serverName)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// ServerConfig creates the TLS configuration of a seeding node, see ServerCredentials
+func (c TLSConfig) ServerConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("TLS requires a certificate and a key")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.Mutual {
+		pool, err := c.certPool()
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientConfig creates the TLS configuration for connecting to a seeding node, see ClientCredentials
+func (c TLSConfig) ClientConfig(serverName string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if c.CAFile != "" {
+		pool, err := c.certPool()
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" || c.Mutual {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// certPool loads the CA certificates
+func (c TLSConfig) certPool() (*x509.CertPool, error) {
+	if c.CAFile == "" {
+		return nil, errors.New("Mutual TLS requires a CA certificate")
+	}
+	pem, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in %s", c.CAFile)
+	}
+	return pool, nil
+}
+
+type identityKey struct{}
+
+// identity of a peer from its verified certificate
+type identity struct {
+	name   string
+	groups []string
+}
+
+// PeerIdentity returns the identity of the peer sending a request, it is only known when the peer presented a verified
+// certificate with mutual TLS
+func PeerIdentity(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(identityKey{}).(identity)
+	return id.name, ok
+}
+
+// PeerGroups returns the groups of the peer sending a request, these are the organizational units of its certificate
+func PeerGroups(ctx context.Context) []string {
+	id, _ := ctx.Value(identityKey{}).(identity)
+	return id.groups
+}
+
+// withIdentity adds the identity from the peer's verified certificate to the context
+func withIdentity(ctx context.Context) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx
+	}
+	name, groups, ok := CertificateIdentity(info.State)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, identityKey{}, identity{name, groups})
+}
+
+// CertificateIdentity returns the name and groups of a peer from its verified certificate, the common name is
+// preferred over the first DNS name and groups are the organizational units
+func CertificateIdentity(state tls.ConnectionState) (string, []string, bool) {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", nil, false
+	}
+	cert := state.VerifiedChains[0][0]
+	name := cert.Subject.CommonName
+	if name == "" && len(cert.DNSNames) > 0 {
+		name = cert.DNSNames[0]
+	}
+	if name == "" {
+		return "", nil, false
+	}
+	return name, cert.Subject.OrganizationalUnit, true
+}
+
+// identityStream overrides the context of a stream, adding the peer's identity
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s identityStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,33 @@
+package p2p_test
+
+import (
+	"context"
+	"fileshare/p2p"
+	"fileshare/p2p/rpc"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test available fragments are replied with the hashes verifying them
+func TestRPCFragmentsAvailable(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := p2p.CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewOsFs()
+	assert.Nil(t, p2p.Publish(fs, db, "file.go", p2p.SHA256, 4096, nil))
+	fm := p2p.List(fs, db)[0]
+	node := rpc.NewNode("node", "test.db", false)
+	reply, err := node.RemoteFragmentsAvailable(context.Background(), &rpc.FragmentRequest{FileHash: fm.Hash})
+	assert.Nil(t, err)
+	assert.Len(t, reply.AvailableFragments, fm.FragmentsCount)
+	assert.Equal(t, []string(fm.FragmentHashes), reply.FragmentHashes)
+	// Fragment hashes aren't sent for files that aren't available
+	assert.Nil(t, db.Model(&fm).Update("status", p2p.Corrupt).Error)
+	reply, err = node.RemoteFragmentsAvailable(context.Background(), &rpc.FragmentRequest{FileHash: fm.Hash})
+	assert.Nil(t, err)
+	assert.Empty(t, reply.AvailableFragments)
+	assert.Empty(t, reply.FragmentHashes)
+}
@@ -0,0 +1,98 @@
+package p2p
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/afero"
+)
+
+// Requester is the peer sending a request to a node. Name identifies it in logs and upload limits, the identity and
+// groups come from its verified certificate and are empty for peers without one
+type Requester struct {
+	Name     string
+	Identity string
+	Groups   []string
+}
+
+// ServedFiles returns the files a node lists to a peer, see OpenServedFile
+func ServedFiles(fs afero.Fs, db *gorm.DB, seedPartial bool, peer Requester) []FileMetaData {
+	var files []FileMetaData
+	for _, f := range List(fs, db) {
+		if !served(f, seedPartial) {
+			log.Warnf("Skipped File(%s), status is %s", f.Name, f.Status)
+			continue
+		}
+		// Files are only listed to peers allowed to download them
+		if !allowed(db, f.Hash, peer) {
+			log.Debugf("Skipped File(%s), %s isn't allowed", f.Name, peer.Name)
+			continue
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// OpenServedFile opens a file for reading if the node serves it to a peer
+func OpenServedFile(fs afero.Fs, db *gorm.DB, hash string, seedPartial bool, peer Requester) (FileMetaData, afero.File, error) {
+	var fm FileMetaData
+	fileHash, err := NormalizeHash(hash)
+	if err != nil {
+		return fm, nil, err
+	}
+	if db.Where("hash = ?", fileHash).First(&fm).RecordNotFound() {
+		return fm, nil, errors.New("File Not found")
+	}
+	if !served(fm, seedPartial) {
+		log.Warnf("Skipped File(%s), status is %s", fm.Name, fm.Status)
+		return fm, nil, errors.New("File not available")
+	}
+	// Peers that aren't allowed can't tell the file exists
+	if !allowed(db, fm.Hash, peer) {
+		log.Warnf("Refused File(%s) to %s, it isn't allowed", fm.Name, peer.Name)
+		return fm, nil, errors.New("File Not found")
+	}
+	f, err := fs.Open(fm.FilePath)
+	if err != nil {
+		log.Errorf("Failed to open file %s. Reason: %s", fm.FilePath, err)
+		return fm, nil, err
+	}
+	log.Debugf("Opened file %s", fm.FilePath)
+	return fm, f, nil
+}
+
+// ServedFragments returns the fragments of a file a node serves to a peer with the hashes of all the fragments of the
+// file, there are none when the file isn't served or the peer isn't allowed to access it. Peers would fail to download
+// the fragments of files that aren't served
+func ServedFragments(db *gorm.DB, hash string, seedPartial bool, peer Requester) ([]int, []string, error) {
+	log.Debugf("Fragment requested for file(hash=%s)", hash)
+	fileHash, err := NormalizeHash(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fm FileMetaData
+	var fragments []Fragment
+	if db.Where("hash = ?", fileHash).First(&fm).RecordNotFound() || !served(fm, seedPartial) || !allowed(db, fileHash, peer) {
+		return []int{}, nil, nil
+	}
+	db.Where("hash_id = ?", fileHash).Find(&fragments)
+	log.Debugf("Found %d fragments for file(hash=%s)", len(fragments), fileHash)
+	ids := []int{}
+	for _, f := range fragments {
+		ids = append(ids, f.FragmentID)
+	}
+	return ids, fm.FragmentHashes, nil
+}
+
+// served checks if a node serves a file to peers. Finished files are unpublished, corrupt files and files that aren't
+// seeding aren't served unless partial files are
+func served(fm FileMetaData, seedPartial bool) bool {
+	return fm.Status != Finished && fm.Status != Corrupt && (fm.Status == Seeding || seedPartial)
+}
+
+// allowed checks if a peer is allowed to access a file
+func allowed(db *gorm.DB, fileHash string, peer Requester) bool {
+	return Allowed(Access(db, fileHash), peer.Identity, peer.Groups)
+}
@@ -0,0 +1,95 @@
+package p2p
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test files with access lists are only listed, opened and described to the peers and groups they allow
+func TestServedFiles(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewOsFs()
+	assert.Nil(t, Publish(fs, db, "file.go", SHA256, 4096, nil))
+	assert.Nil(t, Publish(fs, db, "access.go", SHA256, 0, []string{"hr-laptop", GroupPrefix + "finance"}))
+	var restricted FileMetaData
+	for _, f := range List(fs, db) {
+		if f.Name == "access.go" {
+			restricted = f
+		}
+	}
+	names := func(peer Requester) []string {
+		var names []string
+		for _, f := range ServedFiles(fs, db, false, peer) {
+			names = append(names, f.Name)
+		}
+		return names
+	}
+	anonymous := Requester{Name: "10.0.0.7"}
+	allowed := []Requester{{Name: "hr-laptop", Identity: "hr-laptop"},
+		{Name: "accountant", Identity: "accountant", Groups: []string{"finance"}}}
+	assert.Equal(t, []string{"file.go"}, names(anonymous))
+	_, _, err = OpenServedFile(fs, db, restricted.Hash, false, anonymous)
+	assert.NotNil(t, err)
+	fragments, hashes, err := ServedFragments(db, restricted.Hash, false, anonymous)
+	assert.Nil(t, err)
+	assert.Empty(t, fragments)
+	assert.Empty(t, hashes)
+	for _, peer := range allowed {
+		assert.ElementsMatch(t, []string{"file.go", "access.go"}, names(peer))
+		fm, f, err := OpenServedFile(fs, db, restricted.Hash, false, peer)
+		assert.Nil(t, err)
+		assert.Equal(t, restricted.Hash, fm.Hash)
+		f.Close()
+		fragments, hashes, err = ServedFragments(db, restricted.Hash, false, peer)
+		assert.Nil(t, err)
+		assert.Equal(t, []int{0}, fragments)
+		assert.Equal(t, []string(restricted.FragmentHashes), hashes)
+	}
+	_, _, err = ServedFragments(db, "not-a-hash", false, anonymous)
+	assert.NotNil(t, err)
+}
+
+// Test only the fragments of files that are served are available, peers would fail to download the others
+func TestServedFragments(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewOsFs()
+	assert.Nil(t, Publish(fs, db, "file.go", SHA256, 4096, nil))
+	fm := List(fs, db)[0]
+	peer := Requester{Name: "10.0.0.7"}
+	for _, c := range []struct {
+		status      Status
+		seedPartial bool
+		available   bool
+	}{
+		{Seeding, false, true},
+		{Corrupt, false, false},
+		{Corrupt, true, false},
+		{Finished, true, false},
+		{Downloading, false, false},
+		{Downloading, true, true},
+		{Paused, true, true},
+	} {
+		assert.Nil(t, db.Model(&fm).Update("status", c.status).Error)
+		fragments, hashes, err := ServedFragments(db, fm.Hash, c.seedPartial, peer)
+		assert.Nil(t, err)
+		if c.available {
+			assert.Len(t, fragments, fm.FragmentsCount, "%s", c.status)
+			assert.Equal(t, []string(fm.FragmentHashes), hashes, "%s", c.status)
+		} else {
+			assert.Empty(t, fragments, "%s", c.status)
+			assert.Empty(t, hashes, "%s", c.status)
+		}
+	}
+	fragments, hashes, err := ServedFragments(db, "sha256:"+strings.Repeat("0", 64), true, peer)
+	assert.Nil(t, err)
+	assert.Empty(t, fragments)
+	assert.Empty(t, hashes)
+}
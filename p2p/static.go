@@ -0,0 +1,187 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/spf13/afero"
+)
+
+// defaultDialTimeout bounds checking if a static peer is reachable
+const defaultDialTimeout = 2 * time.Second
+
+// defaultCheckPeriod is how often static peers are checked while listening
+const defaultCheckPeriod = time.Minute
+
+// StaticPeerResolver resolves a fixed list of peers, given as host:port addresses and in a bootstrap file, so peers can
+// be reached across networks multicast discovery doesn't cross. Peers are checked on every discovery, only reachable
+// peers are returned.
+type StaticPeerResolver struct {
+	// Peers holds host:port addresses of peers
+	Peers []string
+	// BootstrapFile holds more peers, a host:port address per line. Empty lines and lines starting with # are ignored.
+	// It is read again on every discovery so peers can be added while running
+	BootstrapFile string
+	// Fs reads the bootstrap file, the OS file system is used when it isn't set
+	Fs            afero.Fs
+	ClientFactory CreateClient
+	// DialTimeout bounds checking if a peer is reachable, defaultDialTimeout is used when it isn't set
+	DialTimeout time.Duration
+	// CheckPeriod is how often peers are checked while listening, defaultCheckPeriod is used when it isn't set
+	CheckPeriod time.Duration
+}
+
+// staticPeer is the address of a static peer
+type staticPeer struct {
+	host string
+	port int
+}
+
+func (s staticPeer) String() string {
+	return net.JoinHostPort(s.host, strconv.Itoa(s.port))
+}
+
+// Discover returns clients of the reachable peers
+func (p StaticPeerResolver) Discover(ctx context.Context) ([]Client, error) {
+	peers, err := p.addresses()
+	if err != nil {
+		log.Errorf("Failed to read peers. Reason: %s", err)
+		return nil, err
+	}
+	var clients []Client
+	for _, peer := range p.reachable(ctx, peers) {
+		log.Debugf("Connecting to %s", peer)
+		client, err := p.ClientFactory(peer.host, peer.host, peer.port)
+		if err != nil {
+			log.Errorf("Failed to create client %s. Reason: %s", peer, err)
+			continue
+		}
+		clients = append(clients, client)
+	}
+	if len(clients) == 0 {
+		return nil, errors.New("Didn't find any peers")
+	}
+	return clients, nil
+}
+
+// Listen checks the peers periodically until ctx is done, logging peers that can't be reached. Static peers aren't
+// announced, remote peers must list this node as well.
+func (p StaticPeerResolver) Listen(ctx context.Context, address string) {
+	period := p.CheckPeriod
+	if period <= 0 {
+		period = defaultCheckPeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		peers, err := p.addresses()
+		if err != nil {
+			log.Errorf("Failed to read peers. Reason: %s", err)
+		} else {
+			log.Infof("%d/%d static peers are reachable", len(p.reachable(ctx, peers)), len(peers))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// addresses returns the peers given and the peers of the bootstrap file, without duplicates
+func (p StaticPeerResolver) addresses() ([]staticPeer, error) {
+	entries := append([]string{}, p.Peers...)
+	if p.BootstrapFile != "" {
+		fs := p.Fs
+		if fs == nil {
+			fs = afero.NewOsFs()
+		}
+		f, err := fs.Open(p.BootstrapFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entries = append(entries, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var peers []staticPeer
+	seen := make(map[staticPeer]bool)
+	for _, entry := range entries {
+		peer, err := parsePeerAddress(entry)
+		if err != nil {
+			log.Warnf("Skipped peer %q. Reason: %s", entry, err)
+			continue
+		}
+		if !seen[peer] {
+			seen[peer] = true
+			peers = append(peers, peer)
+		}
+	}
+	return peers, nil
+}
+
+// reachable returns the peers accepting connections, all peers are checked at once
+func (p StaticPeerResolver) reachable(ctx context.Context, peers []staticPeer) []staticPeer {
+	timeout := p.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	ok := make([]bool, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func(i int, peer staticPeer) {
+			defer wg.Done()
+			dialer := net.Dialer{Timeout: timeout}
+			conn, err := dialer.DialContext(ctx, "tcp", peer.String())
+			if err != nil {
+				log.Debugf("Peer %s isn't reachable. Reason: %s", peer, err)
+				return
+			}
+			conn.Close()
+			ok[i] = true
+		}(i, peer)
+	}
+	wg.Wait()
+	var reachable []staticPeer
+	for i, peer := range peers {
+		if ok[i] {
+			reachable = append(reachable, peer)
+		}
+	}
+	return reachable
+}
+
+// parsePeerAddress parses the host:port address of a peer
+func parsePeerAddress(address string) (staticPeer, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return staticPeer{}, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return staticPeer{}, fmt.Errorf("Invalid port %s", port)
+	}
+	if host == "" {
+		return staticPeer{}, errors.New("Missing host")
+	}
+	return staticPeer{host, p}, nil
+}
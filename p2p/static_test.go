@@ -0,0 +1,86 @@
+package p2p_test
+
+import (
+	"context"
+	"errors"
+	"fileshare/p2p"
+	"fileshare/p2p/mocks"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// factory creates mock clients named by their address
+func factory(name, addr string, port int) (p2p.Client, error) {
+	client := &mocks.Client{}
+	client.On("Name").Return(fmt.Sprintf("%s@%d", name, port))
+	return client, nil
+}
+
+// Test only reachable peers given as flags or in the bootstrap file are discovered
+func TestStaticPeerResolver(t *testing.T) {
+	listeners := make([]net.Listener, 2)
+	for i := range listeners {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		defer lis.Close()
+		listeners[i] = lis
+	}
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "peers", []byte(fmt.Sprintf("# build servers\n%s\n\n  %s  \nnot-an-address\n127.0.0.1:0\n%s\n",
+		listeners[1].Addr(), listeners[0].Addr(), closed.Addr())), 0644)
+	r := p2p.StaticPeerResolver{Peers: []string{listeners[0].Addr().String()}, BootstrapFile: "peers", Fs: fs,
+		ClientFactory: factory}
+	clients, err := r.Discover(context.Background())
+	assert.Nil(t, err)
+	var names []string
+	for _, c := range clients {
+		names = append(names, c.Name())
+	}
+	assert.Equal(t, []string{
+		fmt.Sprintf("127.0.0.1@%d", listeners[0].Addr().(*net.TCPAddr).Port),
+		fmt.Sprintf("127.0.0.1@%d", listeners[1].Addr().(*net.TCPAddr).Port),
+	}, names)
+	// Peers are checked again on every discovery
+	listeners[0].Close()
+	listeners[1].Close()
+	_, err = r.Discover(context.Background())
+	assert.NotNil(t, err)
+	r.BootstrapFile = "missing"
+	_, err = r.Discover(context.Background())
+	assert.NotNil(t, err)
+}
+
+// resolverFunc is a PeerResolver discovering with a function
+type resolverFunc func(ctx context.Context) ([]p2p.Client, error)
+
+func (f resolverFunc) Discover(ctx context.Context) ([]p2p.Client, error) {
+	return f(ctx)
+}
+
+func (f resolverFunc) Listen(ctx context.Context, addr string) {}
+
+// Test clients of all resolvers are discovered, and discovery fails only when all resolvers failed
+func TestMultiResolver(t *testing.T) {
+	c1, _ := factory("a", "127.0.0.1", 1)
+	c2, _ := factory("b", "127.0.0.1", 2)
+	failing := resolverFunc(func(ctx context.Context) ([]p2p.Client, error) {
+		return nil, errors.New("Didn't find any peers")
+	})
+	m := p2p.MultiResolver{
+		resolverFunc(func(ctx context.Context) ([]p2p.Client, error) { return []p2p.Client{c1}, nil }),
+		failing,
+		resolverFunc(func(ctx context.Context) ([]p2p.Client, error) { return []p2p.Client{c2}, nil }),
+	}
+	clients, err := m.Discover(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []p2p.Client{c1, c2}, clients)
+	_, err = p2p.MultiResolver{failing, failing}.Discover(context.Background())
+	assert.NotNil(t, err)
+	m.Listen(context.Background(), "127.0.0.1")
+}
@@ -0,0 +1,122 @@
+package p2p
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxSwarmClockSkew is how old or new a swarm token may be, tokens are remembered for as long to reject replays
+const MaxSwarmClockSkew = 5 * time.Minute
+
+// swarmNonceSize is the size of the random nonce making every swarm token unique
+const swarmNonceSize = 16
+
+// SwarmProof proves holding the pre-shared key of a private swarm, without sending the key itself
+func SwarmProof(key string, message string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// VerifySwarmProof checks that proof was created with the swarm key
+func VerifySwarmProof(key string, message string, proof []byte) bool {
+	return hmac.Equal(SwarmProof(key, message), proof)
+}
+
+// SwarmToken proves holding the swarm key in a single request for resource, i.e a gRPC method or an HTTP method and
+// URI, sent at the given time. Tokens are <unix time>:<nonce>:<proof>, they don't reveal the key but anyone seeing a
+// token could send it, so nodes accept every token once, see SwarmGate
+func SwarmToken(key string, resource string, now time.Time) string {
+	unix := strconv.FormatInt(now.Unix(), 10)
+	nonce := make([]byte, swarmNonceSize)
+	rand.Read(nonce)
+	return unix + ":" + hex.EncodeToString(nonce) + ":" +
+		hex.EncodeToString(SwarmProof(key, swarmTokenMessage(unix, hex.EncodeToString(nonce), resource)))
+}
+
+// VerifySwarmToken checks that a token was created with the swarm key for resource, and that it hasn't expired
+func VerifySwarmToken(key string, token string, resource string, now time.Time) error {
+	parts := strings.SplitN(token, ":", 3)
+	if len(parts) != 3 {
+		return errors.New("Invalid swarm key")
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return errors.New("Invalid swarm key")
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > MaxSwarmClockSkew || skew < -MaxSwarmClockSkew {
+		return errors.New("Expired swarm key")
+	}
+	proof, err := hex.DecodeString(parts[2])
+	if err != nil || !VerifySwarmProof(key, swarmTokenMessage(parts[0], parts[1], resource), proof) {
+		return errors.New("Invalid swarm key")
+	}
+	return nil
+}
+
+// swarmTokenMessage returns the signed part of a token
+func swarmTokenMessage(unix, nonce, resource string) string {
+	return fmt.Sprintf("fileshare-swarm:%s:%s:%s", unix, nonce, resource)
+}
+
+// SwarmResource returns the resource of an HTTP request its swarm token is bound to, the method and URI holding the
+// file hash
+func SwarmResource(req *http.Request) string {
+	return req.Method + " " + req.URL.RequestURI()
+}
+
+// SwarmGate admits requests of peers holding the swarm key, every token is admitted once so tokens seen on the network
+// can't be replayed
+type SwarmGate struct {
+	key string
+	mu  sync.Mutex
+	// seen holds the tokens admitted and when they expire
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewSwarmGate creates a gate admitting requests with tokens of the swarm key
+func NewSwarmGate(key string) *SwarmGate {
+	return &SwarmGate{key: key, seen: make(map[string]time.Time)}
+}
+
+// Admit checks a token was created with the swarm key for resource, and that it wasn't admitted before
+func (g *SwarmGate) Admit(token string, resource string, now time.Time) error {
+	if err := VerifySwarmToken(g.key, token, resource, now); err != nil {
+		return err
+	}
+	// Tokens are identified by their signed part, the proof could be encoded differently
+	parts := strings.SplitN(token, ":", 3)
+	unix, _ := strconv.ParseInt(parts[0], 10, 64)
+	id := parts[0] + ":" + parts[1]
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// Expired tokens are rejected anyway, they don't need to be remembered
+	if now.Sub(g.pruned) > time.Minute {
+		for seen, expires := range g.seen {
+			if now.After(expires) {
+				delete(g.seen, seen)
+			}
+		}
+		g.pruned = now
+	}
+	if _, ok := g.seen[id]; ok {
+		return errors.New("Replayed swarm key")
+	}
+	g.seen[id] = time.Unix(unix, 0).Add(MaxSwarmClockSkew)
+	return nil
+}
+
+// message returns the signed part of a payload
+func (dp DiscoveryPayload) message() string {
+	return fmt.Sprintf("fileshare-discovery:%s:%s:%d", dp.Name, dp.Addr, dp.Port)
+}
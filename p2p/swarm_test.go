@@ -0,0 +1,63 @@
+package p2p
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test announcements of a private swarm prove holding the swarm key
+func TestSwarmAnnouncement(t *testing.T) {
+	p := SimplePeerDiscovery{Payload: DiscoveryPayload{Name: "node", Addr: "10.0.0.1", Port: 7979}, SwarmKey: "secret"}
+	payload := p.announcement()
+	assert.True(t, VerifySwarmProof("secret", payload.message(), payload.Proof))
+	assert.False(t, VerifySwarmProof("other", payload.message(), payload.Proof))
+	// Proofs are bound to the announced address
+	payload.Addr = "10.0.0.2"
+	assert.False(t, VerifySwarmProof("secret", payload.message(), payload.Proof))
+	// Announcements survive encoding
+	decoded, err := decodePayloadFromBytes(encodePayload(p.announcement()))
+	assert.Nil(t, err)
+	assert.Equal(t, p.announcement(), decoded)
+	_, err = decodePayloadFromBytes([]byte("not a payload"))
+	assert.NotNil(t, err)
+	// Public peers don't send a proof
+	assert.Nil(t, SimplePeerDiscovery{Payload: p.Payload}.announcement().Proof)
+}
+
+// Test request tokens prove holding the swarm key for a single resource and expire
+func TestSwarmToken(t *testing.T) {
+	now := time.Now()
+	const resource = "/rpc.FileService/List"
+	token := SwarmToken("secret", resource, now)
+	assert.Nil(t, VerifySwarmToken("secret", token, resource, now))
+	assert.Nil(t, VerifySwarmToken("secret", token, resource, now.Add(MaxSwarmClockSkew-time.Second)))
+	assert.NotNil(t, VerifySwarmToken("other", token, resource, now))
+	assert.NotNil(t, VerifySwarmToken("secret", token, resource, now.Add(MaxSwarmClockSkew+time.Second)))
+	assert.NotNil(t, VerifySwarmToken("secret", token, resource, now.Add(-MaxSwarmClockSkew-time.Second)))
+	assert.NotNil(t, VerifySwarmToken("secret", "", resource, now))
+	assert.NotNil(t, VerifySwarmToken("secret", "abc:def", resource, now))
+	// Tokens are bound to the resource they were sent for, and are unique
+	assert.NotNil(t, VerifySwarmToken("secret", token, "/rpc.FileService/Download", now))
+	assert.NotEqual(t, token, SwarmToken("secret", resource, now))
+}
+
+// Test the gate admits every token once, so tokens seen on the network can't be replayed
+func TestSwarmGate(t *testing.T) {
+	now := time.Now()
+	gate := NewSwarmGate("secret")
+	token := SwarmToken("secret", "GET /files", now)
+	assert.Nil(t, gate.Admit(token, "GET /files", now))
+	assert.NotNil(t, gate.Admit(token, "GET /files", now))
+	// Encoding the proof differently doesn't make it another token
+	assert.NotNil(t, gate.Admit(strings.ToUpper(token), "GET /files", now))
+	assert.NotNil(t, gate.Admit(SwarmToken("other", "GET /files", now), "GET /files", now))
+	assert.Nil(t, gate.Admit(SwarmToken("secret", "GET /files", now), "GET /files", now))
+	// Expired tokens are forgotten, they are rejected anyway
+	later := now.Add(MaxSwarmClockSkew + time.Minute + time.Second)
+	assert.Nil(t, gate.Admit(SwarmToken("secret", "GET /files", later), "GET /files", later))
+	assert.Len(t, gate.seen, 1)
+	assert.NotNil(t, gate.Admit(token, "GET /files", later))
+}
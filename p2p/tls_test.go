@@ -0,0 +1,233 @@
+package p2p_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fileshare/p2p"
+	"fileshare/p2p/rpc"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA issues certificates for peers, written to files in a temporary directory
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA creates a CA and writes its certificate to ca.pem
+func newTestCA(t *testing.T) *testCA {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.Nil(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Test CA"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), IsCA: true,
+		BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	ca := &testCA{t, dir, cert, key}
+	ca.write("ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+// file returns the path of a file of the CA's directory
+func (ca *testCA) file(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+// write writes a PEM block to a file of the CA's directory
+func (ca *testCA) write(name, blockType string, der []byte) {
+	assert.Nil(ca.t, ioutil.WriteFile(ca.file(name), pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+}
+
+// issue issues a certificate for a peer's name with its groups as organizational units, it can be used by seeding nodes
+// and by clients. The config uses the certificate and trusts the CA
+func (ca *testCA) issue(name string, groups []string, mutual bool) rpc.TLSConfig {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(ca.t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.Nil(ca.t, err)
+	template := &x509.Certificate{SerialNumber: serial, Subject: pkix.Name{CommonName: name, OrganizationalUnit: groups},
+		DNSNames: []string{name}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.Nil(ca.t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(ca.t, err)
+	ca.write(name+".pem", "CERTIFICATE", der)
+	ca.write(name+"-key.pem", "EC PRIVATE KEY", keyDer)
+	return rpc.TLSConfig{CertFile: ca.file(name + ".pem"), KeyFile: ca.file(name + "-key.pem"), CAFile: ca.file("ca.pem"),
+		Mutual: mutual}
+}
+
+// handshakeResult holds the errors of both sides of a TLS handshake, and the identity of the client the server verified
+type handshakeResult struct {
+	serverErr, clientErr error
+	name                 string
+	groups               []string
+}
+
+// handshake connects a client to a TLS server
+func handshake(t *testing.T, server, client *tls.Config) handshakeResult {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	assert.Nil(t, err)
+	defer lis.Close()
+	accepted := make(chan handshakeResult, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			accepted <- handshakeResult{serverErr: err}
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			accepted <- handshakeResult{serverErr: err}
+			return
+		}
+		name, groups, _ := rpc.CertificateIdentity(tlsConn.ConnectionState())
+		// The client waits for a reply, so it sees when the server refused its certificate
+		tlsConn.Write([]byte("ok"))
+		accepted <- handshakeResult{name: name, groups: groups}
+	}()
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err == nil {
+		_, err = conn.Read(make([]byte, 2))
+		conn.Close()
+	} else {
+		// The server may wait for a client that gave up on the handshake
+		lis.Close()
+	}
+	r := <-accepted
+	r.clientErr = err
+	return r
+}
+
+// Test clients only connect to nodes with a certificate for their name signed by the CA, and nodes with mutual TLS only
+// accept clients with a certificate signed by the CA
+func TestTLSHandshake(t *testing.T) {
+	ca := newTestCA(t)
+	defer os.RemoveAll(ca.dir)
+	seeder := ca.issue("seeder", nil, false)
+	server, err := seeder.ServerConfig()
+	assert.Nil(t, err)
+	trusting := rpc.TLSConfig{CAFile: ca.file("ca.pem")}
+
+	client, err := trusting.ClientConfig("seeder")
+	assert.Nil(t, err)
+	r := handshake(t, server, client)
+	assert.Nil(t, r.serverErr)
+	assert.Nil(t, r.clientErr)
+	// Clients without certificates have no identity
+	assert.Empty(t, r.name)
+
+	client, err = trusting.ClientConfig("other")
+	assert.Nil(t, err)
+	assert.NotNil(t, handshake(t, server, client).clientErr)
+
+	// Certificates of other CAs aren't trusted
+	other := newTestCA(t)
+	defer os.RemoveAll(other.dir)
+	client, err = rpc.TLSConfig{CAFile: other.file("ca.pem")}.ClientConfig("seeder")
+	assert.Nil(t, err)
+	assert.NotNil(t, handshake(t, server, client).clientErr)
+
+	seeder.Mutual = true
+	server, err = seeder.ServerConfig()
+	assert.Nil(t, err)
+	client, err = trusting.ClientConfig("seeder")
+	assert.Nil(t, err)
+	r = handshake(t, server, client)
+	assert.NotNil(t, r.serverErr)
+	assert.NotNil(t, r.clientErr)
+	client, err = other.issue("intruder", nil, true).ClientConfig("seeder")
+	assert.Nil(t, err)
+	assert.NotNil(t, handshake(t, server, client).serverErr)
+
+	// The common name and organizational units of verified certificates are the name and groups of peers
+	client, err = ca.issue("accountant", []string{"finance", "audit"}, true).ClientConfig("seeder")
+	assert.Nil(t, err)
+	r = handshake(t, server, client)
+	assert.Nil(t, r.serverErr)
+	assert.Nil(t, r.clientErr)
+	assert.Equal(t, "accountant", r.name)
+	assert.ElementsMatch(t, []string{"finance", "audit"}, r.groups)
+
+	// A mutual config can't be used without a certificate
+	_, err = rpc.TLSConfig{CAFile: ca.file("ca.pem"), Mutual: true}.ClientConfig("seeder")
+	assert.NotNil(t, err)
+	_, err = rpc.TLSConfig{CertFile: ca.file("seeder.pem"), KeyFile: ca.file("seeder-key.pem"), Mutual: true}.ServerConfig()
+	assert.NotNil(t, err)
+}
+
+// Test a node with mutual TLS only lists restricted files to the peers and groups of their access list, identified by
+// their certificates
+func TestTLSAccess(t *testing.T) {
+	ca := newTestCA(t)
+	defer os.RemoveAll(ca.dir)
+	defer os.Remove("test.db")
+	db, err := p2p.CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewOsFs()
+	assert.Nil(t, p2p.Publish(fs, db, "file.go", p2p.SHA256, 0, nil))
+	assert.Nil(t, p2p.Publish(fs, db, "access.go", p2p.SHA256, 0, []string{"hr-laptop", p2p.GroupPrefix + "finance"}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	node := rpc.NewNode("seeder", "test.db", false)
+	node.SetTLS(ca.issue("seeder", nil, true))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go node.Seed(ctx, resolverFunc(nil), "127.0.0.1", port, false)
+	time.Sleep(100 * time.Millisecond)
+
+	list := func(config rpc.TLSConfig) ([]string, error) {
+		client, err := rpc.ClientFactory(config, "", p2p.DefaultCallPolicy)("seeder", "127.0.0.1", port)
+		assert.Nil(t, err)
+		defer client.Close()
+		files, err := client.List(ctx)
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		return names, err
+	}
+	for _, c := range []struct {
+		name   string
+		groups []string
+		files  []string
+	}{
+		{"hr-laptop", nil, []string{"file.go", "access.go"}},
+		{"accountant", []string{"finance"}, []string{"file.go", "access.go"}},
+		{"intern", []string{"interns"}, []string{"file.go"}},
+		// Groups are only taken from organizational units, not from names
+		{"finance", nil, []string{"file.go"}},
+	} {
+		names, err := list(ca.issue(c.name, c.groups, true))
+		assert.Nil(t, err, c.name)
+		assert.ElementsMatch(t, c.files, names, c.name)
+	}
+	_, err = list(rpc.TLSConfig{CAFile: ca.file("ca.pem")})
+	assert.NotNil(t, err)
+}
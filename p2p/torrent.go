@@ -0,0 +1,288 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/afero"
+)
+
+// BitTorrent metainfo versions
+const (
+	// TorrentV1 describes pieces by their SHA-1 hash, supported by all BitTorrent clients
+	TorrentV1 = 1
+	// TorrentV2 describes files by merkle trees of SHA-256 hashes of 16 KB blocks, see BEP 52
+	TorrentV2 = 2
+)
+
+// torrentBlockSize is the size of the leaves of version 2 merkle trees
+const torrentBlockSize = 16 * (1 << 10)
+
+// torrentKey holds the fileshare meta data in exported torrents, it is outside of the info dictionary so it doesn't
+// change the info hash. BitTorrent clients ignore it.
+const torrentKey = "fileshare"
+
+// ExportTorrent writes BitTorrent metainfo of a complete file to w, returning its info hash. BitTorrent pieces are the
+// fragments of the file, they are hashed again with the algorithms of the torrent's version. The file hash and fragment
+// hashes are added to the torrent, so it can be imported by other peers.
+func ExportTorrent(fs afero.Fs, db *gorm.DB, fileHash string, version int, trackers []string, w io.Writer) (string, error) {
+	fileHash, err := NormalizeHash(fileHash)
+	if err != nil {
+		return "", err
+	}
+	var fm FileMetaData
+	if db.Where("hash = ?", fileHash).First(&fm).RecordNotFound() {
+		return "", errors.New("File not found")
+	}
+	if fm.Status != Seeding && fm.Status != Finished {
+		return "", fmt.Errorf("File(%s) isn't complete, status is %s", fm.Name, fm.Status)
+	}
+	if len(fm.FragmentHashes) != fm.FragmentsCount {
+		return "", fmt.Errorf("File(%s) doesn't have fragment hashes, publish it again", fm.Name)
+	}
+	f, err := fs.Open(fm.FilePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var info map[string]interface{}
+	torrent := map[string]interface{}{"created by": "fileshare"}
+	switch version {
+	case TorrentV1:
+		info, err = torrentInfoV1(fm, f)
+	case TorrentV2:
+		var layers map[string]interface{}
+		info, layers, err = torrentInfoV2(fm, f)
+		torrent["piece layers"] = layers
+	default:
+		return "", fmt.Errorf("Unsupported torrent version %d", version)
+	}
+	if err != nil {
+		return "", err
+	}
+	torrent["info"] = info
+	if len(trackers) > 0 {
+		torrent["announce"] = trackers[0]
+		var tiers []interface{}
+		for _, t := range trackers {
+			tiers = append(tiers, []interface{}{t})
+		}
+		torrent["announce-list"] = tiers
+	}
+	var leaves bytes.Buffer
+	for _, h := range fm.FragmentHashes {
+		leaf, err := hex.DecodeString(h)
+		if err != nil {
+			return "", err
+		}
+		leaves.Write(leaf)
+	}
+	meta := map[string]interface{}{"hash": fm.Hash, "chunk size": fm.Chunk(), "fragment hashes": leaves.Bytes()}
+	if fm.Encrypted() {
+		meta["encryption"] = fm.Encryption
+	}
+	torrent[torrentKey] = meta
+	encodedInfo, err := bencodeBytes(info)
+	if err != nil {
+		return "", err
+	}
+	var infoHash []byte
+	if version == TorrentV1 {
+		h := sha1.Sum(encodedInfo)
+		infoHash = h[:]
+	} else {
+		h := sha256.Sum256(encodedInfo)
+		infoHash = h[:]
+	}
+	log.Infof("Exporting file(name=%s) as a version %d torrent", fm.Name, version)
+	return hex.EncodeToString(infoHash), bencode(w, torrent)
+}
+
+// torrentInfoV1 creates the info dictionary of a version 1 torrent, fragments are checked against their hashes as they
+// are read
+func torrentInfoV1(fm FileMetaData, r io.ReaderAt) (map[string]interface{}, error) {
+	var pieces bytes.Buffer
+	err := readFragments(fm, r, func(id int, data []byte) {
+		h := sha1.Sum(data)
+		pieces.Write(h[:])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"name": fm.Name, "length": fm.Size, "piece length": fm.Chunk(), "pieces": pieces.Bytes()}, nil
+}
+
+// torrentInfoV2 creates the info dictionary and piece layers of a version 2 torrent, fragments are checked against
+// their hashes as they are read
+func torrentInfoV2(fm FileMetaData, r io.ReaderAt) (map[string]interface{}, map[string]interface{}, error) {
+	chunk := fm.Chunk()
+	if chunk < torrentBlockSize || chunk&(chunk-1) != 0 {
+		return nil, nil, fmt.Errorf("Version 2 torrents require a chunk size that is a power of 2 of at least %d bytes", torrentBlockSize)
+	}
+	blocksPerPiece := int(chunk / torrentBlockSize)
+	var blocks, pieces [][]byte
+	err := readFragments(fm, r, func(id int, data []byte) {
+		var piece [][]byte
+		for offset := 0; offset < len(data); offset += torrentBlockSize {
+			end := offset + torrentBlockSize
+			if end > len(data) {
+				end = len(data)
+			}
+			h := sha256.Sum256(data[offset:end])
+			piece = append(piece, h[:])
+		}
+		blocks = append(blocks, piece...)
+		pieces = append(pieces, torrentMerkleRoot(piece, blocksPerPiece))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	file := map[string]interface{}{"length": fm.Size}
+	layers := map[string]interface{}{}
+	if fm.Size > 0 {
+		root := torrentMerkleRoot(blocks, nextPowerOfTwo(len(blocks)))
+		file["pieces root"] = root
+		// Files of a single piece are verified by their root
+		if fm.Size > chunk {
+			layers[string(root)] = bytes.Join(pieces, nil)
+		}
+	}
+	info := map[string]interface{}{
+		"name":         fm.Name,
+		"meta version": TorrentV2,
+		"piece length": chunk,
+		"file tree":    map[string]interface{}{fm.Name: map[string]interface{}{"": file}},
+	}
+	return info, layers, nil
+}
+
+// readFragments reads every fragment of a file, checking it against its fragment hash
+func readFragments(fm FileMetaData, r io.ReaderAt, read func(id int, data []byte)) error {
+	buffer := make([]byte, fm.Chunk())
+	for id := 0; id < fm.FragmentsCount; id++ {
+		data := buffer[:fm.FragmentSize(id)]
+		if n, err := r.ReadAt(data, fm.FragmentOffset(id)); n != len(data) {
+			return err
+		}
+		if !fm.VerifyFragment(id, data, nil) {
+			return fmt.Errorf("Fragment %d of file(%s) doesn't match its hash", id, fm.Name)
+		}
+		read(id, data)
+	}
+	return nil
+}
+
+// torrentMerkleRoot returns the root of a SHA-256 merkle tree of count leaves, missing leaves are zero hashes
+func torrentMerkleRoot(leaves [][]byte, count int) []byte {
+	layer := make([][]byte, count)
+	copy(layer, leaves)
+	for i := len(leaves); i < count; i++ {
+		layer[i] = make([]byte, sha256.Size)
+	}
+	for len(layer) > 1 {
+		next := make([][]byte, len(layer)/2)
+		for i := range next {
+			h := sha256.New()
+			h.Write(layer[2*i])
+			h.Write(layer[2*i+1])
+			next[i] = h.Sum(nil)
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+// nextPowerOfTwo returns the smallest power of 2 not smaller than n
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// ImportTorrent reads BitTorrent metainfo exported by fileshare, and creates a Downloading file in dlDirectory so it
+// can be downloaded from peers. Torrents created by other tools don't hold the file hash, these files must be published
+// to fileshare instead.
+func ImportTorrent(db *gorm.DB, r io.Reader, dlDirectory string) (FileMetaData, error) {
+	decoded, err := bdecode(r)
+	if err != nil {
+		return FileMetaData{}, fmt.Errorf("Invalid torrent. Reason: %s", err)
+	}
+	torrent, _ := decoded.(map[string]interface{})
+	info, _ := torrent["info"].(map[string]interface{})
+	if info == nil {
+		return FileMetaData{}, errors.New("Invalid torrent, info is missing")
+	}
+	meta, _ := torrent[torrentKey].(map[string]interface{})
+	if meta == nil {
+		return FileMetaData{}, errors.New("Torrent wasn't exported by fileshare, publish its file instead")
+	}
+	name, _ := info["name"].(string)
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return FileMetaData{}, fmt.Errorf("Invalid file name %q", name)
+	}
+	size, ok := torrentFileLength(info, name)
+	if !ok {
+		return FileMetaData{}, errors.New("Only torrents of a single file are supported")
+	}
+	hash, _ := meta["hash"].(string)
+	hash, err = NormalizeHash(hash)
+	if err != nil {
+		return FileMetaData{}, err
+	}
+	chunkSize, _ := meta["chunk size"].(int64)
+	if pieceLength, _ := info["piece length"].(int64); chunkSize <= 0 || chunkSize > MaxChunkSize || pieceLength != chunkSize {
+		return FileMetaData{}, fmt.Errorf("Invalid chunk size %d", chunkSize)
+	}
+	encryption, _ := meta["encryption"].(string)
+	fm := FileMetaData{Name: name, FilePath: path.Join(dlDirectory, name), Hash: hash, Size: size, ChunkSize: chunkSize,
+		Encryption: encryption, FragmentsCount: int(math.Ceil(float64(size) / float64(chunkSize))), Status: Downloading}
+	leaf, err := fm.Algorithm().fragmentAlgorithm().New()
+	if err != nil {
+		return FileMetaData{}, err
+	}
+	leaves, _ := meta["fragment hashes"].(string)
+	leafSize := leaf.Size()
+	if len(leaves) != leafSize*fm.FragmentsCount {
+		return FileMetaData{}, errors.New("Invalid fragment hashes")
+	}
+	for i := 0; i < len(leaves); i += leafSize {
+		fm.FragmentHashes = append(fm.FragmentHashes, hex.EncodeToString([]byte(leaves[i:i+leafSize])))
+	}
+	if !fm.VerifyFragmentHashes() {
+		return FileMetaData{}, errors.New("Fragment hashes don't match the file hash")
+	}
+	var existing FileMetaData
+	if !db.Where("hash = ?", fm.Hash).First(&existing).RecordNotFound() {
+		return FileMetaData{}, fmt.Errorf("File(%s) already exists, status is %s", existing.Name, existing.Status)
+	}
+	log.Infof("Importing file(name=%s, hash=%s) for download", fm.Name, fm.Hash)
+	return fm, db.Save(&fm).Error
+}
+
+// torrentFileLength returns the length of a single file torrent of either version
+func torrentFileLength(info map[string]interface{}, name string) (int64, bool) {
+	if length, ok := info["length"].(int64); ok {
+		return length, length >= 0
+	}
+	tree, _ := info["file tree"].(map[string]interface{})
+	if len(tree) != 1 {
+		return 0, false
+	}
+	entry, _ := tree[name].(map[string]interface{})
+	file, _ := entry[""].(map[string]interface{})
+	length, ok := file["length"].(int64)
+	return length, ok && length >= 0
+}
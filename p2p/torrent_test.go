@@ -0,0 +1,98 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test torrents describe the fragments of a file as pieces, and can be imported to download the file
+func TestExportTorrent(t *testing.T) {
+	defer os.Remove("test.db")
+	db, err := CreateDatabase("test.db", false)
+	assert.Nil(t, err)
+	fs := afero.NewMemMapFs()
+	data := make([]byte, 40*(1<<10))
+	rand.Read(data)
+	assert.Nil(t, afero.WriteFile(fs, "file.bin", data, 0644))
+	assert.Nil(t, Publish(fs, db, "file.bin", SHA256, 32*(1<<10), nil))
+	var fm FileMetaData
+	db.Where("file_path = ?", "file.bin").First(&fm)
+
+	var b bytes.Buffer
+	infoHash, err := ExportTorrent(fs, db, fm.Hash, TorrentV1, []string{"http://tracker/announce"}, &b)
+	assert.Nil(t, err)
+	decoded, err := bdecode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	torrent := decoded.(map[string]interface{})
+	info := torrent["info"].(map[string]interface{})
+	first, second := sha1.Sum(data[:32*(1<<10)]), sha1.Sum(data[32*(1<<10):])
+	assert.Equal(t, string(first[:])+string(second[:]), info["pieces"])
+	assert.Equal(t, int64(len(data)), info["length"])
+	assert.Equal(t, "http://tracker/announce", torrent["announce"])
+	encodedInfo, _ := bencodeBytes(info)
+	h := sha1.Sum(encodedInfo)
+	assert.Equal(t, infoHash, hex.EncodeToString(h[:]))
+
+	// Version 2 pieces are merkle trees of 16 KB blocks, missing blocks are zero hashes
+	b.Reset()
+	_, err = ExportTorrent(fs, db, fm.Hash, TorrentV2, nil, &b)
+	assert.Nil(t, err)
+	decoded, err = bdecode(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+	torrent = decoded.(map[string]interface{})
+	var blocks [][]byte
+	for offset := 0; offset < len(data); offset += torrentBlockSize {
+		end := offset + torrentBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha256.Sum256(data[offset:end])
+		blocks = append(blocks, h[:])
+	}
+	pieces := [][]byte{sha256Pair(blocks[0], blocks[1]), sha256Pair(blocks[2], make([]byte, 32))}
+	root := sha256Pair(pieces[0], pieces[1])
+	file := torrent["info"].(map[string]interface{})["file tree"].(map[string]interface{})["file.bin"].(map[string]interface{})[""].(map[string]interface{})
+	assert.Equal(t, string(root), file["pieces root"])
+	assert.Equal(t, string(bytes.Join(pieces, nil)), torrent["piece layers"].(map[string]interface{})[string(root)])
+	_, err = ExportTorrent(fs, db, fm.Hash, 3, nil, &b)
+	assert.NotNil(t, err)
+
+	// Torrents are imported as new downloads
+	defer os.Remove("import.db")
+	other, err := CreateDatabase("import.db", false)
+	assert.Nil(t, err)
+	imported, err := ImportTorrent(other, bytes.NewReader(b.Bytes()), "downloads")
+	assert.Nil(t, err)
+	assert.Equal(t, fm.Hash, imported.Hash)
+	assert.Equal(t, fm.FragmentHashes, imported.FragmentHashes)
+	assert.Equal(t, fm.Size, imported.Size)
+	assert.Equal(t, "downloads/file.bin", imported.FilePath)
+	assert.Equal(t, Status(Downloading), imported.Status)
+	_, err = ImportTorrent(other, bytes.NewReader(b.Bytes()), "downloads")
+	assert.NotNil(t, err)
+
+	// Torrents of other tools don't hold the file hash
+	delete(torrent, torrentKey)
+	foreign, _ := bencodeBytes(torrent)
+	_, err = ImportTorrent(other, bytes.NewReader(foreign), "downloads")
+	assert.NotNil(t, err)
+
+	// Modified files can't be exported
+	data[0]++
+	assert.Nil(t, afero.WriteFile(fs, "file.bin", data, 0644))
+	_, err = ExportTorrent(fs, db, fm.Hash, TorrentV1, nil, &b)
+	assert.NotNil(t, err)
+}
+
+func sha256Pair(left, right []byte) []byte {
+	h := sha256.Sum256(append(append([]byte{}, left...), right...))
+	return h[:]
+}
@@ -0,0 +1,453 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/afero"
+)
+
+// TrackerSwarmHeader holds the token proving the swarm key sent with every request to a tracker, see SwarmToken
+const TrackerSwarmHeader = "X-Fileshare-Swarm"
+
+const (
+	// DefaultAnnouncePeriod is how often seeding peers announce their files to a tracker
+	DefaultAnnouncePeriod = time.Minute
+	// DefaultTrackerTTL is how long a tracker indexes a peer after its last announcement
+	DefaultTrackerTTL = 3 * DefaultAnnouncePeriod
+	// maxTrackedPeers bounds the peers a tracker indexes
+	maxTrackedPeers = 10000
+	// maxHostPeers bounds the peers a tracker indexes for a single host, so a host can't fill the index
+	maxHostPeers = 32
+	// maxAnnouncedFiles bounds the files indexed of a peer, and maxAnnouncedFragments the fragments of each of them
+	maxAnnouncedFiles     = 1000
+	maxAnnouncedFragments = 1 << 16
+	// maxAnnouncementSize bounds the size of an announcement in bytes
+	maxAnnouncementSize = 4 * (1 << 20) // 4 MB
+	// trackerTimeout bounds every request to a tracker
+	trackerTimeout = 10 * time.Second
+)
+
+// TrackerFile is a file announced to a tracker, and the fragments of it the peer holds
+type TrackerFile struct {
+	Hash      string `json:"hash"`
+	Fragments []int  `json:"fragments"`
+}
+
+// Announcement is sent by seeding peers to a tracker, peers are indexed until they stop announcing
+type Announcement struct {
+	Name string `json:"name"`
+	// Addr is where the peer serves files, only the address the announcement came from is accepted. It is used when
+	// Addr isn't set
+	Addr      string        `json:"addr,omitempty"`
+	Port      int           `json:"port"`
+	Transport string        `json:"transport,omitempty"`
+	Files     []TrackerFile `json:"files"`
+	// Stopped removes the peer from the index, it is sent when the peer stops seeding
+	Stopped bool `json:"stopped,omitempty"`
+}
+
+// TrackerPeer is a peer indexed by a tracker, with the fragments it holds when peers of a file were requested
+type TrackerPeer struct {
+	Name      string `json:"name"`
+	Addr      string `json:"addr"`
+	Port      int    `json:"port"`
+	Transport string `json:"transport"`
+	Fragments []int  `json:"fragments,omitempty"`
+}
+
+// Tracker is a central index of the peers seeding every file, a coordination point for networks where peers can't
+// discover each other.
+//
+//	POST /announce              JSON Announcement, the peer is indexed until it doesn't announce for the TTL
+//	GET  /peers                 JSON list of all peers, see TrackerPeer
+//	GET  /peers?hash=<hash>     JSON list of the peers holding a file, the peers holding most fragments first
+//	GET  /peers?limit=<n>       JSON list of up to n peers picked at random, of a file when the hash is set too
+type Tracker struct {
+	mu sync.Mutex
+	// peers holds the peers by the host their announcements come from and the port they serve files on
+	peers map[string]trackedPeer
+	ttl   time.Duration
+	// swarm admits requests of peers holding the pre-shared key of a private swarm, nil when the swarm is public
+	swarm *SwarmGate
+}
+
+// trackedPeer is an announcement of a peer and when it expires
+type trackedPeer struct {
+	announcement Announcement
+	files        map[string][]int
+	expires      time.Time
+}
+
+// NewTracker creates a tracker indexing peers for ttl after their last announcement, DefaultTrackerTTL is used when
+// ttl isn't set
+func NewTracker(ttl time.Duration) *Tracker {
+	if ttl <= 0 {
+		ttl = DefaultTrackerTTL
+	}
+	return &Tracker{peers: make(map[string]trackedPeer), ttl: ttl}
+}
+
+// SetSwarmKey makes the tracker part of a private swarm, only peers holding the key can announce and ask for peers
+func (t *Tracker) SetSwarmKey(key string) {
+	if key != "" {
+		t.swarm = NewSwarmGate(key)
+	}
+}
+
+// Serve serves the tracker until ctx is done
+func (t *Tracker) Serve(ctx context.Context, addr string, port int) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", addr, port))
+	if err != nil {
+		return err
+	}
+	log.Infof("Tracker listening on %s:%d", addr, port)
+	server := &http.Server{Handler: t.Handler()}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(lis); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Handler returns the handler serving the tracker
+func (t *Tracker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/announce", t.intercept(http.MethodPost, t.announce))
+	mux.HandleFunc("/peers", t.intercept(http.MethodGet, t.list))
+	return mux
+}
+
+// announce indexes the files of a peer, replacing the files it announced before
+func (t *Tracker) announce(w http.ResponseWriter, req *http.Request) {
+	var a Announcement
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxAnnouncementSize)).Decode(&a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Peers are indexed at the address the announcement came from, so peers can't announce other hosts. Peers
+	// listening on all interfaces don't know the address they are reached on anyway
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ip := net.ParseIP(a.Addr); a.Addr != "" && (ip == nil || !ip.IsUnspecified()) && !sameHost(a.Addr, host) {
+		http.Error(w, fmt.Sprintf("Announced address %s isn't %s", a.Addr, host), http.StatusBadRequest)
+		return
+	}
+	a.Addr = host
+	payload := DiscoveryPayload{Name: a.Name, Addr: a.Addr, Port: a.Port, Transport: a.Transport}
+	if err := validExchangedPeer(payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.Transport = payload.transport()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := net.JoinHostPort(host, strconv.Itoa(a.Port))
+	if a.Stopped {
+		log.Infof("Peer %s stopped seeding", peerKey(payload))
+		delete(t.peers, key)
+		return
+	}
+	t.expire()
+	if _, ok := t.peers[key]; !ok {
+		if len(t.peers) >= maxTrackedPeers {
+			http.Error(w, "Too many peers", http.StatusServiceUnavailable)
+			return
+		}
+		if t.hostPeers(host) >= maxHostPeers {
+			http.Error(w, "Too many peers of the host", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	files := make(map[string][]int)
+	for _, f := range a.Files {
+		if len(files) >= maxAnnouncedFiles {
+			log.Debugf("Skipped %d files of %s, it announced too many", len(a.Files)-len(files), peerKey(payload))
+			break
+		}
+		hash, err := NormalizeHash(f.Hash)
+		if err != nil {
+			log.Debugf("Skipped file %s of %s. Reason: %s", f.Hash, peerKey(payload), err)
+			continue
+		}
+		if len(f.Fragments) > maxAnnouncedFragments {
+			f.Fragments = f.Fragments[:maxAnnouncedFragments]
+		}
+		files[hash] = f.Fragments
+	}
+	a.Files = nil
+	log.Debugf("Peer %s announced %d files", peerKey(payload), len(files))
+	t.peers[key] = trackedPeer{a, files, time.Now().Add(t.ttl)}
+}
+
+// hostPeers returns the number of peers indexed for a host. The lock must be held
+func (t *Tracker) hostPeers(host string) int {
+	n := 0
+	for _, p := range t.peers {
+		if p.announcement.Addr == host {
+			n++
+		}
+	}
+	return n
+}
+
+// sameHost checks if two addresses are the same IP, i.e ::ffff:127.0.0.1 and 127.0.0.1
+func sameHost(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return a == b
+	}
+	return ipA.Equal(ipB)
+}
+
+// list replies with the peers of a file, or with all peers
+func (t *Tracker) list(w http.ResponseWriter, req *http.Request) {
+	hash := req.URL.Query().Get("hash")
+	if hash != "" {
+		var err error
+		if hash, err = NormalizeHash(hash); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 0
+	if l := req.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit %s", l), http.StatusBadRequest)
+			return
+		}
+	}
+	t.mu.Lock()
+	t.expire()
+	peers := []TrackerPeer{}
+	for _, p := range t.peers {
+		peer := TrackerPeer{Name: p.announcement.Name, Addr: p.announcement.Addr, Port: p.announcement.Port,
+			Transport: p.announcement.Transport}
+		if hash != "" {
+			fragments, ok := p.files[hash]
+			if !ok {
+				continue
+			}
+			peer.Fragments = fragments
+		}
+		peers = append(peers, peer)
+	}
+	t.mu.Unlock()
+	// Peers holding most of the file are tried first, peers holding as much are picked at random
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	sort.SliceStable(peers, func(i, j int) bool {
+		return len(peers[i].Fragments) > len(peers[j].Fragments)
+	})
+	if limit > 0 && len(peers) > limit {
+		peers = peers[:limit]
+	}
+	log.Debugf("Sending %d peers of file(hash=%s) to %s", len(peers), hash, req.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(peers); err != nil {
+		log.Errorf("Failed to write reply. Reason: %s", err)
+	}
+}
+
+// expire removes peers that didn't announce for the TTL. The lock must be held
+func (t *Tracker) expire() {
+	for key, p := range t.peers {
+		if time.Now().After(p.expires) {
+			log.Infof("Peer %s didn't announce for %s, removed", key, t.ttl)
+			delete(t.peers, key)
+		}
+	}
+}
+
+// intercept rejects requests of other methods, and requests from peers outside of the swarm
+func (t *Tracker) intercept(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if t.swarm != nil {
+			if err := t.swarm.Admit(req.Header.Get(TrackerSwarmHeader), SwarmResource(req), time.Now()); err != nil {
+				log.Warnf("Rejected %s from %s. Reason: %s", req.URL.Path, req.RemoteAddr, err)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		handler(w, req)
+	}
+}
+
+// TrackerResolver discovers peers with a tracker, and announces the files of this node to it while seeding
+type TrackerResolver struct {
+	// URL of the tracker, i.e http://tracker:7980
+	URL string
+	// Payload describes this node, it is announced while listening
+	Payload DiscoveryPayload
+	// FileHash limits discovery to the peers holding a file. When it isn't set up to MaxExchangedPeers peers picked at
+	// random are discovered, enough to exchange with peers
+	FileHash string
+	// Files returns the files announced while listening
+	Files         func() []TrackerFile
+	ClientFactory CreateClient
+	// SwarmKey is the pre-shared key of a private swarm, the tracker rejects requests without it
+	SwarmKey string
+	// AnnouncePeriod is how often files are announced, DefaultAnnouncePeriod is used when it isn't set
+	AnnouncePeriod time.Duration
+}
+
+// Discover asks the tracker for peers, and returns clients of the peers serving files with the same transport
+func (t TrackerResolver) Discover(ctx context.Context) ([]Client, error) {
+	query := fmt.Sprintf("?limit=%d", MaxExchangedPeers)
+	if t.FileHash != "" {
+		hash, err := NormalizeHash(t.FileHash)
+		if err != nil {
+			return nil, err
+		}
+		query = "?hash=" + url.QueryEscape(hash)
+	}
+	var peers []TrackerPeer
+	if err := t.request(ctx, http.MethodGet, "/peers"+query, nil, &peers); err != nil {
+		log.Errorf("Failed to discover with tracker %s. Reason: %s", t.URL, err)
+		return nil, err
+	}
+	var clients []Client
+	for _, peer := range peers {
+		payload := DiscoveryPayload{Name: peer.Name, Addr: peer.Addr, Port: peer.Port, Transport: peer.Transport}
+		// Clients only speak a single transport
+		if payload.transport() != t.Payload.transport() {
+			log.Debugf("Ignored %s, it serves files with %s", peerKey(payload), payload.transport())
+			continue
+		}
+		if payload.Name == t.Payload.Name && payload.Port == t.Payload.Port {
+			continue
+		}
+		log.Debugf("Connecting to %s", peerKey(payload))
+		client, err := t.ClientFactory(payload.Name, payload.Addr, payload.Port)
+		if err != nil {
+			log.Errorf("Failed to create client %s. Reason: %s", peerKey(payload), err)
+			continue
+		}
+		clients = append(clients, client)
+	}
+	if len(clients) == 0 {
+		return nil, errors.New("Didn't find any peers")
+	}
+	return clients, nil
+}
+
+// Listen announces the files of this node every announce period until ctx is done, then tells the tracker it stopped
+func (t TrackerResolver) Listen(ctx context.Context, address string) {
+	if t.Payload.Addr == "" {
+		t.Payload.Addr = address
+	}
+	period := t.AnnouncePeriod
+	if period <= 0 {
+		period = DefaultAnnouncePeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		if err := t.Announce(ctx, false); err != nil {
+			log.Warnf("Failed to announce to tracker %s. Reason: %s", t.URL, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			stopCtx, cancel := context.WithTimeout(context.Background(), trackerTimeout)
+			defer cancel()
+			if err := t.Announce(stopCtx, true); err != nil {
+				log.Debugf("Failed to tell tracker %s seeding stopped. Reason: %s", t.URL, err)
+			}
+			return
+		}
+	}
+}
+
+// Announce announces the files of this node to the tracker, or that it stopped seeding
+func (t TrackerResolver) Announce(ctx context.Context, stopped bool) error {
+	a := Announcement{Name: t.Payload.Name, Addr: t.Payload.Addr, Port: t.Payload.Port, Transport: t.Payload.transport(),
+		Stopped: stopped}
+	if !stopped && t.Files != nil {
+		a.Files = t.Files()
+	}
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Announcing %d files to tracker %s", len(a.Files), t.URL)
+	return t.request(ctx, http.MethodPost, "/announce", bytes.NewReader(body), nil)
+}
+
+// request sends a request to the tracker and decodes the JSON reply into v, unless it is nil
+func (t TrackerResolver) request(ctx context.Context, method, path string, body io.Reader, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, trackerTimeout)
+	defer cancel()
+	req, err := http.NewRequest(method, strings.TrimSuffix(t.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if t.SwarmKey != "" {
+		req.Header.Set(TrackerSwarmHeader, SwarmToken(t.SwarmKey, SwarmResource(req), time.Now()))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// TrackerFiles returns the files a seeding node announces to trackers. Corrupt files and files that aren't seeding or
+// partial unless allowed aren't announced, neither are files with access lists since peers that aren't allowed can't
+// tell they exist
+func TrackerFiles(fs afero.Fs, db *gorm.DB, seedPartial bool) []TrackerFile {
+	files := []TrackerFile{}
+	for _, f := range List(fs, db) {
+		if !served(f, seedPartial) {
+			continue
+		}
+		if len(Access(db, f.Hash)) > 0 {
+			continue
+		}
+		fragments := []int{}
+		for _, fragment := range f.AvailableFragments {
+			fragments = append(fragments, fragment.FragmentID)
+		}
+		files = append(files, TrackerFile{Hash: f.Hash, Fragments: fragments})
+	}
+	return files
+}
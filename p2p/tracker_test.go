@@ -0,0 +1,169 @@
+package p2p_test
+
+import (
+	"context"
+	"encoding/json"
+	"fileshare/p2p"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Hashes of files announced to trackers
+const (
+	trackedHash = "0123456789abcdef0123456789abcdef"
+	otherHash   = "fedcba9876543210fedcba9876543210"
+	unknownHash = "00000000000000000000000000000000"
+)
+
+// discovered returns the names of the clients discovered by a resolver, sorted
+func discovered(t *testing.T, r p2p.TrackerResolver) []string {
+	clients, err := r.Discover(context.Background())
+	var names []string
+	for _, c := range clients {
+		names = append(names, c.Name())
+	}
+	if err != nil {
+		assert.Empty(t, names)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Test peers announcing files are discovered per file, until they stop or their announcements expire
+func TestTracker(t *testing.T) {
+	tracker := p2p.NewTracker(200 * time.Millisecond)
+	server := httptest.NewServer(tracker.Handler())
+	defer server.Close()
+	full := []int{0, 1, 2, 3}
+	seeders := []p2p.TrackerResolver{
+		{Payload: p2p.DiscoveryPayload{Name: "a", Addr: "127.0.0.1", Port: 1},
+			Files: func() []p2p.TrackerFile {
+				return []p2p.TrackerFile{{Hash: strings.ToUpper(trackedHash), Fragments: []int{0}}}
+			}},
+		{Payload: p2p.DiscoveryPayload{Name: "b", Addr: "127.0.0.1", Port: 2},
+			Files: func() []p2p.TrackerFile {
+				return []p2p.TrackerFile{{Hash: trackedHash, Fragments: full}, {Hash: otherHash}}
+			}},
+		// Peers of another transport are indexed, but not discovered
+		{Payload: p2p.DiscoveryPayload{Name: "c", Addr: "127.0.0.1", Port: 3, Transport: "http"},
+			Files: func() []p2p.TrackerFile { return []p2p.TrackerFile{{Hash: trackedHash, Fragments: full}} }},
+	}
+	for _, s := range seeders {
+		s.URL = server.URL
+		assert.Nil(t, s.Announce(context.Background(), false))
+	}
+	r := p2p.TrackerResolver{URL: server.URL + "/", ClientFactory: factory}
+	assert.Equal(t, []string{"a@1", "b@2"}, discovered(t, r))
+	r.FileHash = "md5:" + trackedHash
+	assert.Equal(t, []string{"a@1", "b@2"}, discovered(t, r))
+	r.FileHash = otherHash
+	assert.Equal(t, []string{"b@2"}, discovered(t, r))
+	r.FileHash = unknownHash
+	assert.Empty(t, discovered(t, r))
+	r.FileHash = "not-a-hash"
+	assert.Empty(t, discovered(t, r))
+	// Stopped peers are removed right away
+	seeders[1].URL = server.URL
+	assert.Nil(t, seeders[1].Announce(context.Background(), true))
+	r.FileHash = trackedHash
+	assert.Equal(t, []string{"a@1"}, discovered(t, r))
+	// Peers that don't announce again expire
+	time.Sleep(300 * time.Millisecond)
+	assert.Empty(t, discovered(t, r))
+	// Invalid announcements are rejected
+	invalid := p2p.TrackerResolver{URL: server.URL, Payload: p2p.DiscoveryPayload{Name: "d", Addr: "127.0.0.1"}}
+	assert.NotNil(t, invalid.Announce(context.Background(), false))
+}
+
+// Test listening seeders announce until they stop, and are discovered at the address they announced from
+func TestTrackerResolverListen(t *testing.T) {
+	server := httptest.NewServer(p2p.NewTracker(0).Handler())
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	seeder := p2p.TrackerResolver{URL: server.URL, Payload: p2p.DiscoveryPayload{Name: "a", Port: 1},
+		AnnouncePeriod: 10 * time.Millisecond}
+	done := make(chan struct{})
+	go func() {
+		seeder.Listen(ctx, "0.0.0.0")
+		close(done)
+	}()
+	var addr string
+	r := p2p.TrackerResolver{URL: server.URL, ClientFactory: func(name, a string, port int) (p2p.Client, error) {
+		addr = a
+		return factory(name, a, port)
+	}}
+	assert.Eventually(t, func() bool {
+		clients, _ := r.Discover(context.Background())
+		return len(clients) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "127.0.0.1", addr)
+	// Seeders don't discover themselves
+	_, err := p2p.TrackerResolver{URL: server.URL, Payload: seeder.Payload, ClientFactory: factory}.Discover(context.Background())
+	assert.NotNil(t, err)
+	cancel()
+	<-done
+	_, err = r.Discover(context.Background())
+	assert.NotNil(t, err)
+}
+
+// Test a tracker of a private swarm only serves peers holding the swarm key
+func TestTrackerSwarmKey(t *testing.T) {
+	tracker := p2p.NewTracker(0)
+	tracker.SetSwarmKey("secret")
+	server := httptest.NewServer(tracker.Handler())
+	defer server.Close()
+	seeder := p2p.TrackerResolver{URL: server.URL, Payload: p2p.DiscoveryPayload{Name: "a", Addr: "127.0.0.1", Port: 1}}
+	assert.NotNil(t, seeder.Announce(context.Background(), false))
+	seeder.SwarmKey = "secret"
+	assert.Nil(t, seeder.Announce(context.Background(), false))
+	r := p2p.TrackerResolver{URL: server.URL, ClientFactory: factory, SwarmKey: "other"}
+	assert.Empty(t, discovered(t, r))
+	r.SwarmKey = "secret"
+	assert.Equal(t, []string{"a@1"}, discovered(t, r))
+}
+
+// Test peers are indexed at the address they announce from, hosts and announcements are bounded, and lists are limited
+func TestTrackerLimits(t *testing.T) {
+	server := httptest.NewServer(p2p.NewTracker(0).Handler())
+	defer server.Close()
+	ctx := context.Background()
+	// Peers can't announce other hosts
+	other := p2p.TrackerResolver{URL: server.URL, Payload: p2p.DiscoveryPayload{Name: "other", Addr: "10.0.0.9", Port: 1}}
+	assert.NotNil(t, other.Announce(ctx, false))
+	var files []p2p.TrackerFile
+	for i := 0; i < 1001; i++ {
+		files = append(files, p2p.TrackerFile{Hash: fmt.Sprintf("%032x", i)})
+	}
+	for port := 1; port <= 32; port++ {
+		seeder := p2p.TrackerResolver{URL: server.URL, Payload: p2p.DiscoveryPayload{Name: "a", Port: port},
+			Files: func() []p2p.TrackerFile { return files }}
+		assert.Nil(t, seeder.Announce(ctx, false))
+	}
+	// Hosts announce up to 32 peers
+	seeder := p2p.TrackerResolver{URL: server.URL, Payload: p2p.DiscoveryPayload{Name: "a", Port: 33}}
+	assert.NotNil(t, seeder.Announce(ctx, false))
+	// Peers announce up to 1000 files
+	r := p2p.TrackerResolver{URL: server.URL, ClientFactory: factory, FileHash: fmt.Sprintf("%032x", 999)}
+	assert.Len(t, discovered(t, r), 32)
+	r.FileHash = fmt.Sprintf("%032x", 1000)
+	assert.Empty(t, discovered(t, r))
+	for query, expected := range map[string]int{"": 32, "?limit=5": 5, "?limit=0": 32, "?limit=-1": -1, "?limit=x": -1} {
+		resp, err := http.Get(server.URL + "/peers" + query)
+		assert.Nil(t, err)
+		var peers []p2p.TrackerPeer
+		json.NewDecoder(resp.Body).Decode(&peers)
+		resp.Body.Close()
+		if expected < 0 {
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		} else {
+			assert.Len(t, peers, expected, query)
+		}
+	}
+}